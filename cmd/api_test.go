@@ -1,7 +1,11 @@
 package cmd
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/middlewares"
 	"github.com/alin-io/pkgstore/models"
 	"github.com/alin-io/pkgstore/services/npm"
 	"github.com/google/uuid"
@@ -112,3 +116,152 @@ func TestApiPackageDelete(t *testing.T) {
 	serverApp.ServeHTTP(w, req)
 	assert.Equal(t, 404, w.Code)
 }
+
+func TestApiDeletePermission(t *testing.T) {
+	UseAuthProvider(t, config.AuthProviderLocal)
+	user, plainToken, _ := CreateTestUser(t, models.TokenScopeRead, models.TokenScopePush)
+	deleteToken, _, _ := models.NewApiToken(user, "delete", []string{models.TokenScopeRead, models.TokenScopeDelete}, 0)
+	pkgName := user.Namespace + "/" + uuid.NewString()
+
+	w, req := UploadTestNpmPackage(pkgName, "0.0.1")
+	req.Header.Set("Authorization", "Bearer "+plainToken)
+	serverApp.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	pkg := models.Package[any]{Namespace: user.Namespace, Service: "npm"}
+	assert.Nil(t, pkg.FillByName(pkgName))
+	assert.NotEqual(t, uuid.Nil, pkg.ID)
+
+	t.Run("should reject deletes without the delete permission", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/api/packages/"+pkg.ID.String(), nil)
+		req.Header.Set("Authorization", "Bearer "+plainToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 403, w.Code)
+	})
+
+	t.Run("should delete with the delete permission", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/api/packages/"+pkg.ID.String(), nil)
+		req.Header.Set("Authorization", "Bearer "+deleteToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+	})
+}
+
+func TestApiDeleteActionWithAuthEndpoint(t *testing.T) {
+	actions := make([]string, 0)
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actions = append(actions, r.Header.Get("X-Package-Action"))
+		_ = json.NewEncoder(w).Encode(middlewares.AuthResult{AuthId: "remote", Namespace: "remote", Read: true})
+	}))
+	defer authServer.Close()
+
+	UseAuthProvider(t, config.AuthProviderEndpoint)
+	previousEndpoint := config.Get().AuthEndpoint
+	config.Get().AuthEndpoint = authServer.URL
+	t.Cleanup(func() {
+		config.Get().AuthEndpoint = previousEndpoint
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/api/packages/"+uuid.NewString(), nil)
+	req.Header.Set("Authorization", "Bearer "+uuid.NewString())
+	serverApp.ServeHTTP(w, req)
+	assert.Equal(t, 403, w.Code)
+	assert.Equal(t, []string{middlewares.PkgActionDelete}, actions)
+}
+
+func TestApiPackageGrants(t *testing.T) {
+	UseAuthProvider(t, config.AuthProviderLocal)
+	owner, ownerToken, _ := CreateTestUser(t, models.TokenScopeRead, models.TokenScopePush)
+	partner, partnerToken, _ := CreateTestUser(t, models.TokenScopeRead, models.TokenScopePush)
+	pkgBaseName := uuid.NewString()
+	pkgName := owner.Namespace + "/" + pkgBaseName
+
+	w, req, _ := UploadTestPypiPackage(pkgName, "0.0.1")
+	req.SetBasicAuth("__token__", ownerToken)
+	serverApp.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	pkg := models.Package[any]{Namespace: owner.Namespace, Service: "pypi"}
+	assert.Nil(t, pkg.FillByName(pkgName))
+
+	partnerPull := func() int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/pypi/simple/"+pkgName, nil)
+		req.SetBasicAuth("__token__", partnerToken)
+		serverApp.ServeHTTP(w, req)
+		return w.Code
+	}
+	partnerPublish := func(version string) int {
+		w, req, _ := UploadTestPypiPackage(pkgName, version)
+		req.SetBasicAuth("__token__", partnerToken)
+		serverApp.ServeHTTP(w, req)
+		return w.Code
+	}
+	createGrant := func(token, body string) (int, models.PackageGrant) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/packages/"+pkg.ID.String()+"/grants", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		serverApp.ServeHTTP(w, req)
+		grant := models.PackageGrant{}
+		_ = json.Unmarshal(w.Body.Bytes(), &grant)
+		return w.Code, grant
+	}
+
+	t.Run("should keep private packages to the owning namespace", func(t *testing.T) {
+		assert.Equal(t, 401, partnerPull())
+		status, _ := createGrant(partnerToken, `{"grantee_type": "namespace", "grantee": "`+partner.Namespace+`", "read": true}`)
+		assert.Equal(t, 404, status)
+	})
+
+	t.Run("should share the package with a namespace for reading", func(t *testing.T) {
+		status, grant := createGrant(ownerToken, `{"grantee_type": "namespace", "grantee": "`+partner.Namespace+`", "read": true}`)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, 200, partnerPull())
+		assert.Equal(t, 403, partnerPublish("0.0.2"))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/packages/%s/grants/%s", pkg.ID, grant.ID), nil)
+		req.Header.Set("Authorization", "Bearer "+partnerToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 404, w.Code)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/packages/%s/grants/%s", pkg.ID, grant.ID), nil)
+		req.Header.Set("Authorization", "Bearer "+ownerToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, 401, partnerPull())
+	})
+
+	t.Run("should refuse a second grant for the same grantee", func(t *testing.T) {
+		status, grant := createGrant(ownerToken, `{"grantee_type": "namespace", "grantee": "`+partner.Namespace+`", "read": true}`)
+		assert.Equal(t, 200, status)
+		status, _ = createGrant(ownerToken, `{"grantee_type": "namespace", "grantee": "`+partner.Namespace+`", "read": true, "publish": true}`)
+		assert.Equal(t, 409, status)
+		assert.Nil(t, grant.Delete())
+	})
+
+	t.Run("should let a user publish with a grant", func(t *testing.T) {
+		status, _ := createGrant(ownerToken, `{"grantee_type": "user", "grantee": "`+partner.Name+`", "read": true, "publish": true}`)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, 200, partnerPublish("0.0.2"))
+
+		version, err := pkg.Version("0.0.2")
+		assert.Nil(t, err)
+		assert.Equal(t, partner.Name, version.AuthId)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/packages/"+pkg.ID.String()+"/grants", nil)
+		req.Header.Set("Authorization", "Bearer "+ownerToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+		grants := make([]models.PackageGrant, 0)
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &grants))
+		assert.Len(t, grants, 1)
+	})
+
+	assert.Nil(t, DeleteTestPackage(pkgName, "pypi"))
+}
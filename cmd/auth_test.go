@@ -0,0 +1,784 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/middlewares"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/alin-io/pkgstore/services/container"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func CreateTestUser(t *testing.T, scopes ...string) (user *models.User, plainToken string, token *models.ApiToken) {
+	user = &models.User{
+		Name:      "user-" + uuid.NewString(),
+		Namespace: uuid.NewString()[:8],
+	}
+	err := user.Insert()
+	assert.Nil(t, err)
+
+	plainToken, token, err = models.NewApiToken(user, "test", scopes, time.Hour)
+	assert.Nil(t, err)
+	return user, plainToken, token
+}
+
+func UseAuthProvider(t *testing.T, provider string) {
+	previous := config.Get().Auth.Provider
+	config.Get().Auth.Provider = provider
+	t.Cleanup(func() {
+		config.Get().Auth.Provider = previous
+	})
+}
+
+func TestLocalAuthProvider(t *testing.T) {
+	UseAuthProvider(t, config.AuthProviderLocal)
+	user, plainToken, token := CreateTestUser(t, models.TokenScopeRead, models.TokenScopePush)
+	pkgName := user.Namespace + "/" + uuid.NewString()
+
+	t.Run("should reject requests without a token", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/"+pkgName, nil)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+	})
+
+	t.Run("should accept bearer tokens for npm", func(t *testing.T) {
+		w, req := UploadTestNpmPackage(pkgName, "0.0.1")
+		req.Header.Set("Authorization", "Bearer "+plainToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/npm/"+pkgName, nil)
+		req.Header.Set("Authorization", "Bearer "+plainToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+	})
+
+	t.Run("should accept basic auth tokens for pypi", func(t *testing.T) {
+		w, req, _ := UploadTestPypiPackage(pkgName, "0.0.1")
+		req.SetBasicAuth("__token__", plainToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+	})
+
+	t.Run("should reject pushes outside of the user namespace", func(t *testing.T) {
+		w, req := UploadTestNpmPackage(uuid.NewString()+"/"+uuid.NewString(), "0.0.1")
+		req.Header.Set("Authorization", "Bearer "+plainToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 403, w.Code)
+	})
+
+	t.Run("should reject pushes with a read only token", func(t *testing.T) {
+		readToken, _, _ := models.NewApiToken(user, "read-only", []string{models.TokenScopeRead}, 0)
+		w, req := UploadTestNpmPackage(pkgName, "0.0.2")
+		req.Header.Set("Authorization", "Bearer "+readToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 403, w.Code)
+	})
+
+	t.Run("should reject revoked tokens", func(t *testing.T) {
+		assert.Nil(t, token.Revoke())
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/"+pkgName, nil)
+		req.Header.Set("Authorization", "Bearer "+plainToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+	})
+
+	assert.Nil(t, DeleteTestPackage(pkgName, "npm"))
+	assert.Nil(t, DeleteTestPackage(pkgName, "pypi"))
+}
+
+func UseTestJwks(t *testing.T) *rsa.PrivateKey {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	assert.Nil(t, os.WriteFile(jwksFile, JwksTestDocument(privateKey), 0600))
+
+	previous := config.Get().Auth.Jwt
+	config.Get().Auth.Jwt.JwksFile = jwksFile
+	config.Get().Auth.Jwt.Issuer = "https://sso.example.com"
+	config.Get().Auth.Jwt.Audience = "pkgstore"
+	config.Get().Auth.Jwt.Claims.Write = "scope=registry:push"
+	t.Cleanup(func() {
+		config.Get().Auth.Jwt = previous
+	})
+	return privateKey
+}
+
+func JwksTestDocument(privateKey *rsa.PrivateKey) []byte {
+	jwks, _ := json.Marshal(gin.H{
+		"keys": []gin.H{{
+			"kid": "test",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(privateKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(privateKey.E)).Bytes()),
+		}},
+	})
+	return jwks
+}
+
+func SignTestJwt(t *testing.T, privateKey *rsa.PrivateKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString(privateKey)
+	assert.Nil(t, err)
+	return signed
+}
+
+func TestJwtAuthProvider(t *testing.T) {
+	UseAuthProvider(t, config.AuthProviderJwt)
+	privateKey := UseTestJwks(t)
+	namespace := uuid.NewString()[:8]
+	pkgName := namespace + "/" + uuid.NewString()
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub":       "alice",
+			"iss":       "https://sso.example.com",
+			"aud":       "pkgstore",
+			"exp":       time.Now().Add(time.Minute).Unix(),
+			"namespace": namespace,
+			"read":      true,
+			"scope":     "openid registry:push",
+		}
+	}
+
+	t.Run("should accept a valid token and map the claims", func(t *testing.T) {
+		w, req := UploadTestNpmPackage(pkgName, "0.0.1")
+		req.Header.Set("Authorization", "Bearer "+SignTestJwt(t, privateKey, validClaims()))
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		pkg := models.Package[any]{Namespace: namespace, Service: "npm"}
+		assert.Nil(t, pkg.FillByName(pkgName))
+		assert.Equal(t, "alice", pkg.AuthId)
+	})
+
+	t.Run("should reject tokens without the push permission", func(t *testing.T) {
+		claims := validClaims()
+		claims["scope"] = "openid"
+		w, req := UploadTestNpmPackage(pkgName, "0.0.2")
+		req.Header.Set("Authorization", "Bearer "+SignTestJwt(t, privateKey, claims))
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 403, w.Code)
+	})
+
+	for name, mutate := range map[string]func(claims jwt.MapClaims){
+		"expired token":  func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"wrong issuer":   func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" },
+		"wrong audience": func(claims jwt.MapClaims) { claims["aud"] = "other" },
+	} {
+		t.Run("should reject "+name, func(t *testing.T) {
+			claims := validClaims()
+			mutate(claims)
+			w, req := UploadTestNpmPackage(pkgName, "0.0.2")
+			req.Header.Set("Authorization", "Bearer "+SignTestJwt(t, privateKey, claims))
+			serverApp.ServeHTTP(w, req)
+			assert.Equal(t, 401, w.Code)
+		})
+	}
+
+	t.Run("should reject tokens signed by an unknown key", func(t *testing.T) {
+		otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/"+pkgName, nil)
+		req.Header.Set("Authorization", "Bearer "+SignTestJwt(t, otherKey, validClaims()))
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+	})
+
+	t.Run("should verify with the cached keys while the JWKS URL hangs", func(t *testing.T) {
+		var jwksRequests atomic.Int32
+		release := make(chan struct{})
+		jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if jwksRequests.Add(1) > 1 {
+				<-release
+			}
+			_, _ = w.Write(JwksTestDocument(privateKey))
+		}))
+		defer jwksServer.Close()
+		defer close(release)
+
+		config.Get().Auth.Jwt.JwksFile = ""
+		config.Get().Auth.Jwt.JwksUrl = jwksServer.URL
+		config.Get().Auth.Jwt.JwksRefreshInterval = time.Millisecond
+		for i := 0; i < 2; i++ {
+			time.Sleep(2 * time.Millisecond)
+			start := time.Now()
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/npm/"+pkgName, nil)
+			req.Header.Set("Authorization", "Bearer "+SignTestJwt(t, privateKey, validClaims()))
+			serverApp.ServeHTTP(w, req)
+			assert.Equal(t, 200, w.Code)
+			assert.Less(t, time.Since(start), time.Second)
+		}
+		assert.Eventually(t, func() bool { return jwksRequests.Load() == 2 }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("should follow the key rotations of the JWKS URL", func(t *testing.T) {
+		rotatedKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+		var rotated atomic.Bool
+		jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rotated.Load() {
+				_, _ = w.Write(bytes.Replace(JwksTestDocument(rotatedKey), []byte(`"kid":"test"`), []byte(`"kid":"rotated"`), 1))
+				return
+			}
+			_, _ = w.Write(JwksTestDocument(privateKey))
+		}))
+		defer jwksServer.Close()
+
+		config.Get().Auth.Jwt.JwksFile = ""
+		config.Get().Auth.Jwt.JwksUrl = jwksServer.URL
+		config.Get().Auth.Jwt.JwksRefreshInterval = time.Millisecond
+		pull := func(token string) int {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/npm/"+pkgName, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			serverApp.ServeHTTP(w, req)
+			return w.Code
+		}
+		rotatedToken := jwt.NewWithClaims(jwt.SigningMethodRS256, validClaims())
+		rotatedToken.Header["kid"] = "rotated"
+		signedRotatedToken, err := rotatedToken.SignedString(rotatedKey)
+		assert.Nil(t, err)
+
+		assert.Equal(t, 200, pull(SignTestJwt(t, privateKey, validClaims())))
+		assert.Equal(t, 401, pull(signedRotatedToken))
+
+		// The unknown key of the new token fetches the document again, which drops the removed key
+		rotated.Store(true)
+		time.Sleep(2 * time.Millisecond)
+		assert.Equal(t, 200, pull(signedRotatedToken))
+		assert.Equal(t, 401, pull(SignTestJwt(t, privateKey, validClaims())))
+	})
+
+	assert.Nil(t, DeleteTestPackage(pkgName, "npm"))
+}
+
+func TestTrustedPublishing(t *testing.T) {
+	UseAuthProvider(t, config.AuthProviderLocal)
+	user, plainToken, _ := CreateTestUser(t, models.TokenScopeRead, models.TokenScopePush)
+	pkgName := user.Namespace + "/" + uuid.NewString()
+
+	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	issuerServer := httptest.NewServer(nil)
+	defer issuerServer.Close()
+	issuerServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/.well-known/openid-configuration" {
+			_ = json.NewEncoder(w).Encode(gin.H{"issuer": issuerServer.URL, "jwks_uri": issuerServer.URL + "/jwks"})
+		} else {
+			_, _ = w.Write(JwksTestDocument(privateKey))
+		}
+	})
+
+	previous := config.Get().Auth.Oidc
+	config.Get().Auth.Oidc.Issuers = []string{issuerServer.URL}
+	config.Get().Auth.Oidc.Audience = "pkgstore"
+	t.Cleanup(func() {
+		config.Get().Auth.Oidc = previous
+	})
+
+	w := httptest.NewRecorder()
+	body, _ := json.Marshal(gin.H{
+		"service":      "npm",
+		"package_name": pkgName,
+		"issuer":       issuerServer.URL,
+		"repository":   "acme/app",
+		"workflow":     "release.yml",
+		"branch":       "main",
+	})
+	req, _ := http.NewRequest("POST", "/api/trusted-publishers", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+plainToken)
+	serverApp.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	exchange := func(claims jwt.MapClaims) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body, _ := json.Marshal(gin.H{"token": SignTestJwt(t, privateKey, claims), "service": "npm", "package": pkgName})
+		req, _ := http.NewRequest("POST", "/api/oidc/token", bytes.NewReader(body))
+		serverApp.ServeHTTP(w, req)
+		return w
+	}
+	ciClaims := func(ref string) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":          issuerServer.URL,
+			"aud":          "pkgstore",
+			"sub":          "repo:acme/app:ref:" + ref,
+			"exp":          time.Now().Add(time.Minute).Unix(),
+			"repository":   "acme/app",
+			"workflow_ref": "acme/app/.github/workflows/release.yml@" + ref,
+			"ref":          ref,
+			"ref_type":     "branch",
+		}
+	}
+
+	t.Run("should reject identity tokens that don't match the rules", func(t *testing.T) {
+		w := exchange(ciClaims("refs/heads/feature"))
+		assert.Equal(t, 403, w.Code)
+	})
+
+	t.Run("should publish with the exchanged token", func(t *testing.T) {
+		w := exchange(ciClaims("refs/heads/main"))
+		assert.Equal(t, 200, w.Code)
+		result := struct {
+			Token string `json:"token"`
+		}{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &result))
+
+		w, req := UploadTestNpmPackage(pkgName, "0.0.1")
+		req.Header.Set("Authorization", "Bearer "+result.Token)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		otherPkgName := user.Namespace + "/" + uuid.NewString()
+		w, req = UploadTestNpmPackage(otherPkgName, "0.0.1")
+		req.Header.Set("Authorization", "Bearer "+result.Token)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("PUT", "/npm/"+pkgName, NpmPackageDataReader(otherPkgName, "0.0.1"))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+result.Token)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 400, w.Code)
+
+		otherPkg := models.Package[any]{Namespace: user.Namespace, Service: "npm"}
+		assert.Nil(t, otherPkg.FillByName(otherPkgName))
+		assert.Equal(t, uuid.Nil, otherPkg.ID)
+	})
+
+	t.Run("should stop issuing tokens for a disabled owner", func(t *testing.T) {
+		user.Disabled = true
+		assert.Nil(t, user.Save())
+		t.Cleanup(func() {
+			user.Disabled = false
+			assert.Nil(t, user.Save())
+		})
+		w := exchange(ciClaims("refs/heads/main"))
+		assert.Equal(t, 403, w.Code)
+	})
+
+	t.Run("should refuse the publishers of an untrusted issuer", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		body, _ := json.Marshal(gin.H{
+			"service":      "npm",
+			"package_name": pkgName,
+			"issuer":       "https://issuer.example.com",
+			"repository":   "acme/app",
+		})
+		req, _ := http.NewRequest("POST", "/api/trusted-publishers", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+plainToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 400, w.Code)
+	})
+
+	assert.Nil(t, DeleteTestPackage(pkgName, "npm"))
+}
+
+func UseTestHtpasswd(t *testing.T, htpasswdLines, mappingLines string) (htpasswdFile, mappingFile string) {
+	dir := t.TempDir()
+	htpasswdFile = filepath.Join(dir, "htpasswd")
+	mappingFile = filepath.Join(dir, "htpasswd-mapping")
+	assert.Nil(t, os.WriteFile(htpasswdFile, []byte(htpasswdLines), 0600))
+	assert.Nil(t, os.WriteFile(mappingFile, []byte(mappingLines), 0600))
+
+	previous := config.Get().Auth.Htpasswd
+	config.Get().Auth.Htpasswd.File = htpasswdFile
+	config.Get().Auth.Htpasswd.MappingFile = mappingFile
+	t.Cleanup(func() {
+		config.Get().Auth.Htpasswd = previous
+	})
+	UseAuthProvider(t, config.AuthProviderHtpasswd)
+	return htpasswdFile, mappingFile
+}
+
+func HtpasswdTestLine(t *testing.T, username, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	assert.Nil(t, err)
+	return username + ":" + string(hash) + "\n"
+}
+
+func TestHtpasswdAuthProvider(t *testing.T) {
+	namespace := uuid.NewString()[:8]
+	pkgName := namespace + "/" + uuid.NewString()
+	htpasswdFile, mappingFile := UseTestHtpasswd(t,
+		HtpasswdTestLine(t, "alice", "alice-password")+HtpasswdTestLine(t, "bob", "bob-password"),
+		"# user:namespace:permissions\nalice:"+namespace+":read,push,delete\nbob:"+namespace+":read\n",
+	)
+
+	t.Run("should accept basic auth for npm and pypi", func(t *testing.T) {
+		w, req := UploadTestNpmPackage(pkgName, "0.0.1")
+		req.SetBasicAuth("alice", "alice-password")
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		w, req, _ = UploadTestPypiPackage(pkgName, "0.0.1")
+		req.SetBasicAuth("alice", "alice-password")
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+	})
+
+	t.Run("should reject a wrong password", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/"+pkgName, nil)
+		req.SetBasicAuth("alice", "bob-password")
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+	})
+
+	t.Run("should apply the permissions of the mapping file", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/"+pkgName, nil)
+		req.SetBasicAuth("bob", "bob-password")
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		w, req = UploadTestNpmPackage(pkgName, "0.0.2")
+		req.SetBasicAuth("bob", "bob-password")
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 403, w.Code)
+	})
+
+	t.Run("should issue docker registry tokens", func(t *testing.T) {
+		status, response := RequestContainerToken(t, "alice", "alice-password", url.Values{
+			"scope": {"repository:" + pkgName + ":pull,push"},
+		})
+		assert.Equal(t, 200, status)
+		assert.NotEmpty(t, response.Token)
+	})
+
+	t.Run("should reload the files on change", func(t *testing.T) {
+		htpasswdLines, err := os.ReadFile(htpasswdFile)
+		assert.Nil(t, err)
+		htpasswdLines = append(htpasswdLines, HtpasswdTestLine(t, "carol", "carol-password")...)
+		assert.Nil(t, os.WriteFile(htpasswdFile, htpasswdLines, 0600))
+		mappingLines, err := os.ReadFile(mappingFile)
+		assert.Nil(t, err)
+		mappingLines = append(mappingLines, "carol:"+namespace+":read\n"...)
+		assert.Nil(t, os.WriteFile(mappingFile, mappingLines, 0600))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/"+pkgName, nil)
+		req.SetBasicAuth("carol", "carol-password")
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+	})
+
+	assert.Nil(t, DeleteTestPackage(pkgName, "npm"))
+	assert.Nil(t, DeleteTestPackage(pkgName, "pypi"))
+}
+
+func UseTestMtlsRules(t *testing.T, rules ...string) {
+	previous := config.Get().Auth.Mtls.Rules
+	config.Get().Auth.Mtls.Rules = rules
+	assert.Nil(t, middlewares.LoadMtlsRules())
+	t.Cleanup(func() {
+		config.Get().Auth.Mtls.Rules = previous
+		assert.Nil(t, middlewares.LoadMtlsRules())
+	})
+}
+
+func WithTestClientCert(req *http.Request, commonName string, dnsNames ...string) *http.Request {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}, DNSNames: dnsNames}
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	return req
+}
+
+func TestMtlsClientCertificate(t *testing.T) {
+	namespace := uuid.NewString()[:8]
+	pkgName := namespace + "/" + uuid.NewString()
+	UseAuthProvider(t, config.AuthProviderMtls)
+	UseTestMtlsRules(t,
+		"dns=*.build.example.com:"+namespace+":read,push",
+		"cn=auditor:"+namespace+":read",
+	)
+
+	t.Run("should reject requests without a client certificate", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/"+pkgName, nil)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+	})
+
+	t.Run("should publish with a certificate matching a SAN rule", func(t *testing.T) {
+		w, req := UploadTestNpmPackage(pkgName, "0.0.1")
+		serverApp.ServeHTTP(w, WithTestClientCert(req, "runner-1", "runner-1.build.example.com"))
+		assert.Equal(t, 200, w.Code)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/v2/", nil)
+		serverApp.ServeHTTP(w, WithTestClientCert(req, "runner-1", "runner-1.build.example.com"))
+		assert.Equal(t, 200, w.Code)
+	})
+
+	t.Run("should apply the permissions of the matching rule", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/"+pkgName, nil)
+		serverApp.ServeHTTP(w, WithTestClientCert(req, "auditor"))
+		assert.Equal(t, 200, w.Code)
+
+		w, req = UploadTestNpmPackage(pkgName, "0.0.2")
+		serverApp.ServeHTTP(w, WithTestClientCert(req, "auditor"))
+		assert.Equal(t, 403, w.Code)
+	})
+
+	t.Run("should reject certificates without a matching rule", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/"+pkgName, nil)
+		serverApp.ServeHTTP(w, WithTestClientCert(req, "laptop", "laptop.example.com"))
+		assert.Equal(t, 401, w.Code)
+	})
+
+	t.Run("should fail loading invalid rules and keep the loaded ones", func(t *testing.T) {
+		rules := config.Get().Auth.Mtls.Rules
+		config.Get().Auth.Mtls.Rules = []string{"serial=1234:" + namespace + ":read"}
+		assert.NotNil(t, middlewares.LoadMtlsRules())
+		config.Get().Auth.Mtls.Rules = rules
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/"+pkgName, nil)
+		serverApp.ServeHTTP(w, WithTestClientCert(req, "auditor"))
+		assert.Equal(t, 200, w.Code)
+	})
+
+	assert.Nil(t, DeleteTestPackage(pkgName, "npm"))
+}
+
+func UseTestAuthEndpoint(t *testing.T, handler http.HandlerFunc) {
+	authServer := httptest.NewServer(handler)
+	t.Cleanup(authServer.Close)
+
+	UseAuthProvider(t, config.AuthProviderEndpoint)
+	previousEndpoint := config.Get().AuthEndpoint
+	previousEndpointConfig := config.Get().Auth.Endpoint
+	config.Get().AuthEndpoint = authServer.URL
+	t.Cleanup(func() {
+		config.Get().AuthEndpoint = previousEndpoint
+		config.Get().Auth.Endpoint = previousEndpointConfig
+	})
+}
+
+func TestAuthEndpointClient(t *testing.T) {
+	var calls, failing atomic.Int32
+	UseTestAuthEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		if failing.Load() == 1 {
+			w.WriteHeader(500)
+			return
+		}
+		if r.Header.Get("Authorization") == "denied" {
+			w.WriteHeader(401)
+			_ = json.NewEncoder(w).Encode(gin.H{"error": "invalid token"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(middlewares.AuthResult{AuthId: "remote", Namespace: "remote", Read: true})
+	})
+
+	requestStats := func(token string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/stats", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		serverApp.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("should share a single auth call between parallel requests", func(t *testing.T) {
+		calls.Store(0)
+		token := uuid.NewString()
+		wg := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.Equal(t, 200, requestStats(token))
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), calls.Load())
+
+		metrics := expvar.Get("auth_endpoint").(*expvar.Map)
+		assert.NotNil(t, metrics.Get("shared_requests"))
+		assert.NotNil(t, metrics.Get("latency_ms_total"))
+	})
+
+	t.Run("should hand out copies of the cached answers", func(t *testing.T) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		token := uuid.NewString()
+		first, err := middlewares.ActiveAuthProvider().Authenticate(c, "", token, "npm", middlewares.PkgActionPull)
+		assert.Nil(t, err)
+		first.Namespace = "other"
+		first.Write = true
+
+		second, err := middlewares.ActiveAuthProvider().Authenticate(c, "", token, "npm", middlewares.PkgActionPull)
+		assert.Nil(t, err)
+		assert.Equal(t, "remote", second.Namespace)
+		assert.False(t, second.Write)
+	})
+
+	t.Run("should cache denials", func(t *testing.T) {
+		calls.Store(0)
+		assert.Equal(t, 401, requestStats("denied"))
+		assert.Equal(t, 401, requestStats("denied"))
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("should answer pulls from the stale cache while the endpoint fails", func(t *testing.T) {
+		config.Get().Auth.Endpoint.CacheTTL = time.Millisecond
+		config.Get().Auth.Endpoint.StaleIfError = time.Minute
+		token := uuid.NewString()
+		assert.Equal(t, 200, requestStats(token))
+
+		failing.Store(1)
+		t.Cleanup(func() {
+			failing.Store(0)
+		})
+		time.Sleep(5 * time.Millisecond)
+		assert.Equal(t, 200, requestStats(token))
+
+		config.Get().Auth.Endpoint.StaleIfError = 0
+		assert.Equal(t, 401, requestStats(token))
+	})
+}
+
+func TestPublicPackageAccess(t *testing.T) {
+	UseAuthProvider(t, config.AuthProviderLocal)
+	user, plainToken, _ := CreateTestUser(t, models.TokenScopeRead, models.TokenScopePush)
+	pkgBaseName := uuid.NewString()
+	pkgName := user.Namespace + "/" + pkgBaseName
+
+	w, req := UploadTestNpmPackage(pkgName, "0.0.1")
+	req.Header.Set("Authorization", "Bearer "+plainToken)
+	serverApp.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	w, req, digest := UploadTestPypiPackage(pkgName, "0.0.1")
+	req.SetBasicAuth("__token__", plainToken)
+	serverApp.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	deleteToken, _, err := models.NewApiToken(user, "delete", models.AllTokenScopes, time.Hour)
+	assert.Nil(t, err)
+
+	anonymousPull := func(path string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		serverApp.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("should ask anonymous clients for credentials on private packages", func(t *testing.T) {
+		assert.Equal(t, 401, anonymousPull("/npm/"+pkgName))
+		assert.Equal(t, 401, anonymousPull("/pypi/simple/"+pkgName))
+	})
+
+	t.Run("should toggle the visibility with npm access", func(t *testing.T) {
+		setAccess := func(token string) int {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/npm/-/package/@"+pkgName+"/access", bytes.NewBufferString(`{"access": "public"}`))
+			req.Header.Set("Authorization", "Bearer "+token)
+			serverApp.ServeHTTP(w, req)
+			return w.Code
+		}
+		assert.Equal(t, 403, setAccess(plainToken))
+		assert.Equal(t, 200, setAccess(deleteToken))
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/npm/-/package/@"+pkgName+"/visibility", nil)
+		req.Header.Set("Authorization", "Bearer "+plainToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+		assert.JSONEq(t, `{"public": true}`, w.Body.String())
+	})
+
+	t.Run("should let anonymous clients pull public packages", func(t *testing.T) {
+		assert.Equal(t, 200, anonymousPull("/npm/"+pkgName))
+		assert.Equal(t, 200, anonymousPull(fmt.Sprintf("/npm/%s/-/%s-0.0.1.tgz", pkgName, pkgBaseName)))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/npm/"+pkgName, NpmPackageDataReader(pkgName, "0.0.2"))
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+	})
+
+	t.Run("should toggle the visibility with the API", func(t *testing.T) {
+		pkg := models.Package[any]{Namespace: user.Namespace, Service: "pypi"}
+		assert.Nil(t, pkg.FillByName(pkgName))
+
+		setVisibility := func(token string) int {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("PUT", "/api/packages/"+pkg.ID.String()+"/visibility", bytes.NewBufferString(`{"public": true}`))
+			req.Header.Set("Authorization", "Bearer "+token)
+			serverApp.ServeHTTP(w, req)
+			return w.Code
+		}
+		assert.Equal(t, 403, setVisibility(plainToken))
+		assert.Equal(t, 200, setVisibility(deleteToken))
+
+		w := httptest.NewRecorder()
+
+		req, _ := http.NewRequest("GET", "/pypi/simple/"+pkgName, nil)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), "?namespace="+user.Namespace)
+		assert.Equal(t, 200, anonymousPull(fmt.Sprintf("/pypi/files/%s/%s-0.0.1.tar.gz?namespace=%s", digest, pkgBaseName, user.Namespace)))
+	})
+
+	t.Run("should issue anonymous pull tokens for public images", func(t *testing.T) {
+		imageName := user.Namespace + "/" + uuid.NewString()
+		image := models.Package[any]{Name: imageName, Namespace: user.Namespace, Service: "container", AuthId: user.Name, IsPublic: true}
+		assert.Nil(t, image.Insert())
+		t.Cleanup(func() {
+			_ = image.Delete()
+		})
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v2/token?scope=repository:"+imageName+":pull,push", nil)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+		response := container.TokenResponse{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/v2/"+imageName+"/manifests/latest", nil)
+		req.Header.Set("Authorization", "Bearer "+response.Token)
+		serverApp.ServeHTTP(w, req)
+		assert.NotEqual(t, 401, w.Code)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/v2/"+imageName+"/blobs/uploads/", nil)
+		req.Header.Set("Authorization", "Bearer "+response.Token)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+	})
+
+	assert.Nil(t, DeleteTestPackage(pkgName, "npm"))
+	assert.Nil(t, DeleteTestPackage(pkgName, "pypi"))
+}
@@ -3,11 +3,17 @@ package cmd
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/alin-io/pkgstore/services/container"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -90,3 +96,129 @@ func ContainerManifestReader(layerDigest string, size int) *bytes.Buffer {
 }
 `, layerDigest, size)))
 }
+
+func RequestContainerToken(t *testing.T, username, password string, query url.Values) (int, container.TokenResponse) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v2/token?"+query.Encode(), nil)
+	req.SetBasicAuth(username, password)
+	serverApp.ServeHTTP(w, req)
+	result := container.TokenResponse{}
+	_ = json.Unmarshal(w.Body.Bytes(), &result)
+	return w.Code, result
+}
+
+func TestContainerTokenAuth(t *testing.T) {
+	UseAuthProvider(t, config.AuthProviderLocal)
+	user, plainToken, _ := CreateTestUser(t, models.TokenScopeRead, models.TokenScopePush)
+	name := user.Namespace + "/" + uuid.NewString()
+
+	t.Run("should advertise the token realm and the required scope", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v2/", nil)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), fmt.Sprintf(`realm="%s/token"`, config.Get().RegistryHosts.Container))
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/v2/"+name+"/blobs/uploads/", nil)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), fmt.Sprintf(`scope="repository:%s:pull,push"`, name))
+	})
+
+	t.Run("should reject invalid credentials", func(t *testing.T) {
+		code, _ := RequestContainerToken(t, user.Name, "pks_wrong", url.Values{})
+		assert.Equal(t, 401, code)
+	})
+
+	t.Run("should issue scoped tokens that authorize registry requests", func(t *testing.T) {
+		code, token := RequestContainerToken(t, user.Name, plainToken, url.Values{
+			"service": {"registry"},
+			"scope":   {"repository:" + name + ":pull,push"},
+		})
+		assert.Equal(t, 200, code)
+		assert.NotEmpty(t, token.Token)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v2/", nil)
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/v2/"+name+"/blobs/uploads/", nil)
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 202, w.Code)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/v2/"+user.Namespace+"/other/blobs/uploads/", nil)
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+	})
+
+	t.Run("should not grant scopes outside of the user namespace", func(t *testing.T) {
+		otherName := uuid.NewString() + "/image"
+		code, token := RequestContainerToken(t, user.Name, plainToken, url.Values{
+			"scope": {"repository:" + otherName + ":pull,push"},
+		})
+		assert.Equal(t, 200, code)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v2/"+otherName+"/blobs/uploads/", nil)
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+	})
+
+	refresh := func(refreshToken string) (int, container.TokenResponse) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v2/token", strings.NewReader(url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {refreshToken},
+			"scope":         {"repository:" + name + ":pull"},
+		}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		serverApp.ServeHTTP(w, req)
+		refreshed := container.TokenResponse{}
+		_ = json.Unmarshal(w.Body.Bytes(), &refreshed)
+		return w.Code, refreshed
+	}
+
+	t.Run("should exchange refresh tokens", func(t *testing.T) {
+		code, token := RequestContainerToken(t, user.Name, plainToken, url.Values{"offline_token": {"true"}})
+		assert.Equal(t, 200, code)
+		assert.NotEmpty(t, token.RefreshToken)
+
+		code, refreshed := refresh(token.RefreshToken)
+		assert.Equal(t, 200, code)
+		assert.NotEmpty(t, refreshed.RefreshToken)
+		assert.NotEqual(t, token.RefreshToken, refreshed.RefreshToken)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("HEAD", "/v2/"+name+"/manifests/latest", nil)
+		req.Header.Set("Authorization", "Bearer "+refreshed.AccessToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 404, w.Code)
+
+		code, _ = refresh(token.RefreshToken)
+		assert.Equal(t, 401, code)
+	})
+
+	t.Run("should reject the refresh tokens of revoked credentials", func(t *testing.T) {
+		otherUser, otherPlainToken, otherToken := CreateTestUser(t, models.TokenScopeRead)
+		code, token := RequestContainerToken(t, otherUser.Name, otherPlainToken, url.Values{"offline_token": {"true"}})
+		assert.Equal(t, 200, code)
+		assert.Nil(t, otherToken.Revoke())
+		code, _ = refresh(token.RefreshToken)
+		assert.Equal(t, 401, code)
+
+		code, token = RequestContainerToken(t, user.Name, plainToken, url.Values{"offline_token": {"true"}})
+		assert.Equal(t, 200, code)
+		user.Disabled = true
+		assert.Nil(t, user.Save())
+		code, _ = refresh(token.RefreshToken)
+		assert.Equal(t, 401, code)
+	})
+}
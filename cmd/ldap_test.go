@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/go-asn1-ber/asn1-ber"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestLdapEntry is an entry of the in-process LDAP server
+type TestLdapEntry struct {
+	Dn         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// TestLdapServer is a minimal LDAP server stand-in, it answers the simple binds
+// and the searches with equality, presence, and, or and not filters
+type TestLdapServer struct {
+	Entries []TestLdapEntry
+	Binds   atomic.Int32
+}
+
+func UseTestLdapServer(t *testing.T, entries []TestLdapEntry) *TestLdapServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := &TestLdapServer{Entries: entries}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	previous := config.Get().Auth.Ldap
+	config.Get().Auth.Ldap.Url = "ldap://" + listener.Addr().String()
+	t.Cleanup(func() {
+		config.Get().Auth.Ldap = previous
+		_ = listener.Close()
+	})
+	return server
+}
+
+func (s *TestLdapServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageId := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+
+		switch request.Tag {
+		case 0: // BindRequest
+			s.Binds.Add(1)
+			dn := request.Children[1].Value.(string)
+			password := request.Children[2].Data.String()
+			resultCode := int64(49)
+			for _, entry := range s.Entries {
+				if strings.EqualFold(entry.Dn, dn) && entry.Password == password {
+					resultCode = 0
+				}
+			}
+			_, _ = conn.Write(testLdapResult(messageId, 1, resultCode).Bytes())
+		case 3: // SearchRequest
+			baseDn := strings.ToLower(request.Children[0].Value.(string))
+			scope := request.Children[1].Value.(int64)
+			found := false
+			for _, entry := range s.Entries {
+				dn := strings.ToLower(entry.Dn)
+				// The parent entries of the test entries exist implicitly
+				found = found || strings.HasSuffix(dn, baseDn)
+				if (scope == 0 && dn != baseDn) || !strings.HasSuffix(dn, baseDn) || !testLdapFilterMatch(request.Children[6], entry) {
+					continue
+				}
+				_, _ = conn.Write(testLdapSearchEntry(messageId, entry).Bytes())
+			}
+			resultCode := int64(0)
+			if !found {
+				resultCode = 32
+			}
+			_, _ = conn.Write(testLdapResult(messageId, 5, resultCode).Bytes())
+		default:
+			return
+		}
+	}
+}
+
+func testLdapFilterMatch(filter *ber.Packet, entry TestLdapEntry) bool {
+	switch filter.Tag {
+	case 0, 1: // and, or
+		for _, child := range filter.Children {
+			if testLdapFilterMatch(child, entry) != (filter.Tag == 0) {
+				return filter.Tag != 0
+			}
+		}
+		return filter.Tag == 0
+	case 2: // not
+		return !testLdapFilterMatch(filter.Children[0], entry)
+	case 3: // equalityMatch
+		attribute, value := filter.Children[0].Data.String(), filter.Children[1].Data.String()
+		for name, values := range entry.Attributes {
+			for _, entryValue := range values {
+				if strings.EqualFold(name, attribute) && strings.EqualFold(entryValue, value) {
+					return true
+				}
+			}
+		}
+		return false
+	case 7: // present
+		return strings.EqualFold(filter.Data.String(), "objectClass") || len(entry.Attributes[filter.Data.String()]) > 0
+	}
+	return false
+}
+
+func testLdapEnvelope(messageId int64, response *ber.Packet) *ber.Packet {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageId, "Message ID"))
+	envelope.AppendChild(response)
+	return envelope
+}
+
+func testLdapResult(messageId int64, tag ber.Tag, resultCode int64) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, resultCode, "Result Code"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return testLdapEnvelope(messageId, response)
+}
+
+func testLdapSearchEntry(messageId int64, entry TestLdapEntry) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 4, nil, "Search Result Entry")
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.Dn, "Object Name"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range entry.Attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		valueSet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			valueSet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(valueSet)
+		attributes.AppendChild(attribute)
+	}
+	response.AppendChild(attributes)
+	return testLdapEnvelope(messageId, response)
+}
+
+func TestLdapAuthProvider(t *testing.T) {
+	UseAuthProvider(t, config.AuthProviderLdap)
+	server := UseTestLdapServer(t, []TestLdapEntry{
+		{Dn: "cn=svc,dc=example,dc=com", Password: "svc-pass"},
+		{Dn: "uid=alice,ou=people,dc=example,dc=com", Password: "alice-pass", Attributes: map[string][]string{
+			"uid": {"alice"}, "memberOf": {"cn=devs,ou=groups,dc=example,dc=com"},
+		}},
+		{Dn: "uid=bob,ou=people,dc=example,dc=com", Password: "bob-pass", Attributes: map[string][]string{
+			"uid": {"bob"}, "department": {"audit"},
+		}},
+		{Dn: "uid=carol,ou=people,dc=example,dc=com", Password: "carol-pass", Attributes: map[string][]string{
+			"uid": {"carol"},
+		}},
+		{Dn: "cn=ops,ou=groups,dc=example,dc=com", Attributes: map[string][]string{
+			"member": {"uid=carol,ou=people,dc=example,dc=com"},
+		}},
+	})
+	config.Get().Auth.Ldap.UserDn = "uid=%s,ou=people,dc=example,dc=com"
+	config.Get().Auth.Ldap.CacheTTL = 0
+	config.Get().Auth.Ldap.GroupRules = []string{
+		"dn=cn=devs,ou=groups,dc=example,dc=com:devs:read,push",
+		"filter=(department=audit):devs:read",
+		"dn=CN=ops,OU=groups,DC=example,DC=com:ops:read,push,delete",
+	}
+
+	request := func(method, pkgName, username, password string) int {
+		w, req := UploadTestNpmPackage(pkgName, "0.0.1")
+		if method == "GET" {
+			w = httptest.NewRecorder()
+			req, _ = http.NewRequest("GET", "/npm/"+pkgName, nil)
+		}
+		req.Header.Del("Authorization")
+		req.SetBasicAuth(username, password)
+		serverApp.ServeHTTP(w, req)
+		return w.Code
+	}
+	pkgName := "devs/" + uuid.NewString()
+
+	t.Run("should map the group members to the namespace", func(t *testing.T) {
+		assert.Equal(t, 200, request("PUT", pkgName, "alice", "alice-pass"))
+		assert.Equal(t, 401, request("GET", pkgName, "alice", "wrong-pass"))
+		assert.Equal(t, 401, request("GET", pkgName, "alice", ""))
+	})
+
+	t.Run("should map the users matching the filter", func(t *testing.T) {
+		assert.Equal(t, 200, request("GET", pkgName, "bob", "bob-pass"))
+		assert.Equal(t, 403, request("PUT", "devs/"+uuid.NewString(), "bob", "bob-pass"))
+	})
+
+	t.Run("should find the users and the groups with the service account", func(t *testing.T) {
+		config.Get().Auth.Ldap.UserDn = ""
+		config.Get().Auth.Ldap.BaseDn = "ou=people,dc=example,dc=com"
+		config.Get().Auth.Ldap.BindDn = "cn=svc,dc=example,dc=com"
+		config.Get().Auth.Ldap.BindPassword = "svc-pass"
+		config.Get().Auth.Ldap.GroupBaseDn = "ou=groups,dc=example,dc=com"
+
+		assert.Equal(t, 200, request("PUT", "ops/"+uuid.NewString(), "carol", "carol-pass"))
+		assert.Equal(t, 401, request("GET", pkgName, "nobody", "nobody-pass"))
+	})
+
+	t.Run("should reject the users without a mapped group", func(t *testing.T) {
+		config.Get().Auth.Ldap.GroupRules = []string{"dn=cn=devs,ou=groups,dc=example,dc=com:devs:read,push"}
+		assert.Equal(t, 401, request("GET", pkgName, "bob", "bob-pass"))
+	})
+
+	t.Run("should cache the successful binds", func(t *testing.T) {
+		config.Get().Auth.Ldap.CacheTTL = time.Minute
+		assert.Equal(t, 200, request("GET", pkgName, "alice", "alice-pass"))
+		binds := server.Binds.Load()
+		assert.Equal(t, 200, request("GET", pkgName, "alice", "alice-pass"))
+		assert.Equal(t, binds, server.Binds.Load())
+	})
+
+	t.Run("should keep the namespace of the cached binds after a public pull", func(t *testing.T) {
+		config.Get().Auth.Ldap.GroupRules = append(config.Get().Auth.Ldap.GroupRules, "dn=cn=ops,ou=groups,dc=example,dc=com:ops:read,push,delete")
+		pkg := models.Package[any]{Namespace: "devs", Service: "npm"}
+		assert.Nil(t, pkg.FillByName(pkgName))
+		assert.Nil(t, pkg.SetPublic(true))
+
+		assert.Equal(t, 200, request("GET", pkgName, "carol", "carol-pass"))
+		assert.Equal(t, 403, request("PUT", "devs/"+uuid.NewString(), "carol", "carol-pass"))
+		assert.Equal(t, 200, request("PUT", "ops/"+uuid.NewString(), "carol", "carol-pass"))
+	})
+
+	assert.Nil(t, DeleteTestPackage(pkgName, "npm"))
+}
@@ -1,15 +1,29 @@
 package cmd
 
 import (
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"crypto/sha512"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/db"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/alin-io/pkgstore/services"
 	"github.com/alin-io/pkgstore/services/npm"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNpmPackageUpload(t *testing.T) {
@@ -28,6 +42,55 @@ func TestNpmPackageUpload(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestNpmGrantedPublish(t *testing.T) {
+	UseAuthProvider(t, config.AuthProviderLocal)
+	owner, ownerToken, _ := CreateTestUser(t, models.TokenScopeRead, models.TokenScopePush)
+	partner, partnerToken, _ := CreateTestUser(t, models.TokenScopeRead, models.TokenScopePush)
+	pkgName := owner.Namespace + "/" + uuid.NewString()
+
+	w, req := UploadTestNpmPackage(pkgName, "0.0.1")
+	req.Header.Set("Authorization", "Bearer "+ownerToken)
+	serverApp.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	pkg := models.Package[any]{Namespace: owner.Namespace, Service: "npm"}
+	assert.Nil(t, pkg.FillByName(pkgName))
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/packages/"+pkg.ID.String()+"/grants",
+		bytes.NewBufferString(`{"grantee_type": "user", "grantee": "`+partner.Name+`", "read": true, "publish": true}`))
+	req.Header.Set("Authorization", "Bearer "+ownerToken)
+	serverApp.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	t.Run("should publish the granted package", func(t *testing.T) {
+		w, req := UploadTestNpmPackage(pkgName, "0.0.2")
+		req.Header.Set("Authorization", "Bearer "+partnerToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+	})
+
+	t.Run("should refuse a body naming another package of the namespace", func(t *testing.T) {
+		otherPkgName := owner.Namespace + "/evil-" + uuid.NewString()[:8]
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/npm/"+pkgName, NpmPackageDataReader(otherPkgName, "0.0.1"))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+partnerToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 400, w.Code)
+
+		w, req = UploadTestNpmPackage(otherPkgName, "0.0.1")
+		req.Header.Set("Authorization", "Bearer "+partnerToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 403, w.Code)
+
+		otherPkg := models.Package[any]{Namespace: owner.Namespace, Service: "npm"}
+		assert.Nil(t, otherPkg.FillByName(otherPkgName))
+		assert.Equal(t, uuid.Nil, otherPkg.ID)
+	})
+
+	assert.Nil(t, DeleteTestPackage(pkgName, "npm"))
+}
+
 func TestNpmPackageMetadata(t *testing.T) {
 	t.Run("should respond with 404 if requested package doesn't exist", func(t *testing.T) {
 		w := httptest.NewRecorder()
@@ -82,6 +145,913 @@ func TestNpmPackageDownload(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestNpmDistTags(t *testing.T) {
+	pkgName := uuid.NewString()
+	for _, version := range []string{"0.0.1", "0.0.2"} {
+		w, req := UploadTestNpmPackage(pkgName, version)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+	}
+
+	distTagsRequest := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/npm/-/package/"+pkgName+"/dist-tags"+path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		serverApp.ServeHTTP(w, req)
+		return w
+	}
+	metadataDistTags := func() map[string]string {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/"+pkgName, nil)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+		metadata := npm.MetadataResponse{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &metadata))
+		assert.Len(t, metadata.Versions, 2)
+		return metadata.DistTags
+	}
+
+	t.Run("should list the tags of the published versions", func(t *testing.T) {
+		w := distTagsRequest("GET", "", "")
+		assert.Equal(t, 200, w.Code)
+		assert.JSONEq(t, `{"latest": "0.0.2"}`, w.Body.String())
+	})
+
+	t.Run("should add and move the tags", func(t *testing.T) {
+		assert.Equal(t, 201, distTagsRequest("PUT", "/beta", `"0.0.1"`).Code)
+		assert.Equal(t, map[string]string{"latest": "0.0.2", "beta": "0.0.1"}, metadataDistTags())
+
+		assert.Equal(t, 201, distTagsRequest("PUT", "/beta", `"0.0.2"`).Code)
+		assert.Equal(t, 201, distTagsRequest("PUT", "/latest", `"0.0.1"`).Code)
+		assert.Equal(t, map[string]string{"latest": "0.0.1", "beta": "0.0.2"}, metadataDistTags())
+	})
+
+	t.Run("should reject the invalid tags", func(t *testing.T) {
+		assert.Equal(t, 404, distTagsRequest("PUT", "/beta", `"9.9.9"`).Code)
+		assert.Equal(t, 400, distTagsRequest("PUT", "/1.0.0", `"0.0.1"`).Code)
+		assert.Equal(t, 400, distTagsRequest("PUT", "/beta", `{}`).Code)
+	})
+
+	t.Run("should remove the tags except latest", func(t *testing.T) {
+		assert.Equal(t, 200, distTagsRequest("DELETE", "/beta", "").Code)
+		assert.Equal(t, 404, distTagsRequest("DELETE", "/beta", "").Code)
+		assert.Equal(t, 400, distTagsRequest("DELETE", "/latest", "").Code)
+		assert.Equal(t, map[string]string{"latest": "0.0.1"}, metadataDistTags())
+	})
+
+	assert.Nil(t, DeleteTestPackage(pkgName, "npm"))
+}
+
+func TestNpmUnpublishAndDeprecate(t *testing.T) {
+	pkgName := uuid.NewString()
+	for _, version := range []string{"0.0.1", "0.0.2", "0.0.3"} {
+		w, req := UploadTestNpmPackage(pkgName, version)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+	}
+
+	packument := func() npm.MetadataResponse {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/"+pkgName+"?write=true", nil)
+		serverApp.ServeHTTP(w, req)
+		metadata := npm.MetadataResponse{}
+		if w.Code == 200 {
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &metadata))
+		}
+		return metadata
+	}
+	npmRequest := func(method, path string, body any) int {
+		bodyBytes, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/npm/"+pkgName+path, bytes.NewBuffer(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		serverApp.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("should store the deprecation message of the versions", func(t *testing.T) {
+		metadata := packument()
+		versionInfo := metadata.Versions["0.0.1"]
+		versionInfo.Deprecated = "use 0.0.2"
+		metadata.Versions["0.0.1"] = versionInfo
+		assert.Equal(t, 200, npmRequest("PUT", "", metadata))
+
+		metadata = packument()
+		assert.Equal(t, "use 0.0.2", metadata.Versions["0.0.1"].Deprecated)
+		assert.Empty(t, metadata.Versions["0.0.2"].Deprecated)
+	})
+
+	t.Run("should unpublish a version with the packument rewrite", func(t *testing.T) {
+		metadata := packument()
+		assert.NotEmpty(t, metadata.Rev)
+		delete(metadata.Versions, "0.0.3")
+		delete(metadata.DistTags, "latest")
+		assert.Equal(t, 200, npmRequest("PUT", "/-rev/"+metadata.Rev, metadata))
+		assert.Equal(t, 200, npmRequest("DELETE", fmt.Sprintf("/-/%s-0.0.3.tgz/-rev/%s", pkgName, packument().Rev), nil))
+
+		metadata = packument()
+		assert.Len(t, metadata.Versions, 2)
+		assert.Equal(t, "0.0.2", metadata.DistTags["latest"])
+		assert.Equal(t, "use 0.0.2", metadata.Versions["0.0.1"].Deprecated)
+	})
+
+	t.Run("should respect the unpublish window", func(t *testing.T) {
+		previous := config.Get().Npm.UnpublishWindow
+		config.Get().Npm.UnpublishWindow = time.Nanosecond
+		assert.Equal(t, 403, npmRequest("DELETE", "/-rev/"+packument().Rev, nil))
+		config.Get().Npm.UnpublishWindow = previous
+		assert.Len(t, packument().Versions, 2)
+	})
+
+	t.Run("should unpublish the whole package", func(t *testing.T) {
+		assert.Equal(t, 200, npmRequest("DELETE", "/-rev/"+packument().Rev, nil))
+		assert.Equal(t, 404, npmRequest("GET", "", nil))
+	})
+}
+
+func TestNpmSearch(t *testing.T) {
+	UseAuthProvider(t, config.AuthProviderLocal)
+	owner, ownerToken, _ := CreateTestUser(t, models.TokenScopeRead, models.TokenScopePush)
+	_, partnerToken, _ := CreateTestUser(t, models.TokenScopeRead)
+	term := "search" + uuid.NewString()[:8]
+	pkgNames := []string{
+		owner.Namespace + "/a-" + term,
+		owner.Namespace + "/" + term,
+		owner.Namespace + "/" + term + "-utils",
+	}
+	for _, pkgName := range pkgNames {
+		w, req := UploadTestNpmPackage(pkgName, "0.0.1")
+		req.Header.Set("Authorization", "Bearer "+ownerToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+	}
+
+	search := func(token, query string) npm.SearchResponse {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/-/v1/search?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+		result := npm.SearchResponse{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &result))
+		return result
+	}
+	resultNames := func(result npm.SearchResponse) []string {
+		names := make([]string, 0)
+		for _, object := range result.Objects {
+			names = append(names, object.Package.Name)
+		}
+		return names
+	}
+
+	t.Run("should rank the name matches first", func(t *testing.T) {
+		result := search(ownerToken, "text="+term)
+		assert.Equal(t, 3, result.Total)
+		assert.Equal(t, []string{pkgNames[1], pkgNames[2], pkgNames[0]}, resultNames(result))
+		assert.Equal(t, 1.0, result.Objects[0].Score.Final)
+		assert.Equal(t, "0.0.1", result.Objects[0].Package.Version)
+	})
+
+	t.Run("should match every term with the description", func(t *testing.T) {
+		assert.Equal(t, 3, search(ownerToken, "text="+term+"+created").Total)
+		assert.Equal(t, 0, search(ownerToken, "text="+term+"+missing").Total)
+	})
+
+	t.Run("should page the results", func(t *testing.T) {
+		result := search(ownerToken, "text="+term+"&size=1&from=1")
+		assert.Equal(t, 3, result.Total)
+		assert.Equal(t, []string{pkgNames[2]}, resultNames(result))
+	})
+
+	t.Run("should only find the packages visible to the caller", func(t *testing.T) {
+		assert.Equal(t, 0, search(partnerToken, "text="+term).Total)
+
+		pkg := models.Package[any]{Namespace: owner.Namespace, Service: "npm"}
+		assert.Nil(t, pkg.FillByName(pkgNames[1]))
+		assert.Nil(t, pkg.SetPublic(true))
+		assert.Equal(t, []string{pkgNames[1]}, resultNames(search(partnerToken, "text="+term)))
+	})
+
+	t.Run("should describe the latest version and not match the wildcards", func(t *testing.T) {
+		w, req := UploadTestNpmPackage(pkgNames[1], "0.0.2")
+		req.Header.Set("Authorization", "Bearer "+ownerToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		result := search(ownerToken, "text="+term)
+		assert.Equal(t, 3, result.Total)
+		assert.Equal(t, "0.0.2", result.Objects[0].Package.Version)
+		assert.Equal(t, 0, search(ownerToken, "text="+term+"+%25%25").Total)
+	})
+
+	for _, pkgName := range pkgNames {
+		assert.Nil(t, DeleteTestPackage(pkgName, "npm"))
+	}
+}
+
+func TestNpmLogin(t *testing.T) {
+	namespace := uuid.NewString()[:8]
+	pkgName := namespace + "/" + uuid.NewString()
+	UseTestHtpasswd(t, HtpasswdTestLine(t, "alice", "alice-password"), "alice:"+namespace+":read,push\n")
+
+	npmRequest := func(method, path, token string, body io.Reader) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, body)
+		req.Header.Set("Content-Type", "application/json")
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		serverApp.ServeHTTP(w, req)
+		return w
+	}
+	whoami := func(token string) (int, string) {
+		w := npmRequest("GET", "/npm/-/whoami", token, nil)
+		result := map[string]string{}
+		_ = json.Unmarshal(w.Body.Bytes(), &result)
+		return w.Code, result["username"]
+	}
+
+	t.Run("should answer ping without credentials", func(t *testing.T) {
+		assert.Equal(t, 200, npmRequest("GET", "/npm/-/ping", "", nil).Code)
+		status, _ := whoami("")
+		assert.Equal(t, 401, status)
+	})
+
+	t.Run("should issue a token with the legacy login", func(t *testing.T) {
+		w := npmRequest("PUT", "/npm/-/user/org.couchdb.user:alice", "", bytes.NewBufferString(`{"name": "alice", "password": "wrong"}`))
+		assert.Equal(t, 401, w.Code)
+
+		w = npmRequest("PUT", "/npm/-/user/org.couchdb.user:alice", "", bytes.NewBufferString(`{"_id": "org.couchdb.user:alice", "name": "alice", "password": "alice-password", "type": "user"}`))
+		assert.Equal(t, 201, w.Code)
+		result := map[string]any{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &result))
+		token := result["token"].(string)
+
+		status, username := whoami(token)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, "alice", username)
+
+		w, req := UploadTestNpmPackage(pkgName, "0.0.1")
+		req.Header.Set("Authorization", "Bearer "+token)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		// The token only works for the npm registry
+		assert.Equal(t, 401, npmRequest("GET", "/pypi/simple/"+pkgName, token, nil).Code)
+	})
+
+	t.Run("should issue a token with the web login", func(t *testing.T) {
+		w := npmRequest("POST", "/npm/-/v1/login", "", bytes.NewBufferString(`{"hostname": "test"}`))
+		assert.Equal(t, 200, w.Code)
+		result := map[string]string{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &result))
+		loginUrl, err := url.Parse(result["loginUrl"])
+		assert.Nil(t, err)
+		doneUrl, err := url.Parse(result["doneUrl"])
+		assert.Nil(t, err)
+		assert.NotEqual(t, path.Base(loginUrl.Path), path.Base(doneUrl.Path))
+
+		w = npmRequest("GET", doneUrl.Path, "", nil)
+		assert.Equal(t, 202, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+		assert.Equal(t, 200, npmRequest("GET", loginUrl.Path, "", nil).Code)
+
+		submitLogin := func(password string) int {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", loginUrl.Path, strings.NewReader(url.Values{"username": {"alice"}, "password": {password}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			serverApp.ServeHTTP(w, req)
+			return w.Code
+		}
+		assert.Equal(t, 401, submitLogin("wrong"))
+		assert.Equal(t, 200, submitLogin("alice-password"))
+		assert.Equal(t, 404, submitLogin("alice-password"))
+
+		w = npmRequest("GET", doneUrl.Path, "", nil)
+		assert.Equal(t, 200, w.Code)
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &result))
+		status, username := whoami(result["token"])
+		assert.Equal(t, 200, status)
+		assert.Equal(t, "alice", username)
+		assert.Equal(t, 404, npmRequest("GET", doneUrl.Path, "", nil).Code)
+	})
+
+	assert.Nil(t, DeleteTestPackage(pkgName, "npm"))
+}
+
+func TestNpmLoginRevocation(t *testing.T) {
+	UseAuthProvider(t, config.AuthProviderLocal)
+
+	remoteAddr := "198.51.100.40:1234"
+	login := func(username, password string) (int, string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/npm/-/user/org.couchdb.user:"+username, bytes.NewBufferString(`{"name": "`+username+`", "password": "`+password+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = remoteAddr
+		serverApp.ServeHTTP(w, req)
+		result := map[string]any{}
+		_ = json.Unmarshal(w.Body.Bytes(), &result)
+		token, _ := result["token"].(string)
+		return w.Code, token
+	}
+	whoami := func(token string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/-/whoami", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		serverApp.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("should reject the session of a revoked API token", func(t *testing.T) {
+		user, plainToken, apiToken := CreateTestUser(t, models.TokenScopeRead)
+		code, token := login(user.Name, plainToken)
+		assert.Equal(t, 201, code)
+		assert.Equal(t, 200, whoami(token))
+
+		assert.Nil(t, apiToken.Revoke())
+		assert.Equal(t, 401, whoami(token))
+	})
+
+	t.Run("should reject the session of a disabled user", func(t *testing.T) {
+		user, plainToken, _ := CreateTestUser(t, models.TokenScopeRead)
+		code, token := login(user.Name, plainToken)
+		assert.Equal(t, 201, code)
+		assert.Equal(t, 200, whoami(token))
+
+		user.Disabled = true
+		assert.Nil(t, user.Save())
+		assert.Equal(t, 401, whoami(token))
+	})
+
+	t.Run("should limit the login attempts by IP", func(t *testing.T) {
+		rateLimitConfig := config.Get().RateLimit
+		t.Cleanup(func() {
+			config.Get().RateLimit = rateLimitConfig
+		})
+		config.Get().RateLimit.Backend = config.RateLimitBackendMemory
+		config.Get().RateLimit.Login = config.RateLimitRule{Requests: 2, Window: time.Minute}
+
+		remoteAddr = "198.51.100.41:1234"
+		username := "user-" + uuid.NewString()
+		codes := make([]int, 0)
+		for i := 0; i < 3; i++ {
+			code, _ := login(username, uuid.NewString())
+			codes = append(codes, code)
+		}
+		assert.Equal(t, []int{401, 401, 429}, codes)
+	})
+}
+
+func TestNpmAbbreviatedMetadata(t *testing.T) {
+	pkgName := uuid.NewString()
+	w, req := UploadTestNpmPackage(pkgName, "0.0.1")
+	serverApp.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	metadataRequest := func(accept string, headers ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/"+pkgName, nil)
+		req.Header.Set("Accept", accept)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		serverApp.ServeHTTP(w, req)
+		return w
+	}
+	corgiAccept := npm.AbbreviatedMetadataContentType + "; q=1.0, application/json; q=0.8, */*"
+
+	var etag string
+	t.Run("should serve the abbreviated document to installs", func(t *testing.T) {
+		w := metadataRequest(corgiAccept)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, npm.AbbreviatedMetadataContentType, w.Header().Get("Content-Type"))
+		assert.NotContains(t, w.Body.String(), "readme")
+
+		metadata := npm.AbbreviatedMetadataResponse{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &metadata))
+		assert.Equal(t, "0.0.1", metadata.DistTags["latest"])
+		assert.NotEmpty(t, metadata.Versions["0.0.1"].Dist.Tarball)
+		assert.NotEmpty(t, metadata.Modified)
+
+		etag = w.Header().Get("ETag")
+		assert.NotEmpty(t, etag)
+		assert.NotEqual(t, etag, metadataRequest("application/json").Header().Get("ETag"))
+	})
+
+	t.Run("should respond with 304 to the conditional requests", func(t *testing.T) {
+		assert.Equal(t, 304, metadataRequest(corgiAccept, "If-None-Match", etag).Code)
+		assert.Equal(t, 304, metadataRequest(corgiAccept, "If-None-Match", "W/"+etag).Code)
+		assert.Equal(t, 200, metadataRequest(corgiAccept, "If-None-Match", `"other"`).Code)
+
+		lastModified := metadataRequest(corgiAccept).Header().Get("Last-Modified")
+		assert.Equal(t, 304, metadataRequest(corgiAccept, "If-Modified-Since", lastModified).Code)
+	})
+
+	t.Run("should refresh the documents on tag and publish", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/npm/-/package/"+pkgName+"/dist-tags/beta", bytes.NewBufferString(`"0.0.1"`))
+		req.Header.Set("Content-Type", "application/json")
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 201, w.Code)
+
+		w = metadataRequest(corgiAccept, "If-None-Match", etag)
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), `"beta":"0.0.1"`)
+		etag = w.Header().Get("ETag")
+
+		w, req = UploadTestNpmPackage(pkgName, "0.0.2")
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		w = metadataRequest(corgiAccept, "If-None-Match", etag)
+		assert.Equal(t, 200, w.Code)
+		metadata := npm.AbbreviatedMetadataResponse{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &metadata))
+		assert.Len(t, metadata.Versions, 2)
+	})
+
+	assert.Nil(t, DeleteTestPackage(pkgName, "npm"))
+}
+
+func TestNpmVersionManifests(t *testing.T) {
+	pkgName := uuid.NewString()
+	fetchVersion := func(accept string) map[string]json.RawMessage {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/"+pkgName, nil)
+		req.Header.Set("Accept", accept)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+		document := struct {
+			Versions map[string]map[string]json.RawMessage `json:"versions"`
+		}{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &document))
+		return document.Versions["0.0.1"]
+	}
+
+	t.Run("should return the published manifest unchanged", func(t *testing.T) {
+		body := map[string]any{}
+		assert.Nil(t, json.Unmarshal(NpmPackageDataReader(pkgName, "0.0.1").Bytes(), &body))
+		versionInfo := body["versions"].(map[string]any)["0.0.1"].(map[string]any)
+		versionInfo["author"] = "Package Registry Utility"
+		versionInfo["dependencies"] = map[string]string{"left-pad": "^1.3.0"}
+		versionInfo["bin"] = map[string]string{"bananas": "bin/bananas.js"}
+		versionInfo["engines"] = map[string]string{"node": ">=18"}
+		versionInfo["gitHead"] = "4a9dbd94ca6093feda03d909f3d7e6bd89d9d4bf"
+		versionInfo["dist"].(map[string]any)["fileCount"] = 3
+		requestBody, _ := json.Marshal(body)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/npm/"+pkgName, bytes.NewReader(requestBody))
+		req.Header.Set("Content-Type", "application/json")
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		manifest := fetchVersion("application/json")
+		assert.JSONEq(t, `"Package Registry Utility"`, string(manifest["author"]))
+		assert.JSONEq(t, `{"left-pad":"^1.3.0"}`, string(manifest["dependencies"]))
+		assert.JSONEq(t, `"4a9dbd94ca6093feda03d909f3d7e6bd89d9d4bf"`, string(manifest["gitHead"]))
+		assert.JSONEq(t, `"`+pkgName+`@0.0.1"`, string(manifest["_id"]))
+		assert.Contains(t, string(manifest["dist"]), `"fileCount":3`)
+
+		manifest = fetchVersion(npm.AbbreviatedMetadataContentType)
+		assert.JSONEq(t, `{"left-pad":"^1.3.0"}`, string(manifest["dependencies"]))
+		assert.JSONEq(t, `{"bananas":"bin/bananas.js"}`, string(manifest["bin"]))
+		assert.JSONEq(t, `{"node":">=18"}`, string(manifest["engines"]))
+		assert.NotContains(t, manifest, "gitHead")
+	})
+
+	t.Run("should restore the missing fields of the legacy manifests from the tarball", func(t *testing.T) {
+		pkg := models.Package[npm.PackageMetadata]{Service: "npm"}
+		assert.Nil(t, pkg.FillByName(pkgName))
+		legacyManifest := fmt.Sprintf(`{"_id":"","description":"","readme":"","name":%q,"version":"0.0.1","_nodeVersion":"","_npmVersion":"",`+
+			`"author":null,"dist":{"integrity":"","shasum":"","tarball":""},"publishConfig":null,"scripts":null,"keywords":null,"license":"MIT","main":""}`, pkgName)
+		err := db.DB().Model(&models.PackageVersion[any]{}).Where("package_id = ?", pkg.ID).Update("metadata", legacyManifest).Error
+		assert.Nil(t, err)
+
+		migrated, err := npm.NewService(storageBackend).MigrateManifests()
+		assert.Nil(t, err)
+		assert.GreaterOrEqual(t, migrated, 1)
+		migrated, err = npm.NewService(storageBackend).MigrateManifests()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, migrated)
+
+		// The migration doesn't overwrite the versions updated since it loaded them
+		version, err := pkg.Version("0.0.1")
+		assert.Nil(t, err)
+		staleVersion := version
+		assert.Nil(t, version.SaveMeta())
+		saved, err := staleVersion.SaveMetaIfUnchanged()
+		assert.Nil(t, err)
+		assert.False(t, saved)
+
+		version, err = pkg.Version("0.0.1")
+		assert.Nil(t, err)
+		saved, err = version.SaveMetaIfUnchanged()
+		assert.Nil(t, err)
+		assert.True(t, saved)
+
+		manifest := fetchVersion("application/json")
+		assert.JSONEq(t, `"GitLab Package Registry Utility"`, string(manifest["author"]))
+		assert.JSONEq(t, `{"test":"echo \"Error: no test specified\" && exit 1"}`, string(manifest["scripts"]))
+		assert.JSONEq(t, `"MIT"`, string(manifest["license"]))
+		assert.JSONEq(t, `"`+pkgName+`"`, string(manifest["name"]))
+	})
+
+	assert.Nil(t, DeleteTestPackage(pkgName, "npm"))
+}
+
+func TestNpmTarballUrls(t *testing.T) {
+	pkgName := uuid.NewString()[:8] + "/bananas"
+	w, req := UploadTestNpmPackage("@"+pkgName, "1.0.0-rc.1")
+	serverApp.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	npmConfig, registryHost := config.Get().Npm, config.Get().RegistryHosts.Npm
+	t.Cleanup(func() {
+		config.Get().Npm, config.Get().RegistryHosts.Npm = npmConfig, registryHost
+	})
+
+	tarballUrl := func(headers ...string) string {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/@"+pkgName, nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		if host := req.Header.Get("Host"); len(host) > 0 {
+			req.Host = host
+		}
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+		metadata := npm.MetadataResponse{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &metadata))
+		return metadata.Versions["1.0.0-rc.1"].Dist.Tarball
+	}
+
+	t.Run("should serve the tarball URLs of the registry host", func(t *testing.T) {
+		assert.Equal(t, "http://localhost:8080/npm/@"+pkgName+"/-/bananas-1.0.0-rc.1.tgz", tarballUrl())
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/@"+pkgName+"/-/bananas-1.0.0-rc.1.tgz", nil)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		config.Get().RegistryHosts.Npm = "https://npm.example.com/"
+		assert.Equal(t, "https://npm.example.com/@"+pkgName+"/-/bananas-1.0.0-rc.1.tgz", tarballUrl())
+	})
+
+	t.Run("should use the forwarded host when trusted", func(t *testing.T) {
+		assert.Equal(t, "https://npm.example.com/@"+pkgName+"/-/bananas-1.0.0-rc.1.tgz", tarballUrl("X-Forwarded-Host", "registry.internal"))
+
+		config.Get().Npm.TrustForwardedHost = true
+		assert.Equal(t, "https://registry.internal:8443/registry/npm/@"+pkgName+"/-/bananas-1.0.0-rc.1.tgz",
+			tarballUrl("X-Forwarded-Host", "registry.internal:8443, proxy.internal", "X-Forwarded-Proto", "https", "X-Forwarded-Prefix", "/registry/"))
+		assert.Equal(t, "http://example.com/npm/@"+pkgName+"/-/bananas-1.0.0-rc.1.tgz", tarballUrl("Host", "example.com"))
+		assert.Equal(t, "https://npm.example.com/@"+pkgName+"/-/bananas-1.0.0-rc.1.tgz", tarballUrl("X-Forwarded-Host", "evil.com/path"))
+	})
+
+	assert.Nil(t, DeleteTestPackage(pkgName, "npm"))
+}
+
+func TestNpmCachingProxy(t *testing.T) {
+	pkgName := "proxy-" + uuid.NewString()[:8] + "/lib"
+	unverifiedPkgName := "unverified-" + uuid.NewString()[:8]
+	tarball := []byte("tarball of " + pkgName)
+	sha512Sum := sha512.Sum512(tarball)
+
+	var metadataHits, tarballHits int
+	upstreamDown := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if upstreamDown {
+			w.WriteHeader(503)
+			return
+		}
+		switch r.URL.EscapedPath() {
+		case "/@" + strings.Replace(pkgName, "/", "%2f", 1):
+			metadataHits++
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(304)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			_, _ = fmt.Fprintf(w, `{"name": "@%[1]s", "dist-tags": {"latest": "1.0.0"}, "versions": {"1.0.0": {"name": "@%[1]s", "version": "1.0.0",
+				"dependencies": {"left-pad": "^1.3.0"}, "dist": {"integrity": "sha512-%[2]s", "tarball": "http://%[3]s/tarballs/lib-1.0.0.tgz"}}}}`,
+				pkgName, base64.StdEncoding.EncodeToString(sha512Sum[:]), r.Host)
+		case "/" + unverifiedPkgName:
+			_, _ = fmt.Fprintf(w, `{"name": %[1]q, "dist-tags": {"latest": "1.0.0"}, "versions": {"1.0.0": {"name": %[1]q, "version": "1.0.0",
+				"dist": {"tarball": "http://%[2]s/tarballs/lib-1.0.0.tgz"}}}}`, unverifiedPkgName, r.Host)
+		case "/tarballs/lib-1.0.0.tgz":
+			tarballHits++
+			_, _ = w.Write(tarball)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer upstream.Close()
+
+	npmConfig := config.Get().Npm
+	t.Cleanup(func() {
+		config.Get().Npm = npmConfig
+	})
+	config.Get().Npm.ProxyMode = config.NpmProxyCache
+	config.Get().Npm.UpstreamUrl = upstream.URL
+	config.Get().Npm.ProxyMetadataTTL = time.Hour
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", npm.AbbreviatedMetadataContentType)
+		serverApp.ServeHTTP(w, req)
+		return w
+	}
+	tarballPath := "/npm/@" + pkgName + "/-/lib-1.0.0.tgz"
+
+	t.Run("should cache the upstream metadata with the tarball URLs of pkgstore", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			w := get("/npm/@" + pkgName)
+			assert.Equal(t, 200, w.Code)
+			metadata := npm.AbbreviatedMetadataResponse{}
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &metadata))
+			assert.Equal(t, config.Get().RegistryHosts.Npm+tarballPath[len("/npm"):], metadata.Versions["1.0.0"].Dist.Tarball)
+			assert.JSONEq(t, `{"left-pad": "^1.3.0"}`, string(metadata.Versions["1.0.0"].Dependencies))
+		}
+		assert.Equal(t, 1, metadataHits)
+	})
+
+	t.Run("should store the tarball on the first download", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			w := get(tarballPath)
+			assert.Equal(t, 200, w.Code)
+			assert.Equal(t, tarball, w.Body.Bytes())
+		}
+		assert.Equal(t, 1, tarballHits)
+
+		cachedTarball := models.UpstreamTarball{Service: "npm"}
+		assert.Nil(t, cachedTarball.FillByVersion(pkgName, "1.0.0"))
+		gc := services.GarbageCollector{Storage: storageBackend}
+		assets, err := gc.CleanupAssets(true)
+		assert.Nil(t, err)
+		for _, asset := range assets {
+			assert.NotEqual(t, cachedTarball.AssetId, asset.ID)
+		}
+	})
+
+	t.Run("should refuse the tarballs without an integrity or a shasum", func(t *testing.T) {
+		assert.Equal(t, 200, get("/npm/"+unverifiedPkgName).Code)
+		assert.Equal(t, 502, get("/npm/"+unverifiedPkgName+"/-/"+unverifiedPkgName+"-1.0.0.tgz").Code)
+
+		cachedTarball := models.UpstreamTarball{Service: "npm"}
+		assert.Nil(t, cachedTarball.FillByVersion(unverifiedPkgName, "1.0.0"))
+		assert.Equal(t, uuid.Nil, cachedTarball.AssetId)
+	})
+
+	t.Run("should revalidate the expired metadata", func(t *testing.T) {
+		config.Get().Npm.ProxyMetadataTTL = 0
+		assert.Equal(t, 200, get("/npm/@"+pkgName).Code)
+		assert.Equal(t, 2, metadataHits)
+	})
+
+	t.Run("should keep serving the cached packages while the upstream is down", func(t *testing.T) {
+		upstreamDown = true
+		assert.Equal(t, 200, get("/npm/@"+pkgName).Code)
+		assert.Equal(t, 200, get(tarballPath).Code)
+		assert.Equal(t, 502, get("/npm/missing-"+uuid.NewString()).Code)
+
+		upstreamDown = false
+		assert.Equal(t, 404, get("/npm/missing-"+uuid.NewString()).Code)
+		assert.Equal(t, 404, get("/npm/@"+pkgName+"/-/lib-2.0.0.tgz").Code)
+	})
+}
+
+func TestNpmReservedNames(t *testing.T) {
+	deletedPkgName := "deleted-" + uuid.NewString()[:8]
+	scopeName := "acme-" + uuid.NewString()[:8]
+	publicPkgName := "public-" + uuid.NewString()[:8]
+
+	upstreamHits := map[string]int{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/-/v1/search" {
+			_, _ = fmt.Fprintf(w, `{"objects": [{"package": {"name": %q}}, {"package": {"name": %q}}, {"package": {"name": %q}}], "total": 3}`,
+				deletedPkgName, "internal-"+uuid.NewString()[:8], publicPkgName)
+			return
+		}
+		name, _ := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/"))
+		upstreamHits[name]++
+		_, _ = fmt.Fprintf(w, `{"name": %q, "dist-tags": {"latest": "1.0.0"}, "versions": {"1.0.0": {"name": %[1]q, "version": "1.0.0",
+			"dist": {"tarball": "http://%s/tarballs/1.0.0.tgz"}}}}`, name, r.Host)
+	}))
+	defer upstream.Close()
+
+	npmConfig, proxyConfig := config.Get().Npm, config.Get().Proxy
+	t.Cleanup(func() {
+		config.Get().Npm, config.Get().Proxy = npmConfig, proxyConfig
+	})
+	config.Get().Npm.ProxyMode = config.NpmProxyCache
+	config.Get().Npm.UpstreamUrl = upstream.URL
+	config.Get().Proxy.ReservedNamespaces = []string{"@" + scopeName}
+	config.Get().Proxy.ReservedNames = []string{"internal-*"}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		serverApp.ServeHTTP(w, req)
+		return w
+	}
+
+	w, req := UploadTestNpmPackage(deletedPkgName, "1.0.0")
+	serverApp.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Nil(t, DeleteTestPackage(deletedPkgName, "npm"))
+
+	t.Run("should not proxy the reserved names", func(t *testing.T) {
+		for _, pkgName := range []string{deletedPkgName, strings.ToUpper(deletedPkgName), "@" + scopeName + "/lib", "internal-" + uuid.NewString()[:8]} {
+			w := get("/npm/" + pkgName)
+			assert.Equal(t, 404, w.Code, pkgName)
+			assert.Contains(t, w.Body.String(), "reserved", pkgName)
+			assert.Equal(t, 404, get("/npm/"+pkgName+"/-/lib-1.0.0.tgz").Code, pkgName)
+		}
+		assert.Empty(t, upstreamHits)
+	})
+
+	t.Run("should proxy the other names", func(t *testing.T) {
+		assert.Equal(t, 200, get("/npm/"+publicPkgName).Code)
+		assert.Equal(t, 1, upstreamHits[publicPkgName])
+	})
+
+	t.Run("should audit the blocked requests", func(t *testing.T) {
+		auditLogs := make([]models.AuditLog, 0)
+		assert.Nil(t, db.DB().Find(&auditLogs, "event = ? AND package_name = ?", models.AuditEventProxyBlocked, deletedPkgName).Error)
+		assert.Len(t, auditLogs, 2)
+		for _, auditLog := range auditLogs {
+			assert.Equal(t, "existing package", auditLog.Detail)
+		}
+	})
+
+	t.Run("should audit the blocked requests in the namespace owning the name", func(t *testing.T) {
+		ownedPkgName := "owned-" + uuid.NewString()[:8]
+		owner := uuid.NewString()[:8]
+		reservedName := models.ReservedName{Service: "npm", Name: ownedPkgName, Namespace: owner}
+		assert.Nil(t, reservedName.Insert(db.DB()))
+		assert.Equal(t, 404, get("/npm/"+ownedPkgName).Code)
+
+		auditLogs, err := models.ListAuditLogs(owner, 10)
+		assert.Nil(t, err)
+		if assert.Len(t, auditLogs, 1) {
+			assert.Equal(t, ownedPkgName, auditLogs[0].PackageName)
+		}
+	})
+
+	t.Run("should reserve the names of the packages missing a reserved name", func(t *testing.T) {
+		pkgName := "unreserved-" + uuid.NewString()[:8]
+		w, req := UploadTestNpmPackage(pkgName, "1.0.0")
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+		assert.Nil(t, db.DB().Delete(&models.ReservedName{}, "service = ? AND name = ?", "npm", pkgName).Error)
+
+		models.SyncModels()
+		reservedName, err := models.FindReservedName("npm", pkgName)
+		assert.Nil(t, err)
+		assert.NotEqual(t, uuid.Nil, reservedName.ID)
+		assert.Nil(t, DeleteTestPackage(pkgName, "npm"))
+	})
+
+	t.Run("should leave the reserved names out of the upstream search", func(t *testing.T) {
+		config.Get().Npm.SearchUpstream = true
+		w := get("/npm/-/v1/search?text=lib")
+		assert.Equal(t, 200, w.Code)
+		result := npm.SearchResponse{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &result))
+		if assert.Len(t, result.Objects, 1) {
+			assert.Equal(t, publicPkgName, result.Objects[0].Package.Name)
+		}
+	})
+}
+
+func TestNpmAudit(t *testing.T) {
+	pkgName := "audit-" + uuid.NewString()[:8]
+	scopedPkgName := "@audit-" + uuid.NewString()[:8] + "/lib"
+	osvId := "GHSA-" + uuid.NewString()[:8]
+	osvEntries := map[string]string{
+		osvId + ".json": fmt.Sprintf(`{"id": %q, "aliases": ["CVE-2024-0001"], "summary": "Prototype pollution", "published": "2024-01-02T00:00:00Z",
+			"affected": [{"package": {"ecosystem": "npm", "name": %q}, "ranges": [{"type": "SEMVER", "events": [
+				{"introduced": "1.0.0"}, {"fixed": "1.2.5"}, {"introduced": "2.0.0-rc.1"}, {"last_affected": "2.0.0"}]}]}],
+			"references": [{"type": "WEB", "url": "https://example.com"}, {"type": "ADVISORY", "url": "https://github.com/advisories/%s"}],
+			"database_specific": {"severity": "HIGH", "cwe_ids": ["CWE-1321"]}}`, osvId, pkgName, osvId),
+		"withdrawn.json": fmt.Sprintf(`{"id": "GHSA-%s", "withdrawn": "2024-02-01T00:00:00Z",
+			"affected": [{"package": {"ecosystem": "npm", "name": %q}, "ranges": [{"type": "SEMVER", "events": [{"introduced": "0"}]}]}]}`, uuid.NewString()[:8], pkgName),
+		"pypi.json": fmt.Sprintf(`{"id": "PYSEC-%s", "affected": [{"package": {"ecosystem": "PyPI", "name": %q}, "versions": ["1.2.4"]}]}`, uuid.NewString()[:8], pkgName),
+	}
+	osvDir := t.TempDir()
+	for name, entry := range osvEntries {
+		assert.Nil(t, os.WriteFile(path.Join(osvDir, name), []byte(entry), 0o600))
+	}
+
+	zipPath := path.Join(t.TempDir(), "all.zip")
+	zipFile, err := os.Create(zipPath)
+	assert.Nil(t, err)
+	zipWriter := zip.NewWriter(zipFile)
+	entryWriter, _ := zipWriter.Create("scoped.json")
+	_, _ = fmt.Fprintf(entryWriter, `{"id": "GHSA-%s", "affected": [{"package": {"ecosystem": "npm", "name": %q}, "ranges": [{"type": "SEMVER", "events": [{"introduced": "0"}]}]}]}`,
+		uuid.NewString()[:8], scopedPkgName)
+	assert.Nil(t, zipWriter.Close())
+	assert.Nil(t, zipFile.Close())
+
+	t.Run("should import the npm entries of the OSV dumps", func(t *testing.T) {
+		imported, err := npm.ImportOsvAdvisories(osvDir)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, imported)
+
+		imported, err = npm.ImportOsvAdvisories(zipPath)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, imported)
+	})
+
+	bulkAudit := func(body string, gzipped bool) map[string][]npm.BulkAdvisory {
+		requestBody := bytes.NewBufferString(body)
+		if gzipped {
+			requestBody = &bytes.Buffer{}
+			gzipWriter := gzip.NewWriter(requestBody)
+			_, _ = gzipWriter.Write([]byte(body))
+			_ = gzipWriter.Close()
+		}
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/npm/-/npm/v1/security/advisories/bulk", requestBody)
+		req.Header.Set("Content-Type", "application/json")
+		if gzipped {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+		result := make(map[string][]npm.BulkAdvisory)
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &result))
+		return result
+	}
+
+	t.Run("should answer the bulk advisory requests of npm audit", func(t *testing.T) {
+		result := bulkAudit(fmt.Sprintf(`{%q: ["1.2.4", "1.2.5"], %q: ["0.1.0"], "left-pad": ["1.3.0"]}`, pkgName, scopedPkgName), true)
+		assert.Len(t, result, 2)
+		if assert.Len(t, result[pkgName], 1) {
+			advisory := result[pkgName][0]
+			assert.Equal(t, "Prototype pollution", advisory.Title)
+			assert.Equal(t, "high", advisory.Severity)
+			assert.Equal(t, ">=1.0.0 <1.2.5 || >=2.0.0-rc.1 <=2.0.0", advisory.VulnerableVersions)
+			assert.Equal(t, "https://github.com/advisories/"+osvId, advisory.Url)
+			assert.Equal(t, []string{"CWE-1321"}, advisory.Cwe)
+		}
+		assert.Len(t, result[scopedPkgName], 1)
+		assert.Equal(t, "*", result[scopedPkgName][0].VulnerableVersions)
+	})
+
+	t.Run("should answer npm audit with a read-only token", func(t *testing.T) {
+		UseAuthProvider(t, config.AuthProviderLocal)
+		_, readToken, _ := CreateTestUser(t, models.TokenScopeRead)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/npm/-/npm/v1/security/advisories/bulk", bytes.NewBufferString(fmt.Sprintf(`{%q: ["1.2.4"]}`, pkgName)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+readToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+		result := make(map[string][]npm.BulkAdvisory)
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.Len(t, result[pkgName], 1)
+	})
+
+	t.Run("should match the semver ranges", func(t *testing.T) {
+		assert.Empty(t, bulkAudit(fmt.Sprintf(`{%q: ["0.9.9", "1.2.5", "1.10.0", "2.0.0-rc.0", "2.0.0-beta", "2.0.1"]}`, pkgName), false))
+		for _, version := range []string{"1.0.0", "1.2.5-rc.1", "2.0.0-rc.1", "2.0.0-rc.10", "2.0.0"} {
+			assert.Len(t, bulkAudit(fmt.Sprintf(`{%q: [%q]}`, pkgName, version), false)[pkgName], 1, version)
+		}
+	})
+
+	t.Run("should list the advisories of the package versions", func(t *testing.T) {
+		for _, version := range []string{"1.2.4", "1.3.0"} {
+			w, req := UploadTestNpmPackage(pkgName, version)
+			serverApp.ServeHTTP(w, req)
+			assert.Equal(t, 200, w.Code)
+		}
+		pkg := models.Package[any]{Service: "npm"}
+		assert.Nil(t, pkg.FillByName(pkgName))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/packages/"+pkg.ID.String(), nil)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &pkg))
+		assert.Len(t, pkg.Versions, 2)
+		for _, version := range pkg.Versions {
+			if version.Version == "1.2.4" {
+				assert.Len(t, version.Advisories, 1)
+				assert.Equal(t, osvId, version.Advisories[0].OsvId)
+				assert.Equal(t, []string{"CVE-2024-0001"}, []string(version.Advisories[0].Aliases))
+			} else {
+				assert.Empty(t, version.Advisories)
+			}
+		}
+	})
+
+	assert.Nil(t, DeleteTestPackage(pkgName, "npm"))
+}
+
 func UploadTestNpmPackage(name, version string) (*httptest.ResponseRecorder, *http.Request) {
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("PUT", "/npm/"+name, NpmPackageDataReader(name, version))
@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"encoding/json"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func UseTestPolicy(t *testing.T, policy string) string {
+	policyFile := filepath.Join(t.TempDir(), "policy.yaml")
+	assert.Nil(t, os.WriteFile(policyFile, []byte(policy), 0600))
+
+	previous := config.Get().Auth.PolicyFile
+	config.Get().Auth.PolicyFile = policyFile
+	t.Cleanup(func() {
+		config.Get().Auth.PolicyFile = previous
+	})
+	return policyFile
+}
+
+func TestAccessPolicy(t *testing.T) {
+	UseAuthProvider(t, config.AuthProviderLocal)
+	owner, ownerToken, _ := CreateTestUser(t, models.TokenScopeRead, models.TokenScopePush)
+	contributor, contributorToken, _ := CreateTestUser(t, models.TokenScopeRead, models.TokenScopePush)
+	contributorReadToken, _, _ := models.NewApiToken(contributor, "read", []string{models.TokenScopeRead}, time.Hour)
+	sharedPkgName := owner.Namespace + "/shared-" + uuid.NewString()
+	frozenPkgName := owner.Namespace + "/frozen-" + uuid.NewString()
+
+	policyFile := UseTestPolicy(t, `
+rules:
+  - name: contributors-publish-shared
+    effect: allow
+    priority: 10
+    subjects: ["`+contributor.Name+`"]
+    namespaces: ["`+owner.Namespace+`"]
+    packages: ["`+owner.Namespace+`/shared-*"]
+    services: [npm]
+    actions: [pull, push]
+  - name: frozen-packages
+    effect: deny
+    packages: ["*/frozen-*"]
+    actions: [push]
+  - name: owner-frozen-exception
+    effect: allow
+    subjects: ["`+owner.Name+`"]
+    packages: ["*/frozen-*"]
+`)
+
+	t.Run("should allow pushes granted by a rule outside of the namespace", func(t *testing.T) {
+		w, req := UploadTestNpmPackage(sharedPkgName, "0.0.1")
+		req.Header.Set("Authorization", "Bearer "+contributorToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/npm/"+sharedPkgName, nil)
+		req.Header.Set("Authorization", "Bearer "+ownerToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+	})
+
+	t.Run("should limit the package rules to the matching packages", func(t *testing.T) {
+		siblingPkgName := owner.Namespace + "/sibling-" + uuid.NewString()
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/npm/"+sharedPkgName, NpmPackageDataReader(siblingPkgName, "0.0.1"))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+contributorToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 400, w.Code)
+
+		w, req = UploadTestNpmPackage(siblingPkgName, "0.0.1")
+		req.Header.Set("Authorization", "Bearer "+contributorToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 403, w.Code)
+
+		siblingPkg := models.Package[any]{Namespace: owner.Namespace, Service: "npm"}
+		assert.Nil(t, siblingPkg.FillByName(siblingPkgName))
+		assert.Equal(t, uuid.Nil, siblingPkg.ID)
+	})
+
+	t.Run("should still require the permission of the token", func(t *testing.T) {
+		w, req := UploadTestNpmPackage(sharedPkgName, "0.0.2")
+		req.Header.Set("Authorization", "Bearer "+contributorReadToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 403, w.Code)
+	})
+
+	t.Run("should prefer deny rules on the same priority", func(t *testing.T) {
+		w, req := UploadTestNpmPackage(frozenPkgName, "0.0.1")
+		req.Header.Set("Authorization", "Bearer "+ownerToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 403, w.Code)
+	})
+
+	t.Run("should explain the decision", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/policy/explain?service=npm&action=push&package="+frozenPkgName, nil)
+		req.Header.Set("Authorization", "Bearer "+ownerToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		result := struct {
+			Subject  string `json:"subject"`
+			Decision struct {
+				Allowed bool                   `json:"allowed"`
+				Source  string                 `json:"source"`
+				Rule    map[string]interface{} `json:"rule"`
+			} `json:"decision"`
+		}{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.Equal(t, owner.Name, result.Subject)
+		assert.False(t, result.Decision.Allowed)
+		assert.Equal(t, "policy", result.Decision.Source)
+		// The contents of the policy aren't shown to the callers
+		assert.Equal(t, map[string]interface{}{"name": "frozen-packages", "effect": "deny"}, result.Decision.Rule)
+	})
+
+	t.Run("should reload the policy file on change", func(t *testing.T) {
+		assert.Nil(t, os.WriteFile(policyFile, []byte(`
+rules:
+  - name: owner-frozen-exception
+    effect: allow
+    priority: 1
+    subjects: ["`+owner.Name+`"]
+    packages: ["*/frozen-*"]
+`), 0600))
+
+		w, req := UploadTestNpmPackage(frozenPkgName, "0.0.1")
+		req.Header.Set("Authorization", "Bearer "+ownerToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+	})
+
+	assert.Nil(t, DeleteTestPackage(sharedPkgName, "npm"))
+	assert.Nil(t, DeleteTestPackage(frozenPkgName, "npm"))
+}
+
+func TestAccessPolicyAnonymous(t *testing.T) {
+	UseAuthProvider(t, config.AuthProviderLocal)
+	owner, ownerToken, _ := CreateTestUser(t, models.TokenScopeRead, models.TokenScopePush)
+	pkgName := owner.Namespace + "/" + uuid.NewString()
+
+	w, req := UploadTestNpmPackage(pkgName, "0.0.1")
+	req.Header.Set("Authorization", "Bearer "+ownerToken)
+	serverApp.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	anonymousPull := func() int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/"+pkgName, nil)
+		serverApp.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("should keep the private packages from the rules matching any subject", func(t *testing.T) {
+		UseTestPolicy(t, `
+rules:
+  - name: everyone-reads
+    effect: allow
+    subjects: ["*"]
+    namespaces: ["`+owner.Namespace+`"]
+    actions: [pull]
+`)
+		assert.Equal(t, 401, anonymousPull())
+	})
+
+	t.Run("should apply the rules naming the anonymous subject", func(t *testing.T) {
+		UseTestPolicy(t, `
+rules:
+  - name: anonymous-reads
+    effect: allow
+    subjects: ["anonymous"]
+    namespaces: ["`+owner.Namespace+`"]
+    actions: [pull]
+`)
+		assert.Equal(t, 200, anonymousPull())
+	})
+
+	assert.Nil(t, DeleteTestPackage(pkgName, "npm"))
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"bytes"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	rateLimitConfig := config.Get().RateLimit
+	t.Cleanup(func() {
+		config.Get().RateLimit = rateLimitConfig
+	})
+	config.Get().RateLimit.Metadata = config.RateLimitRule{Requests: 2, Window: time.Minute}
+
+	requestMetadata := func(remoteAddr string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/npm/"+uuid.NewString(), nil)
+		req.RemoteAddr = remoteAddr
+		serverApp.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, backend := range []string{config.RateLimitBackendMemory, config.RateLimitBackendDB} {
+		t.Run("should respond with 429 after the limit with the "+backend+" backend", func(t *testing.T) {
+			config.Get().RateLimit.Backend = backend
+			remoteAddr := "198.51.100." + strconv.Itoa(len(backend)) + ":1234"
+
+			for i := 0; i < 2; i++ {
+				w := requestMetadata(remoteAddr)
+				assert.NotEqual(t, 429, w.Code)
+			}
+
+			w := requestMetadata(remoteAddr)
+			assert.Equal(t, 429, w.Code)
+			retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+			assert.Nil(t, err)
+			assert.True(t, retryAfter > 0 && retryAfter <= 60)
+
+			// Other clients keep their own budget
+			w = requestMetadata("203.0.113.1:1234")
+			assert.NotEqual(t, 429, w.Code)
+		})
+	}
+
+	t.Run("should count the requests failing the authentication", func(t *testing.T) {
+		UseAuthProvider(t, config.AuthProviderLocal)
+		config.Get().RateLimit.Backend = config.RateLimitBackendMemory
+		codes := make([]int, 0)
+		for i := 0; i < 3; i++ {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/npm/"+uuid.NewString(), nil)
+			req.RemoteAddr = "198.51.100.20:1234"
+			req.Header.Set("Authorization", "Bearer pks_guess"+strconv.Itoa(i))
+			serverApp.ServeHTTP(w, req)
+			codes = append(codes, w.Code)
+		}
+		assert.Equal(t, []int{401, 401, 429}, codes)
+	})
+
+	t.Run("should ignore X-Forwarded-For from untrusted peers", func(t *testing.T) {
+		config.Get().RateLimit.Backend = config.RateLimitBackendMemory
+		var w *httptest.ResponseRecorder
+		for i := 0; i < 3; i++ {
+			w = httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/npm/"+uuid.NewString(), nil)
+			req.RemoteAddr = "198.51.100.30:1234"
+			req.Header.Set("X-Forwarded-For", "203.0.113."+strconv.Itoa(100+i))
+			serverApp.ServeHTTP(w, req)
+		}
+		assert.Equal(t, 429, w.Code)
+	})
+
+	t.Run("should authorize and limit the npm packument rewrites as deletes", func(t *testing.T) {
+		UseAuthProvider(t, config.AuthProviderLocal)
+		config.Get().RateLimit.Backend = config.RateLimitBackendMemory
+		config.Get().RateLimit.Delete = config.RateLimitRule{Requests: 1, Window: time.Minute}
+		user, pushToken, _ := CreateTestUser(t, models.TokenScopeRead, models.TokenScopePush)
+		codes := make([]int, 0)
+		for i := 0; i < 2; i++ {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("PUT", "/npm/"+user.Namespace+"/"+uuid.NewString()+"/-rev/1-0", bytes.NewBufferString(`{"versions": {}}`))
+			req.RemoteAddr = "198.51.100.50:1234"
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+pushToken)
+			serverApp.ServeHTTP(w, req)
+			codes = append(codes, w.Code)
+		}
+		assert.Equal(t, []int{403, 429}, codes)
+	})
+
+	t.Run("should not limit the routes without a rule", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/npm/"+uuid.NewString()+"/-/file.tgz", nil)
+			req.RemoteAddr = "198.51.100.10:1234"
+			serverApp.ServeHTTP(w, req)
+			assert.NotEqual(t, 429, w.Code)
+		}
+	})
+}
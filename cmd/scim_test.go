@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/middlewares"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/alin-io/pkgstore/services/scim"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func UseTestScim(t *testing.T, token string, groupMapping ...string) {
+	previous := config.Get().Auth.Scim
+	config.Get().Auth.Scim.Token = token
+	config.Get().Auth.Scim.GroupMapping = groupMapping
+	t.Cleanup(func() {
+		config.Get().Auth.Scim = previous
+	})
+}
+
+func ScimRequest(t *testing.T, method, path, body string, response any) int {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer scim-secret")
+	req.Header.Set("Content-Type", "application/scim+json")
+	serverApp.ServeHTTP(w, req)
+	if response != nil && w.Body.Len() > 0 {
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), response))
+	}
+	return w.Code
+}
+
+func TestScimProvisioning(t *testing.T) {
+	UseAuthProvider(t, config.AuthProviderLocal)
+	groupName := "Platform " + uuid.NewString()[:8]
+	mappedNamespace := uuid.NewString()[:8]
+	UseTestScim(t, "scim-secret", groupName+":"+mappedNamespace+":read")
+	userName := "scim-" + uuid.NewString()[:8]
+
+	t.Run("should require the SCIM token", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/scim/v2/Users", nil)
+		req.Header.Set("Authorization", "Bearer wrong")
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+	})
+
+	scimUser := scim.User{}
+	t.Run("should provision a user without permissions", func(t *testing.T) {
+		status := ScimRequest(t, "POST", "/scim/v2/Users", `{"schemas": ["`+scim.UserSchema+`"], "userName": "`+userName+`", "externalId": "ext-1", "active": true}`, &scimUser)
+		assert.Equal(t, 201, status)
+		assert.Equal(t, 409, ScimRequest(t, "POST", "/scim/v2/Users", `{"userName": "`+userName+`"}`, nil))
+
+		list := scim.ListResponse{}
+		assert.Equal(t, 200, ScimRequest(t, "GET", `/scim/v2/Users?filter=userName+eq+"`+userName+`"`, "", &list))
+		assert.Equal(t, 1, list.TotalResults)
+
+		user := models.User{}
+		assert.Nil(t, user.FillByName(userName))
+		assert.Equal(t, userName, user.Namespace)
+		assert.Equal(t, models.UserScopesNone, user.Scopes)
+		assert.True(t, user.ScimManaged)
+	})
+
+	user := models.User{}
+	assert.Nil(t, user.FillByName(userName))
+	plainToken, _, err := models.NewApiToken(&user, "test", models.AllTokenScopes, 0)
+	assert.Nil(t, err)
+	pushPackage := func(pkgName string) int {
+		w, req := UploadTestNpmPackage(pkgName, "0.0.1")
+		req.Header.Set("Authorization", "Bearer "+plainToken)
+		serverApp.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	scimGroup := scim.Group{}
+	t.Run("should map the group members to the namespace and the role of the group", func(t *testing.T) {
+		status := ScimRequest(t, "POST", "/scim/v2/Groups", `{"displayName": "`+groupName+`", "members": [{"value": "`+scimUser.Id+`"}]}`, &scimGroup)
+		assert.Equal(t, 201, status)
+		assert.Len(t, scimGroup.Members, 1)
+
+		assert.Nil(t, user.FillByName(userName))
+		assert.Equal(t, mappedNamespace, user.Namespace)
+		assert.Equal(t, models.TokenScopeRead, user.Scopes)
+		assert.Equal(t, 403, pushPackage(mappedNamespace+"/"+uuid.NewString()))
+
+		status = ScimRequest(t, "PATCH", "/scim/v2/Groups/"+scimGroup.Id, `{"schemas": ["`+scim.PatchOpSchema+`"], "Operations": [{"op": "remove", "path": "members[value eq \"`+scimUser.Id+`\"]"}]}`, &scimGroup)
+		assert.Equal(t, 200, status)
+		assert.Len(t, scimGroup.Members, 0)
+
+		user = models.User{}
+		assert.Nil(t, user.FillByName(userName))
+		assert.Equal(t, userName, user.Namespace)
+		assert.Equal(t, 403, pushPackage(userName+"/"+uuid.NewString()))
+	})
+
+	t.Run("should revoke the tokens on deactivation", func(t *testing.T) {
+		code, containerToken := RequestContainerToken(t, userName, plainToken, url.Values{"offline_token": {"true"}})
+		assert.Equal(t, 200, code)
+		assert.NotEmpty(t, containerToken.RefreshToken)
+		_, err := middlewares.ParseIssuedToken(containerToken.Token, middlewares.IssuedTokenTypeAccess)
+		assert.Nil(t, err)
+
+		status := ScimRequest(t, "PATCH", "/scim/v2/Users/"+scimUser.Id, `{"schemas": ["`+scim.PatchOpSchema+`"], "Operations": [{"op": "Replace", "path": "active", "value": "False"}]}`, &scimUser)
+		assert.Equal(t, 200, status)
+		assert.False(t, *scimUser.Active)
+
+		tokens, err := user.Tokens()
+		assert.Nil(t, err)
+		assert.NotNil(t, tokens[0].RevokedAt)
+		assert.Equal(t, 401, pushPackage(userName+"/"+uuid.NewString()))
+		// The short-lived access tokens aren't recorded, they are refused by their subject
+		_, err = middlewares.ParseIssuedToken(containerToken.Token, middlewares.IssuedTokenTypeAccess)
+		assert.NotNil(t, err)
+
+		// Reactivating the user doesn't bring the revoked tokens back
+		status = ScimRequest(t, "PATCH", "/scim/v2/Users/"+scimUser.Id, `{"schemas": ["`+scim.PatchOpSchema+`"], "Operations": [{"op": "Replace", "path": "active", "value": "True"}]}`, &scimUser)
+		assert.Equal(t, 200, status)
+		_, err = middlewares.ExchangeRefreshToken(containerToken.RefreshToken)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("should delete the users and the groups", func(t *testing.T) {
+		claims := &middlewares.IssuedTokenClaims{TokenType: middlewares.IssuedTokenTypeAccess}
+		claims.Subject = userName
+		accessToken, err := middlewares.SignIssuedToken(claims, time.Minute)
+		assert.Nil(t, err)
+		_, err = middlewares.ParseIssuedToken(accessToken, middlewares.IssuedTokenTypeAccess)
+		assert.Nil(t, err)
+
+		assert.Equal(t, 204, ScimRequest(t, "DELETE", "/scim/v2/Groups/"+scimGroup.Id, "", nil))
+		assert.Equal(t, 204, ScimRequest(t, "DELETE", "/scim/v2/Users/"+scimUser.Id, "", nil))
+		assert.Equal(t, 404, ScimRequest(t, "GET", "/scim/v2/Users/"+scimUser.Id, "", nil))
+		_, err = middlewares.ParseIssuedToken(accessToken, middlewares.IssuedTokenTypeAccess)
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/alin-io/pkgstore/services/npm"
+	"github.com/alin-io/pkgstore/storage"
+	"github.com/google/uuid"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const adminUsage = `Usage:
+  pkgstore user add <name> <namespace>
+  pkgstore user list
+  pkgstore user disable <name>
+  pkgstore user enable <name>
+  pkgstore token create [-name <name>] [-scopes read,push,delete] [-expires 720h] <user>
+  pkgstore token list <user>
+  pkgstore token revoke <token-id>
+  pkgstore advisories import <osv directory, zip or json file>
+  pkgstore npm migrate-manifests`
+
+// runAdminCommand handles the user/token management commands of the built-in auth provider, the advisory imports
+// and the one-off migrations
+func runAdminCommand(args []string, storageBackend storage.BaseStorageBackend) error {
+	if len(args) < 2 {
+		return errors.New(adminUsage)
+	}
+
+	switch args[0] + " " + args[1] {
+	case "user add":
+		if len(args) != 4 {
+			return errors.New(adminUsage)
+		}
+		user := models.User{Name: args[2], Namespace: args[3]}
+		if err := user.Insert(); err != nil {
+			return err
+		}
+		fmt.Println("Created user", user.Name, "with namespace", user.Namespace)
+	case "user list":
+		users, err := models.ListUsers()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "NAME\tNAMESPACE\tDISABLED\tCREATED")
+		for _, user := range users {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%t\t%s\n", user.Name, user.Namespace, user.Disabled, user.CreatedAt.Format(time.RFC3339))
+		}
+		return w.Flush()
+	case "user disable", "user enable":
+		if len(args) != 3 {
+			return errors.New(adminUsage)
+		}
+		user, err := findUser(args[2])
+		if err != nil {
+			return err
+		}
+		user.Disabled = args[1] == "disable"
+		if err = user.Save(); err != nil {
+			return err
+		}
+		if user.Disabled {
+			if err = user.RevokeTokens(); err != nil {
+				return err
+			}
+		}
+		fmt.Println("User", user.Name, args[1]+"d")
+	case "token create":
+		flags := flag.NewFlagSet("token create", flag.ContinueOnError)
+		name := flags.String("name", "", "token description")
+		scopes := flags.String("scopes", models.TokenScopeRead, "comma separated list of read, push, delete")
+		expires := flags.Duration("expires", 0, "token lifetime, never expires when 0")
+		if err := flags.Parse(args[2:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return errors.New(adminUsage)
+		}
+		user, err := findUser(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		plainToken, token, err := models.NewApiToken(user, *name, strings.Split(*scopes, ","), *expires)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Token ID:", token.ID)
+		fmt.Println("Token:", plainToken)
+		fmt.Println("Store the token now, it can't be shown again.")
+	case "token list":
+		if len(args) != 3 {
+			return errors.New(adminUsage)
+		}
+		user, err := findUser(args[2])
+		if err != nil {
+			return err
+		}
+		tokens, err := user.Tokens()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "ID\tNAME\tSCOPES\tEXPIRES\tACTIVE")
+		for _, token := range tokens {
+			expires := "never"
+			if token.ExpiresAt != nil {
+				expires = token.ExpiresAt.Format(time.RFC3339)
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\n", token.ID, token.Name, token.Scopes, expires, token.IsActive())
+		}
+		return w.Flush()
+	case "token revoke":
+		if len(args) != 3 {
+			return errors.New(adminUsage)
+		}
+		tokenId, err := uuid.Parse(args[2])
+		if err != nil {
+			return err
+		}
+		token := models.ApiToken{}
+		if err = token.FillById(tokenId); err != nil {
+			return err
+		}
+		if token.ID == uuid.Nil {
+			return errors.New("token not found")
+		}
+		if err = token.Revoke(); err != nil {
+			return err
+		}
+		fmt.Println("Token", token.ID, "revoked")
+	case "advisories import":
+		if len(args) != 3 {
+			return errors.New(adminUsage)
+		}
+		imported, err := npm.ImportOsvAdvisories(args[2])
+		if err != nil {
+			return err
+		}
+		fmt.Println("Imported", imported, "npm advisories")
+	case "npm migrate-manifests":
+		if len(args) != 2 {
+			return errors.New(adminUsage)
+		}
+		migrated, err := npm.NewService(storageBackend).MigrateManifests()
+		if err != nil {
+			return err
+		}
+		fmt.Println("Migrated", migrated, "npm version manifests")
+	default:
+		return errors.New(adminUsage)
+	}
+
+	return nil
+}
+
+func findUser(name string) (*models.User, error) {
+	user := &models.User{}
+	if err := user.FillByName(name); err != nil {
+		return nil, err
+	}
+	if user.ID == uuid.Nil {
+		return nil, fmt.Errorf("user %q not found", name)
+	}
+	return user, nil
+}
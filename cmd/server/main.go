@@ -1,10 +1,14 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"embed"
+	"errors"
 	"github.com/alin-io/pkgstore/config"
 	"github.com/alin-io/pkgstore/db"
 	_ "github.com/alin-io/pkgstore/db"
+	"github.com/alin-io/pkgstore/middlewares"
 	"github.com/alin-io/pkgstore/models"
 	"github.com/alin-io/pkgstore/router"
 	"github.com/alin-io/pkgstore/services"
@@ -52,6 +56,36 @@ func main() {
 		return
 	}
 
+	if len(os.Args) > 1 && (os.Args[1] == "user" || os.Args[1] == "token" || os.Args[1] == "advisories" || os.Args[1] == "npm") {
+		err := runAdminCommand(os.Args[1:], storageBackend)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	// Fail fast on a misconfigured auth provider instead of on the first request
+	_ = middlewares.ActiveAuthProvider()
+	if config.Get().Auth.Provider == config.AuthProviderHtpasswd {
+		if err := middlewares.LoadHtpasswd(); err != nil {
+			log.Fatalln("Unable to load the htpasswd files: ", err)
+		}
+	}
+	if err := middlewares.LoadMtlsRules(); err != nil {
+		log.Fatalln("Unable to load the mtls rules: ", err)
+	}
+	if len(config.Get().Auth.PolicyFile) > 0 {
+		if err := middlewares.LoadPolicy(); err != nil {
+			log.Fatalln("Unable to load the policy file: ", err)
+		}
+	}
+
+	if len(config.Get().MetricsAddress) > 0 {
+		go func() {
+			log.Println("Metrics server stopped: ", router.SetupMetricsServer().ListenAndServe())
+		}()
+	}
+
 	r := router.SetupGinServer()
 	// Setup Cors if we are in Debug mode, otherwise UI would be under the same domain name
 	if gin.Mode() == gin.DebugMode {
@@ -84,12 +118,42 @@ func main() {
 
 	router.PackageRouter(r, storageBackend)
 
-	err := r.Run(config.Get().ListenAddress)
+	var err error
+	if tlsConfig := config.Get().Tls; len(tlsConfig.CertFile) > 0 {
+		server := &http.Server{Addr: config.Get().ListenAddress, Handler: r}
+		server.TLSConfig, err = newTlsConfig()
+		if err != nil {
+			log.Fatalln("Unable to load the client CA bundle: ", err)
+		}
+		err = server.ListenAndServeTLS(tlsConfig.CertFile, tlsConfig.KeyFile)
+	} else {
+		err = r.Run(config.Get().ListenAddress)
+	}
 	if err != nil {
 		panic(err)
 	}
 }
 
+// newTlsConfig verifies the client certificates against the CA bundle when one is configured,
+// clients without a certificate can still use tokens
+func newTlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(config.Get().Tls.ClientCaFile) == 0 {
+		return tlsConfig, nil
+	}
+
+	caBundle, err := os.ReadFile(config.Get().Tls.ClientCaFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(caBundle) {
+		return nil, errors.New("no certificates found in " + config.Get().Tls.ClientCaFile)
+	}
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsConfig, nil
+}
+
 func serveIndexTemplate(c *gin.Context) {
 	c.HTML(http.StatusOK, "index.html", gin.H{
 		"title": "Main website",
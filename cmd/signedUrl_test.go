@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func UseTestSignedUrlKeys(t *testing.T, keys ...config.SignedUrlKey) {
+	previous := config.Get().SignedUrls.Keys
+	config.Get().SignedUrls.Keys = keys
+	t.Cleanup(func() {
+		config.Get().SignedUrls.Keys = previous
+	})
+}
+
+func TestSignedUrls(t *testing.T) {
+	UseAuthProvider(t, config.AuthProviderLocal)
+	UseTestSignedUrlKeys(t, config.SignedUrlKey{Id: "k1", Secret: "first-secret"})
+	user, plainToken, _ := CreateTestUser(t, models.TokenScopeRead, models.TokenScopePush)
+	pkgName := user.Namespace + "/" + uuid.NewString()
+
+	w, req := UploadTestNpmPackage(pkgName, "0.0.1")
+	req.Header.Set("Authorization", "Bearer "+plainToken)
+	serverApp.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	w, req, _ = UploadTestPypiPackage(pkgName, "0.0.1")
+	req.SetBasicAuth("__token__", plainToken)
+	serverApp.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	createSignedUrl := func(body string) (int, string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/signed-urls", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+plainToken)
+		serverApp.ServeHTTP(w, req)
+		response := struct {
+			Url string `json:"url"`
+		}{}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		if len(response.Url) == 0 {
+			return w.Code, ""
+		}
+		signedUrl, err := url.Parse(response.Url)
+		assert.Nil(t, err)
+		return w.Code, signedUrl.RequestURI()
+	}
+
+	download := func(requestUri string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", requestUri, nil)
+		serverApp.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("should download the files with a signed link and no token", func(t *testing.T) {
+		status, npmUri := createSignedUrl(`{"service": "npm", "package": "` + pkgName + `", "version": "0.0.1", "ttl": 60}`)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, 200, download(npmUri))
+
+		status, pypiUri := createSignedUrl(`{"service": "pypi", "package": "` + pkgName + `", "version": "0.0.1"}`)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, 200, download(pypiUri))
+	})
+
+	t.Run("should only sign the blobs of the package", func(t *testing.T) {
+		pkg := models.Package[any]{Name: pkgName, Namespace: user.Namespace, Service: "container", AuthId: user.Name}
+		assert.Nil(t, pkg.Insert())
+		layer := models.Asset{Service: "container", Digest: fmt.Sprintf("%x", sha256.Sum256([]byte(uuid.NewString()))), UploadUUID: uuid.NewString()}
+		assert.Nil(t, layer.Insert())
+		otherLayer := models.Asset{Service: "container", Digest: fmt.Sprintf("%x", sha256.Sum256([]byte(uuid.NewString()))), UploadUUID: uuid.NewString()}
+		assert.Nil(t, otherLayer.Insert())
+		assert.Nil(t, pkg.InsertVersion(models.PackageVersion[any]{
+			Version: "latest", Namespace: user.Namespace, Service: "container", Digest: layer.Digest, AssetIds: layer.ID.String(),
+		}))
+
+		status, _ := createSignedUrl(`{"service": "container", "package": "` + pkgName + `", "digest": "sha256:` + layer.Digest + `"}`)
+		assert.Equal(t, 200, status)
+		status, _ = createSignedUrl(`{"service": "container", "package": "` + pkgName + `", "digest": "sha256:` + otherLayer.Digest + `"}`)
+		assert.Equal(t, 404, status)
+		assert.Nil(t, pkg.Delete())
+	})
+
+	t.Run("should rate limit every link on its own", func(t *testing.T) {
+		rateLimitConfig := config.Get().RateLimit
+		t.Cleanup(func() {
+			config.Get().RateLimit = rateLimitConfig
+		})
+		config.Get().RateLimit.Backend = config.RateLimitBackendMemory
+		config.Get().RateLimit.Download = config.RateLimitRule{Requests: 1, Window: time.Minute}
+
+		_, firstUri := createSignedUrl(`{"service": "npm", "package": "` + pkgName + `", "version": "0.0.1", "ttl": 61}`)
+		_, secondUri := createSignedUrl(`{"service": "npm", "package": "` + pkgName + `", "version": "0.0.1", "ttl": 62}`)
+		downloadFrom := func(requestUri, remoteAddr string) int {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", requestUri, nil)
+			req.RemoteAddr = remoteAddr
+			serverApp.ServeHTTP(w, req)
+			return w.Code
+		}
+		assert.Equal(t, 200, downloadFrom(firstUri, "198.51.100.60:1234"))
+		assert.Equal(t, 429, downloadFrom(firstUri, "198.51.100.61:1234"))
+		assert.Equal(t, 200, downloadFrom(secondUri, "198.51.100.62:1234"))
+	})
+
+	t.Run("should reject tampered and expired links", func(t *testing.T) {
+		_, npmUri := createSignedUrl(`{"service": "npm", "package": "` + pkgName + `", "version": "0.0.1"}`)
+		assert.Equal(t, 403, download(strings.Replace(npmUri, "0.0.1", "0.0.2", -1)))
+		assert.Equal(t, 403, download(strings.Replace(npmUri, "kid=k1", "kid=k2", 1)))
+
+		signedUrl, _ := url.Parse(npmUri)
+		query := signedUrl.Query()
+		query.Set("expires", "1")
+		assert.Equal(t, 403, download(signedUrl.Path+"?"+query.Encode()))
+	})
+
+	t.Run("should limit the lifetime of the links", func(t *testing.T) {
+		status, _ := createSignedUrl(`{"service": "npm", "package": "` + pkgName + `", "version": "0.0.1", "ttl": 100000000}`)
+		assert.Equal(t, 400, status)
+		status, _ = createSignedUrl(`{"service": "npm", "package": "` + pkgName + `", "version": "9.9.9"}`)
+		assert.Equal(t, 404, status)
+	})
+
+	t.Run("should revoke the links when the key is removed", func(t *testing.T) {
+		_, npmUri := createSignedUrl(`{"service": "npm", "package": "` + pkgName + `", "version": "0.0.1"}`)
+		config.Get().SignedUrls.Keys = []config.SignedUrlKey{{Id: "k2", Secret: "second-secret"}}
+		assert.Equal(t, 403, download(npmUri))
+	})
+
+	t.Run("should audit the creation and the downloads", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/audit-logs", nil)
+		req.Header.Set("Authorization", "Bearer "+plainToken)
+		serverApp.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		auditLogs := make([]models.AuditLog, 0)
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &auditLogs))
+		events := map[string]int{}
+		for _, auditLog := range auditLogs {
+			events[auditLog.Event]++
+		}
+		assert.Equal(t, 7, events[models.AuditEventSignedUrlCreate])
+		assert.Equal(t, 5, events[models.AuditEventSignedUrlDownload])
+	})
+
+	assert.Nil(t, DeleteTestPackage(pkgName, "npm"))
+	assert.Nil(t, DeleteTestPackage(pkgName, "pypi"))
+}
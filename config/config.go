@@ -5,6 +5,9 @@ import (
 	_ "github.com/joho/godotenv/autoload"
 
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
@@ -15,6 +18,22 @@ const (
 	StorageS3         = "s3"
 	StorageFileSystem = "filesystem"
 
+	AuthProviderEndpoint = "endpoint"
+	AuthProviderLocal    = "local"
+	AuthProviderJwt      = "jwt"
+	AuthProviderHtpasswd = "htpasswd"
+	AuthProviderLdap     = "ldap"
+	// AuthProviderMtls only accepts client certificates, which are checked before the tokens of any other provider
+	AuthProviderMtls = "mtls"
+
+	// NpmProxyPassthrough forwards the requests of the packages missing from pkgstore to the public registry,
+	// NpmProxyCache serves them from the cached metadata and tarballs
+	NpmProxyPassthrough = "passthrough"
+	NpmProxyCache       = "cache"
+
+	RateLimitBackendMemory = "memory"
+	RateLimitBackendDB     = "db"
+
 	// NumberOfPkgNameLevels PkgName Levels (e.g. /npm/@username/package-name)
 	NumberOfPkgNameLevels = 2
 )
@@ -29,13 +48,136 @@ func Get() *ProjectConfigType {
 
 type ProjectConfigType struct {
 	ListenAddress string
-	DatabaseUrl   string
-	AuthEndpoint  string
+	// TrustedProxies are the CIDRs allowed to set X-Forwarded-For, the client IP is the peer address otherwise
+	TrustedProxies []string
+	// MetricsAddress serves /debug/vars on a separate listener, disabled when empty
+	MetricsAddress string
+	DatabaseUrl    string
+	AuthEndpoint   string
+	Auth           struct {
+		Provider string
+		// Endpoint tunes the client of AUTH_ENDPOINT
+		Endpoint struct {
+			Timeout          time.Duration
+			CacheTTL         time.Duration
+			CacheSize        int
+			NegativeCacheTTL time.Duration
+			// StaleIfError keeps answering pulls from the expired cache while the endpoint is failing
+			StaleIfError time.Duration
+		}
+		// TokenSecret signs the tokens issued by pkgstore, a random one is used when empty
+		TokenSecret     string
+		TokenTTL        time.Duration
+		RefreshTokenTTL time.Duration
+		// SessionTokenTTL is the lifetime of the tokens issued by "npm login"
+		SessionTokenTTL time.Duration
+		// Oidc configures the CI identity providers trusted for publishing
+		Oidc struct {
+			Issuers         []string
+			Audience        string
+			PublishTokenTTL time.Duration
+		}
+		Jwt struct {
+			JwksFile            string
+			JwksUrl             string
+			JwksRefreshInterval time.Duration
+			Issuer              string
+			Audience            string
+			// Claims are the JWT claim names mapped to the AuthResult fields,
+			// "claim=value" checks that the claim is or contains the value
+			Claims struct {
+				AuthId    string
+				Namespace string
+				Read      string
+				Write     string
+				Delete    string
+			}
+		}
+		// Htpasswd is an Apache htpasswd file with bcrypt entries, MappingFile assigns
+		// the namespace and permissions of the users as "user:namespace:read,push,delete"
+		Htpasswd struct {
+			File        string
+			MappingFile string
+		}
+		// Ldap binds with the basic auth credentials of the users. The user DN comes from UserDn ("uid=%s,ou=people,dc=example,dc=com"),
+		// or from a search of UserFilter under BaseDn with the BindDn service account.
+		// GroupRules map the users to the namespace and permissions as "dn=<group dn>:namespace:read,push" or "filter=<ldap filter>:namespace:read"
+		Ldap struct {
+			Url           string
+			StartTls      bool
+			Timeout       time.Duration
+			BindDn        string
+			BindPassword  string
+			BaseDn        string
+			UserDn        string
+			UserFilter    string
+			GroupBaseDn   string
+			GroupFilter   string
+			GroupRules    []string
+			CacheTTL      time.Duration
+			TlsSkipVerify bool
+		}
+		// PolicyFile is an optional YAML policy evaluated before the provider permissions
+		PolicyFile string
+		// Mtls rules map verified client certificates to the namespace and permissions
+		// as "field=pattern:namespace:read,push,delete"
+		Mtls struct {
+			Rules []string
+		}
+		// Scim provisions the built-in users from an identity provider, it's disabled without a token.
+		// GroupMapping assigns the namespace and permissions of the group members as "group:namespace:read,push,delete"
+		Scim struct {
+			Token        string
+			GroupMapping []string
+		}
+	}
+	// Tls serves HTTPS when the certificate is set, ClientCaFile enables the client certificate verification
+	Tls struct {
+		CertFile     string
+		KeyFile      string
+		ClientCaFile string
+	}
 	RegistryHosts struct {
 		Pypi      string
 		Npm       string
 		Container string
 	}
+	// SignedUrls are the HMAC keys of the download links, the first key signs the new links
+	// and removing a key revokes every link signed with it
+	SignedUrls struct {
+		Keys       []SignedUrlKey
+		DefaultTTL time.Duration
+		MaxTTL     time.Duration
+	}
+	// Npm registry behaviour, UnpublishWindow is how long after the publish a version can be unpublished,
+	// zero removes the limit. MetadataCacheSize is the number of rendered metadata documents kept in memory.
+	// TrustForwardedHost builds the tarball URLs from the Host and X-Forwarded-* headers instead of RegistryHosts.Npm.
+	// ProxyMode is how the packages missing from pkgstore are served from UpstreamUrl, the cached metadata
+	// is revalidated after ProxyMetadataTTL. SearchUpstream merges the search results of UpstreamUrl.
+	Npm struct {
+		UnpublishWindow    time.Duration
+		MetadataCacheSize  int
+		TrustForwardedHost bool
+		UpstreamUrl        string
+		ProxyMode          string
+		ProxyMetadataTTL   time.Duration
+		SearchUpstream     bool
+	}
+	// Proxy protects the private packages from dependency confusion, the names in ReservedNamespaces,
+	// matching the ReservedNames globs, or that ever existed in pkgstore are never fetched from the public registries
+	Proxy struct {
+		ReservedNamespaces []string
+		ReservedNames      []string
+	}
+	RateLimit struct {
+		// Backend keeps the counters in memory, or in the DB to share them between replicas
+		Backend  string
+		Metadata RateLimitRule
+		Download RateLimitRule
+		Publish  RateLimitRule
+		Delete   RateLimitRule
+		Login    RateLimitRule
+	}
 	Storage struct {
 		ActiveBackend  string
 		FileSystemRoot string
@@ -51,14 +193,125 @@ type ProjectConfigType struct {
 
 func (c *ProjectConfigType) Init() {
 	c.ListenAddress = GetEnv("LISTEN_ADDRESS", ":8080")
+	c.TrustedProxies = GetEnvList("TRUSTED_PROXIES", "")
+	c.MetricsAddress = GetEnv("METRICS_ADDRESS", "")
 	c.AuthEndpoint = GetEnv("AUTH_ENDPOINT", "")
 
+	// TLS and client certificates
+	c.Tls.CertFile = GetEnv("TLS_CERT_FILE", "")
+	c.Tls.KeyFile = GetEnv("TLS_KEY_FILE", "")
+	c.Tls.ClientCaFile = GetEnv("TLS_CLIENT_CA_FILE", "")
+
+	// Auth Provider, falls back to the remote endpoint when AUTH_ENDPOINT is set
+	if len(c.AuthEndpoint) > 0 {
+		c.Auth.Provider = GetEnv("AUTH_PROVIDER", AuthProviderEndpoint)
+	} else {
+		c.Auth.Provider = os.Getenv("AUTH_PROVIDER")
+	}
+
+	// Auth Endpoint client
+	c.Auth.Endpoint.Timeout = GetEnvDuration("AUTH_ENDPOINT_TIMEOUT", 10*time.Second)
+	c.Auth.Endpoint.CacheTTL = GetEnvDuration("AUTH_CACHE_TTL", 10*time.Second)
+	c.Auth.Endpoint.CacheSize = GetEnvInt("AUTH_CACHE_SIZE", 1000)
+	c.Auth.Endpoint.NegativeCacheTTL = GetEnvDuration("AUTH_NEGATIVE_CACHE_TTL", 2*time.Second)
+	c.Auth.Endpoint.StaleIfError = GetEnvDuration("AUTH_STALE_IF_ERROR", 0)
+
+	// Tokens issued by pkgstore (e.g. Docker registry tokens)
+	c.Auth.TokenSecret = GetEnv("AUTH_TOKEN_SECRET", "")
+	c.Auth.TokenTTL = GetEnvDuration("AUTH_TOKEN_TTL", 5*time.Minute)
+	c.Auth.RefreshTokenTTL = GetEnvDuration("AUTH_REFRESH_TOKEN_TTL", 24*time.Hour)
+	c.Auth.SessionTokenTTL = GetEnvDuration("AUTH_SESSION_TOKEN_TTL", 7*24*time.Hour)
+
+	// Trusted Publishing Config
+	c.Auth.Oidc.Issuers = GetEnvList("OIDC_ISSUERS", "https://token.actions.githubusercontent.com")
+	c.Auth.Oidc.Audience = GetEnv("OIDC_AUDIENCE", "pkgstore")
+	c.Auth.Oidc.PublishTokenTTL = GetEnvDuration("OIDC_PUBLISH_TOKEN_TTL", 15*time.Minute)
+
+	// JWT Auth Provider Config
+	c.Auth.Jwt.JwksFile = GetEnv("JWT_JWKS_FILE", "")
+	c.Auth.Jwt.JwksUrl = GetEnv("JWT_JWKS_URL", "")
+	c.Auth.Jwt.JwksRefreshInterval = GetEnvDuration("JWT_JWKS_REFRESH_INTERVAL", time.Hour)
+	c.Auth.Jwt.Issuer = GetEnv("JWT_ISSUER", "")
+	c.Auth.Jwt.Audience = GetEnv("JWT_AUDIENCE", "")
+	c.Auth.Jwt.Claims.AuthId = GetEnv("JWT_CLAIM_AUTH_ID", "sub")
+	c.Auth.Jwt.Claims.Namespace = GetEnv("JWT_CLAIM_NAMESPACE", "namespace")
+	c.Auth.Jwt.Claims.Read = GetEnv("JWT_CLAIM_READ", "read")
+	c.Auth.Jwt.Claims.Write = GetEnv("JWT_CLAIM_WRITE", "write")
+	c.Auth.Jwt.Claims.Delete = GetEnv("JWT_CLAIM_DELETE", "delete")
+
+	// Htpasswd Auth Provider Config
+	c.Auth.Htpasswd.File = GetEnv("HTPASSWD_FILE", "htpasswd")
+	c.Auth.Htpasswd.MappingFile = GetEnv("HTPASSWD_MAPPING_FILE", "")
+
+	// LDAP Auth Provider Config, the group rules are separated by ";" since the DNs are comma separated
+	c.Auth.Ldap.Url = GetEnv("LDAP_URL", "ldap://localhost:389")
+	c.Auth.Ldap.StartTls = GetEnv("LDAP_START_TLS", "false") == "true"
+	c.Auth.Ldap.TlsSkipVerify = GetEnv("LDAP_TLS_SKIP_VERIFY", "false") == "true"
+	c.Auth.Ldap.Timeout = GetEnvDuration("LDAP_TIMEOUT", 5*time.Second)
+	c.Auth.Ldap.BindDn = GetEnv("LDAP_BIND_DN", "")
+	c.Auth.Ldap.BindPassword = GetEnv("LDAP_BIND_PASSWORD", "")
+	c.Auth.Ldap.BaseDn = GetEnv("LDAP_BASE_DN", "")
+	c.Auth.Ldap.UserDn = GetEnv("LDAP_USER_DN", "")
+	c.Auth.Ldap.UserFilter = GetEnv("LDAP_USER_FILTER", "(uid=%s)")
+	c.Auth.Ldap.GroupBaseDn = GetEnv("LDAP_GROUP_BASE_DN", "")
+	c.Auth.Ldap.GroupFilter = GetEnv("LDAP_GROUP_FILTER", "(member=%s)")
+	c.Auth.Ldap.CacheTTL = GetEnvDuration("LDAP_CACHE_TTL", time.Minute)
+	c.Auth.Ldap.GroupRules = make([]string, 0)
+	for _, rule := range strings.Split(GetEnv("LDAP_GROUP_RULES", ""), ";") {
+		if rule = strings.TrimSpace(rule); len(rule) > 0 {
+			c.Auth.Ldap.GroupRules = append(c.Auth.Ldap.GroupRules, rule)
+		}
+	}
+
+	c.Auth.PolicyFile = GetEnv("POLICY_FILE", "")
+
+	// Client certificate rules, separated by ";" since the permissions are comma separated
+	c.Auth.Mtls.Rules = make([]string, 0)
+	for _, rule := range strings.Split(GetEnv("MTLS_RULES", ""), ";") {
+		if rule = strings.TrimSpace(rule); len(rule) > 0 {
+			c.Auth.Mtls.Rules = append(c.Auth.Mtls.Rules, rule)
+		}
+	}
+
+	// SCIM provisioning, the group mapping is separated by ";" as well
+	c.Auth.Scim.Token = GetEnv("SCIM_TOKEN", "")
+	c.Auth.Scim.GroupMapping = make([]string, 0)
+	for _, mapping := range strings.Split(GetEnv("SCIM_GROUP_MAPPING", ""), ";") {
+		if mapping = strings.TrimSpace(mapping); len(mapping) > 0 {
+			c.Auth.Scim.GroupMapping = append(c.Auth.Scim.GroupMapping, mapping)
+		}
+	}
+
 	c.RegistryHosts.Npm = GetEnv("REGISTRY_HOST_NPM", "http://localhost:8080/npm")
 	c.RegistryHosts.Pypi = GetEnv("REGISTRY_HOST_PYPI", "http://localhost:8080/pypi")
 	c.RegistryHosts.Container = GetEnv("REGISTRY_HOST_CONTAINER", "http://host.docker.internal:8080/v2")
 
 	c.DatabaseUrl = GetEnv("DATABASE_URL", "file::memory:?cache=shared")
 
+	// Signed download links as "<key id>:<secret>,<key id>:<secret>"
+	c.SignedUrls.Keys = GetEnvSignedUrlKeys("SIGNED_URL_KEYS")
+	c.SignedUrls.DefaultTTL = GetEnvDuration("SIGNED_URL_DEFAULT_TTL", time.Hour)
+	c.SignedUrls.MaxTTL = GetEnvDuration("SIGNED_URL_MAX_TTL", 7*24*time.Hour)
+
+	c.Npm.UnpublishWindow = GetEnvDuration("NPM_UNPUBLISH_WINDOW", 72*time.Hour)
+	c.Npm.MetadataCacheSize = GetEnvInt("NPM_METADATA_CACHE_SIZE", 500)
+	c.Npm.TrustForwardedHost = GetEnv("NPM_TRUST_FORWARDED_HOST", "false") == "true"
+	c.Npm.UpstreamUrl = strings.TrimSuffix(GetEnv("NPM_UPSTREAM_URL", "https://registry.npmjs.org"), "/")
+	c.Npm.ProxyMode = GetEnv("NPM_PROXY_MODE", NpmProxyPassthrough)
+	c.Npm.ProxyMetadataTTL = GetEnvDuration("NPM_PROXY_METADATA_TTL", 5*time.Minute)
+	c.Npm.SearchUpstream = GetEnv("NPM_SEARCH_UPSTREAM", "false") == "true"
+
+	c.Proxy.ReservedNamespaces = GetEnvList("PROXY_RESERVED_NAMESPACES", "")
+	c.Proxy.ReservedNames = GetEnvList("PROXY_RESERVED_NAMES", "")
+
+	// Rate Limits, e.g. "600/1m", disabled when empty
+	c.RateLimit.Backend = GetEnv("RATE_LIMIT_BACKEND", RateLimitBackendMemory)
+	c.RateLimit.Metadata = GetEnvRateLimit("RATE_LIMIT_METADATA", "")
+	c.RateLimit.Download = GetEnvRateLimit("RATE_LIMIT_DOWNLOAD", "")
+	c.RateLimit.Publish = GetEnvRateLimit("RATE_LIMIT_PUBLISH", "")
+	c.RateLimit.Delete = GetEnvRateLimit("RATE_LIMIT_DELETE", "")
+	c.RateLimit.Login = GetEnvRateLimit("RATE_LIMIT_LOGIN", "10/1m")
+
 	// Storage Backend
 	c.Storage.ActiveBackend = GetEnv("STORAGE_BACKEND", StorageFileSystem)
 
@@ -73,6 +326,83 @@ func (c *ProjectConfigType) Init() {
 	c.Storage.FileSystemRoot = GetEnv("STORAGE_BACKEND_FILESYSTEM_ROOT", "")
 }
 
+type SignedUrlKey struct {
+	Id     string
+	Secret string
+}
+
+func GetEnvSignedUrlKeys(key string) []SignedUrlKey {
+	keys := make([]SignedUrlKey, 0)
+	for _, item := range GetEnvList(key, "") {
+		id, secret, found := strings.Cut(item, ":")
+		if !found || len(id) == 0 || len(secret) == 0 {
+			panic("Invalid signed url key in environment variable - " + key)
+		}
+		keys = append(keys, SignedUrlKey{Id: id, Secret: secret})
+	}
+	return keys
+}
+
+type RateLimitRule struct {
+	Requests int
+	Window   time.Duration
+}
+
+// GetEnvRateLimit parses a "<requests>/<window>" environment variable like "100/1m"
+func GetEnvRateLimit(key, fallback string) RateLimitRule {
+	value := GetEnv(key, fallback)
+	if len(value) == 0 {
+		return RateLimitRule{}
+	}
+	requests, window, _ := strings.Cut(value, "/")
+	rule := RateLimitRule{}
+	var err error
+	rule.Requests, err = strconv.Atoi(requests)
+	if err == nil {
+		rule.Window, err = time.ParseDuration(window)
+	}
+	if err != nil || rule.Requests <= 0 || rule.Window <= 0 {
+		panic("Invalid rate limit in environment variable - " + key)
+	}
+	return rule
+}
+
+// GetEnvList splits a comma separated environment variable
+func GetEnvList(key, fallback string) []string {
+	result := make([]string, 0)
+	for _, item := range strings.Split(GetEnv(key, fallback), ",") {
+		item = strings.TrimSpace(item)
+		if len(item) > 0 {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func GetEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return fallback
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		panic("Invalid number in environment variable - " + key)
+	}
+	return result
+}
+
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		panic("Invalid duration in environment variable - " + key)
+	}
+	return duration
+}
+
 func GetEnv(keyAndFallback ...string) string {
 	key := keyAndFallback[0]
 	value := os.Getenv(key)
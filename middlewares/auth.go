@@ -2,12 +2,11 @@ package middlewares
 
 import (
 	"encoding/base64"
-	"errors"
 	"fmt"
 	"github.com/alin-io/pkgstore/config"
-	"github.com/carlmjohnson/requests"
 	"github.com/gin-gonic/gin"
-	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/google/uuid"
+	"os"
 	"strings"
 	"time"
 )
@@ -20,16 +19,39 @@ type AuthResult struct {
 	AuthId       string `json:"auth_id"`
 	Namespace    string `json:"namespace"`
 	Error        string `json:"error"`
+
+	// ApiTokenId is the local API token of the request, the tokens issued in exchange of it are revoked with it
+	ApiTokenId uuid.UUID `json:"-"`
+	// PackageName limits the access to a single package of the namespace, for the grants and the package rules
+	PackageName string `json:"-"`
+}
+
+// Clone copies the result, the cached results are shared between requests and mustn't be changed by one of them
+func (r *AuthResult) Clone() *AuthResult {
+	if r == nil {
+		return nil
+	}
+	result := *r
+	return &result
 }
 
-const AuthIdPublic = "public"
+// AllowsPackage reports whether the access covers the package, the access limited to a package doesn't extend to the rest of the namespace
+func (r *AuthResult) AllowsPackage(pkgName string) bool {
+	return len(r.PackageName) == 0 || len(pkgName) == 0 || r.PackageName == pkgName
+}
 
-var (
-	// make cache with 10s TTL and 1000 max keys
-	authCache = expirable.NewLRU[string, *AuthResult](1000, nil, time.Second*10)
+const (
+	AuthIdPublic = "public"
+	// AuthIdAnonymous is the caller without credentials when the registry requires auth
+	AuthIdAnonymous = "anonymous"
 )
 
-func extractTokenHeader(c *gin.Context) (string, error) {
+// AuthProvider resolves the caller identity and permissions for a package action
+type AuthProvider interface {
+	Authenticate(c *gin.Context, pkgName, token, pkgService, action string) (*AuthResult, error)
+}
+
+func ExtractTokenHeader(c *gin.Context) (string, error) {
 	tokenHeader := c.GetHeader("Authorization")
 
 	if len(tokenHeader) == 0 {
@@ -54,34 +76,81 @@ func extractTokenHeader(c *gin.Context) (string, error) {
 	return tokenString, nil
 }
 
-func getRemoteAuthContext(c *gin.Context, pkgName, token, pkgService, action string) (authResult *AuthResult, err error) {
-	cacheKey := fmt.Sprintf("%s-%s-%s-%s", token, pkgName, pkgService, action)
-	authResult = &AuthResult{}
+// CredentialSecret drops the username of basic auth credentials (twine, docker login),
+// which arrive decoded as "username:token"
+func CredentialSecret(token string) string {
+	if _, password, found := strings.Cut(token, ":"); found {
+		return password
+	}
+	return token
+}
 
-	if r, ok := authCache.Get(cacheKey); ok {
-		authResult = r
-	} else {
-		err := requests.URL(config.Get().AuthEndpoint).
-			Header("Authorization", token).
-			Header("X-Package-Service", pkgService).
-			Header("X-Package-Name", pkgName).
-			Header("X-Package-Action", action).
-			ToJSON(authResult).
-			ErrorJSON(&authResult).
-			Fetch(c)
-
-		if len(authResult.Error) > 0 {
-			return authResult, errors.New(authResult.Error)
+// parsePermissionList reads the permissions of a "read,push,delete" list,
+// where "pull" and "write" are accepted as aliases
+func parsePermissionList(list string) (read, write, canDelete bool) {
+	for _, permission := range strings.Split(list, ",") {
+		switch strings.TrimSpace(permission) {
+		case "read", PkgActionPull:
+			read = true
+		case "write", PkgActionPush:
+			write = true
+		case PkgActionDelete:
+			canDelete = true
 		}
+	}
+	return
+}
 
-		if err != nil {
-			return nil, err
-		}
+// cutLast is strings.Cut around the last separator, for the mappings with values that can contain it
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
 
-		authCache.Add(cacheKey, authResult)
+// watchedFile identifies the version of a config file, so that it's reloaded once it changes on disk
+type watchedFile struct {
+	Path    string
+	ModTime time.Time
+	Size    int64
+}
+
+func statWatchedFile(path string) (watchedFile, error) {
+	if len(path) == 0 {
+		return watchedFile{}, nil
 	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return watchedFile{}, err
+	}
+	return watchedFile{Path: path, ModTime: info.ModTime(), Size: info.Size()}, nil
+}
 
-	return authResult, nil
+// AnonymousAuthResult is the identity of the requests without credentials, which can only pull the public packages
+func AnonymousAuthResult() *AuthResult {
+	return &AuthResult{AuthId: AuthIdAnonymous}
+}
+
+// ActiveAuthProvider returns the configured AuthProvider or nil when the registry is public
+func ActiveAuthProvider() AuthProvider {
+	switch config.Get().Auth.Provider {
+	case "":
+		return nil
+	case config.AuthProviderEndpoint:
+		return endpointAuthProvider{}
+	case config.AuthProviderLocal:
+		return localAuthProvider{}
+	case config.AuthProviderJwt:
+		return jwtAuthProvider{}
+	case config.AuthProviderHtpasswd:
+		return htpasswdAuthProvider{}
+	case config.AuthProviderLdap:
+		return ldapAuthProvider{}
+	case config.AuthProviderMtls:
+		return mtlsAuthProvider{}
+	}
+	panic("Unknown auth provider - " + config.Get().Auth.Provider)
 }
 
 func GetAuthCtx(c *gin.Context) *AuthResult {
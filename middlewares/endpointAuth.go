@@ -0,0 +1,147 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/carlmjohnson/requests"
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"golang.org/x/sync/singleflight"
+	"sync"
+	"time"
+)
+
+var (
+	endpointCache     *expirable.LRU[string, *endpointCacheEntry]
+	endpointCacheOnce sync.Once
+	// endpointRequests shares a single auth call between the concurrent requests with the same token and package
+	endpointRequests singleflight.Group
+
+	// endpointMetrics are published on /debug/vars of METRICS_ADDRESS
+	endpointMetrics        = expvar.NewMap("auth_endpoint")
+	endpointLatencyBuckets = []time.Duration{10 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 500 * time.Millisecond, time.Second, 5 * time.Second}
+)
+
+// endpointAuthProvider asks AUTH_ENDPOINT for the permissions of the token
+type endpointAuthProvider struct{}
+
+func (endpointAuthProvider) Authenticate(c *gin.Context, pkgName, token, pkgService, action string) (*AuthResult, error) {
+	return getRemoteAuthContext(c, pkgName, token, pkgService, action)
+}
+
+type endpointCacheEntry struct {
+	result     *AuthResult
+	err        error
+	freshUntil time.Time
+}
+
+// authDenial is an answer of the endpoint rejecting the token, unlike the errors of an unavailable endpoint
+type authDenial struct {
+	message string
+}
+
+func (e *authDenial) Error() string {
+	return e.message
+}
+
+func getEndpointCache() *expirable.LRU[string, *endpointCacheEntry] {
+	endpointCacheOnce.Do(func() {
+		endpointConfig := config.Get().Auth.Endpoint
+		// Entries are kept for the stale grace period after they stop being fresh
+		ttl := max(endpointConfig.CacheTTL, endpointConfig.NegativeCacheTTL) + endpointConfig.StaleIfError
+		endpointCache = expirable.NewLRU[string, *endpointCacheEntry](endpointConfig.CacheSize, nil, ttl)
+	})
+	return endpointCache
+}
+
+func getRemoteAuthContext(c *gin.Context, pkgName, token, pkgService, action string) (*AuthResult, error) {
+	endpointConfig := config.Get().Auth.Endpoint
+	cacheKey := fmt.Sprintf("%s-%s-%s-%s", token, pkgName, pkgService, action)
+
+	cached, isCached := getEndpointCache().Get(cacheKey)
+	if isCached && time.Now().Before(cached.freshUntil) {
+		endpointMetrics.Add("cache_hits", 1)
+		return cached.result.Clone(), cached.err
+	}
+
+	result, err, shared := endpointRequests.Do(cacheKey, func() (interface{}, error) {
+		return fetchRemoteAuthContext(c, cacheKey, pkgName, token, pkgService, action)
+	})
+	if shared {
+		endpointMetrics.Add("shared_requests", 1)
+	}
+	if err == nil {
+		// The result is shared with the cache and the other waiters
+		return result.(*AuthResult).Clone(), nil
+	}
+
+	denial := &authDenial{}
+	if errors.As(err, &denial) {
+		return nil, err
+	}
+
+	// The endpoint is unavailable, pulls can still use the last known answer within the grace period
+	if action == PkgActionPull && isCached && cached.result != nil &&
+		time.Now().Before(cached.freshUntil.Add(endpointConfig.StaleIfError)) {
+		endpointMetrics.Add("stale_hits", 1)
+		return cached.result.Clone(), nil
+	}
+	return nil, err
+}
+
+func fetchRemoteAuthContext(c *gin.Context, cacheKey, pkgName, token, pkgService, action string) (*AuthResult, error) {
+	endpointConfig := config.Get().Auth.Endpoint
+	// The call is shared with other requests, so it shouldn't be canceled along with the first one
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c), endpointConfig.Timeout)
+	defer cancel()
+
+	authResult := &AuthResult{}
+	start := time.Now()
+	err := requests.URL(config.Get().AuthEndpoint).
+		Header("Authorization", token).
+		Header("X-Package-Service", pkgService).
+		Header("X-Package-Name", pkgName).
+		Header("X-Package-Action", action).
+		ToJSON(authResult).
+		ErrorJSON(&authResult).
+		Fetch(ctx)
+	observeEndpointLatency(time.Since(start))
+	endpointMetrics.Add("requests", 1)
+
+	responseError := &requests.ResponseError{}
+	isClientError := errors.As(err, &responseError) && responseError.StatusCode >= 400 && responseError.StatusCode < 500
+	if len(authResult.Error) > 0 || isClientError {
+		endpointMetrics.Add("denials", 1)
+		message := authResult.Error
+		if len(message) == 0 {
+			message = err.Error()
+		}
+		denial := &authDenial{message: message}
+		if endpointConfig.NegativeCacheTTL > 0 {
+			getEndpointCache().Add(cacheKey, &endpointCacheEntry{err: denial, freshUntil: time.Now().Add(endpointConfig.NegativeCacheTTL)})
+		}
+		return nil, denial
+	}
+
+	if err != nil {
+		endpointMetrics.Add("failures", 1)
+		return nil, err
+	}
+
+	getEndpointCache().Add(cacheKey, &endpointCacheEntry{result: authResult, freshUntil: time.Now().Add(endpointConfig.CacheTTL)})
+	return authResult, nil
+}
+
+func observeEndpointLatency(latency time.Duration) {
+	endpointMetrics.Add("latency_ms_total", latency.Milliseconds())
+	for _, bucket := range endpointLatencyBuckets {
+		if latency <= bucket {
+			endpointMetrics.Add(fmt.Sprintf("latency_le_%s", bucket), 1)
+			return
+		}
+	}
+	endpointMetrics.Add("latency_le_inf", 1)
+}
@@ -0,0 +1,194 @@
+package middlewares
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+var htpasswd = &htpasswdStore{}
+
+// htpasswdAuthProvider authenticates basic auth credentials against an htpasswd file
+type htpasswdAuthProvider struct{}
+
+type htpasswdMapping struct {
+	Namespace string
+	Read      bool
+	Write     bool
+	Delete    bool
+}
+
+// htpasswdStore keeps the parsed files and reloads them once they change on disk
+type htpasswdStore struct {
+	mu          sync.RWMutex
+	file        watchedFile
+	mappingFile watchedFile
+	users       map[string]string
+	mappings    map[string]htpasswdMapping
+	// verified caches the credentials that matched, bcrypt is too slow to run on every request
+	verified map[[32]byte]bool
+}
+
+func (htpasswdAuthProvider) Authenticate(_ *gin.Context, _, token, _, _ string) (*AuthResult, error) {
+	username, password, found := strings.Cut(token, ":")
+	if !found || len(username) == 0 {
+		return nil, errors.New("basic auth credentials are required")
+	}
+
+	err := htpasswd.reloadIfChanged()
+	if err != nil {
+		log.Println("Unable to load the htpasswd files: ", err)
+		return nil, errors.New("unable to verify the credentials")
+	}
+	return htpasswd.authenticate(username, password)
+}
+
+// LoadHtpasswd reads the configured htpasswd files, so broken files are reported at startup
+func LoadHtpasswd() error {
+	return htpasswd.reloadIfChanged()
+}
+
+func (s *htpasswdStore) authenticate(username, password string) (*AuthResult, error) {
+	credentialsHash := sha256.Sum256([]byte(username + ":" + password))
+
+	s.mu.RLock()
+	hash, userExists := s.users[username]
+	verified := s.verified[credentialsHash]
+	mapping, mapped := s.mappings[username]
+	s.mu.RUnlock()
+
+	if !userExists {
+		return nil, errors.New("invalid username or password")
+	}
+	if !verified {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			return nil, errors.New("invalid username or password")
+		}
+		s.mu.Lock()
+		s.verified[credentialsHash] = true
+		s.mu.Unlock()
+	}
+
+	if !mapped {
+		// Users without a mapping publish under their own name
+		mapping = htpasswdMapping{Namespace: username, Read: true, Write: true}
+	}
+
+	return &AuthResult{
+		AuthId:    username,
+		Namespace: mapping.Namespace,
+		Read:      mapping.Read,
+		Write:     mapping.Write,
+		Delete:    mapping.Delete,
+	}, nil
+}
+
+func (s *htpasswdStore) reloadIfChanged() error {
+	htpasswdConfig := config.Get().Auth.Htpasswd
+	file, err := statWatchedFile(htpasswdConfig.File)
+	if err != nil {
+		return err
+	}
+	mappingFile, err := statWatchedFile(htpasswdConfig.MappingFile)
+	if err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	changed := s.users == nil || file != s.file || mappingFile != s.mappingFile
+	s.mu.RUnlock()
+	if !changed {
+		return nil
+	}
+
+	users, err := parseHtpasswdFile(file.Path)
+	if err != nil {
+		return err
+	}
+	mappings := make(map[string]htpasswdMapping)
+	if len(mappingFile.Path) > 0 {
+		mappings, err = parseHtpasswdMappingFile(mappingFile.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.file = file
+	s.mappingFile = mappingFile
+	s.users = users
+	s.mappings = mappings
+	s.verified = make(map[[32]byte]bool)
+	s.mu.Unlock()
+	log.Println("Loaded", len(users), "users from", file.Path)
+	return nil
+}
+
+// readHtpasswdLines returns the non-empty lines of the file without the comments
+func readHtpasswdLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) > 0 && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+func parseHtpasswdFile(path string) (map[string]string, error) {
+	lines, err := readHtpasswdLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(map[string]string)
+	for _, line := range lines {
+		username, hash, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid htpasswd line for %q", username)
+		}
+		// Only bcrypt is accepted, the MD5 and SHA1 variants are too weak to keep around
+		if !strings.HasPrefix(hash, "$2y$") && !strings.HasPrefix(hash, "$2a$") && !strings.HasPrefix(hash, "$2b$") {
+			log.Println("Skipping htpasswd user", username, "without a bcrypt password")
+			continue
+		}
+		users[username] = hash
+	}
+	return users, nil
+}
+
+func parseHtpasswdMappingFile(path string) (map[string]htpasswdMapping, error) {
+	lines, err := readHtpasswdLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mappings := make(map[string]htpasswdMapping)
+	for _, line := range lines {
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid mapping line %q, expected user:namespace:permissions", line)
+		}
+
+		mapping := htpasswdMapping{Namespace: fields[1]}
+		mapping.Read, mapping.Write, mapping.Delete = parsePermissionList(fields[2])
+		mappings[fields[0]] = mapping
+	}
+	return mappings, nil
+}
@@ -0,0 +1,265 @@
+package middlewares
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	IssuedTokenTypeAccess  = "access"
+	IssuedTokenTypeRefresh = "refresh"
+	// IssuedTokenTypeSession is the token of a CLI login, it carries the identity for a single package service
+	IssuedTokenTypeSession = "session"
+
+	// TokenAccessRepository is the Docker token auth resource type of container images
+	TokenAccessRepository = "repository"
+
+	issuedTokenIssuer = "pkgstore"
+)
+
+var (
+	issuedTokenSecret     []byte
+	issuedTokenSecretOnce sync.Once
+)
+
+type TokenAccess struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Actions []string `json:"actions"`
+}
+
+// IssuedTokenClaims are the claims of the short-lived tokens signed by pkgstore itself
+type IssuedTokenClaims struct {
+	jwt.RegisteredClaims
+
+	TokenType string        `json:"token_type"`
+	Namespace string        `json:"namespace,omitempty"`
+	Access    []TokenAccess `json:"access,omitempty"`
+
+	// Permissions of the identity, only set on refresh and session tokens
+	Read   bool `json:"read,omitempty"`
+	Write  bool `json:"write,omitempty"`
+	Delete bool `json:"delete,omitempty"`
+}
+
+func getIssuedTokenSecret() []byte {
+	issuedTokenSecretOnce.Do(func() {
+		if len(config.Get().Auth.TokenSecret) > 0 {
+			issuedTokenSecret = []byte(config.Get().Auth.TokenSecret)
+			return
+		}
+		log.Println("AUTH_TOKEN_SECRET is not set, issued tokens won't survive a restart or work across replicas")
+		issuedTokenSecret = make([]byte, 32)
+		if _, err := rand.Read(issuedTokenSecret); err != nil {
+			panic(err)
+		}
+	})
+	return issuedTokenSecret
+}
+
+// SignIssuedToken fills the registered claims of the token and signs it
+func SignIssuedToken(claims *IssuedTokenClaims, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims.Issuer = issuedTokenIssuer
+	claims.ID = uuid.NewString()
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.NotBefore = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(getIssuedTokenSecret())
+}
+
+// signRecordedToken signs a long-lived token and records it, so it can be revoked before it expires
+func signRecordedToken(claims *IssuedTokenClaims, ttl time.Duration, identity *AuthResult) (string, error) {
+	token, err := SignIssuedToken(claims, ttl)
+	if err != nil {
+		return "", err
+	}
+	record := models.IssuedToken{
+		ID:        uuid.MustParse(claims.ID),
+		TokenType: claims.TokenType,
+		AuthId:    claims.Subject,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}
+	if identity.ApiTokenId != uuid.Nil {
+		record.ApiTokenId = &identity.ApiTokenId
+	}
+	return token, record.Insert()
+}
+
+func ParseIssuedToken(token, tokenType string) (*IssuedTokenClaims, error) {
+	claims, _, err := parseIssuedToken(token, tokenType)
+	return claims, err
+}
+
+func parseIssuedToken(token, tokenType string) (*IssuedTokenClaims, *models.IssuedToken, error) {
+	claims := &IssuedTokenClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(_ *jwt.Token) (interface{}, error) {
+		return getIssuedTokenSecret(), nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithIssuer(issuedTokenIssuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, nil, err
+	}
+	if claims.TokenType != tokenType {
+		return nil, nil, fmt.Errorf("expected %s token", tokenType)
+	}
+	// The short-lived access tokens aren't recorded, only their subject is checked,
+	// while the refresh and the session tokens are checked on every use
+	if tokenType != IssuedTokenTypeRefresh && tokenType != IssuedTokenTypeSession {
+		if err = CheckIssuedTokenSubject(claims.Subject); err != nil {
+			return nil, nil, err
+		}
+		return claims, nil, nil
+	}
+	record, err := checkIssuedTokenRecord(claims)
+	if err != nil {
+		return nil, nil, err
+	}
+	return claims, record, nil
+}
+
+// checkIssuedTokenRecord rejects the revoked tokens, the tokens exchanged for an API token that was revoked since,
+// and the tokens of the local users that were disabled or deleted since
+func checkIssuedTokenRecord(claims *IssuedTokenClaims) (*models.IssuedToken, error) {
+	id, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return nil, errors.New("invalid token id")
+	}
+	record := &models.IssuedToken{}
+	if err = record.FillById(id); err != nil {
+		return nil, err
+	}
+	if !record.IsActive() {
+		return nil, errors.New("token is expired or revoked")
+	}
+
+	if record.ApiTokenId != nil {
+		apiToken := models.ApiToken{}
+		if err = apiToken.FillById(*record.ApiTokenId); err != nil {
+			return nil, err
+		}
+		if !apiToken.IsActive() {
+			return nil, errors.New("token is expired or revoked")
+		}
+	}
+
+	if err = CheckIssuedTokenSubject(record.AuthId); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// CheckIssuedTokenSubject rejects the tokens of the local users that were disabled or deleted since they were issued
+func CheckIssuedTokenSubject(authId string) error {
+	if authId == AuthIdAnonymous || authId == AuthIdPublic {
+		return nil
+	}
+	user := models.User{}
+	if err := user.FillByName(authId); err != nil {
+		return err
+	}
+	if user.Disabled || (user.ID == uuid.Nil && config.Get().Auth.Provider == config.AuthProviderLocal) {
+		return errors.New("user is disabled")
+	}
+	return nil
+}
+
+// ExchangeRefreshToken returns the identity of the refresh token and revokes it,
+// the refresh tokens are single use and replaced on every exchange
+func ExchangeRefreshToken(token string) (*AuthResult, error) {
+	claims, record, err := parseIssuedToken(token, IssuedTokenTypeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	if err = record.Revoke(); err != nil {
+		return nil, err
+	}
+	identity := claims.Identity()
+	if record.ApiTokenId != nil {
+		identity.ApiTokenId = *record.ApiTokenId
+	}
+	return identity, nil
+}
+
+// Allows checks the access list of the token for the resource action
+func (t *IssuedTokenClaims) Allows(accessType, name, action string) bool {
+	for _, access := range t.Access {
+		if access.Type == accessType && access.Name == name && slices.Contains(access.Actions, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenAccessType is the access resource type of the package service,
+// the container registry follows the Docker naming while the others use the service prefix
+func TokenAccessType(pkgService string) string {
+	if pkgService == "container" {
+		return TokenAccessRepository
+	}
+	return pkgService
+}
+
+// AuthResult converts an access token into the AuthResult for the requested package action,
+// limited to that package as the access list names the packages and not their namespace
+func (t *IssuedTokenClaims) AuthResult(pkgService, pkgName, action string) (*AuthResult, error) {
+	accessType := TokenAccessType(pkgService)
+	if !t.Allows(accessType, pkgName, action) {
+		return nil, errors.New("insufficient scope")
+	}
+
+	return &AuthResult{
+		AuthId:      t.Subject,
+		Namespace:   t.Namespace,
+		Read:        t.Allows(accessType, pkgName, PkgActionPull),
+		Write:       t.Allows(accessType, pkgName, PkgActionPush),
+		Delete:      t.Allows(accessType, pkgName, PkgActionDelete),
+		PackageName: pkgName,
+	}, nil
+}
+
+// Identity returns the AuthResult that a refresh or a session token was issued for
+func (t *IssuedTokenClaims) Identity() *AuthResult {
+	return &AuthResult{
+		AuthId:    t.Subject,
+		Namespace: t.Namespace,
+		Read:      t.Read,
+		Write:     t.Write,
+		Delete:    t.Delete,
+	}
+}
+
+// SignRefreshToken issues the refresh token of the Docker token authentication for the identity
+func SignRefreshToken(identity *AuthResult) (string, error) {
+	claims := &IssuedTokenClaims{
+		TokenType: IssuedTokenTypeRefresh,
+		Namespace: identity.Namespace,
+		Read:      identity.Read,
+		Write:     identity.Write,
+		Delete:    identity.Delete,
+	}
+	claims.Subject = identity.AuthId
+	return signRecordedToken(claims, config.Get().Auth.RefreshTokenTTL, identity)
+}
+
+// SignSessionToken issues the token of a CLI login for the identity, the token is only accepted by the package service
+func SignSessionToken(identity *AuthResult, pkgService string) (string, error) {
+	claims := &IssuedTokenClaims{
+		TokenType: IssuedTokenTypeSession,
+		Namespace: identity.Namespace,
+		Read:      identity.Read,
+		Write:     identity.Write,
+		Delete:    identity.Delete,
+	}
+	claims.Subject = identity.AuthId
+	claims.Audience = []string{pkgService}
+	return signRecordedToken(claims, config.Get().Auth.SessionTokenTTL, identity)
+}
@@ -0,0 +1,225 @@
+package middlewares
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/carlmjohnson/requests"
+	"github.com/golang-jwt/jwt/v5"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksMinRefreshInterval limits how often an unknown "kid" can trigger a refresh
+	jwksMinRefreshInterval = 10 * time.Second
+	// jwksFetchTimeout bounds the refreshes made while a request waits for the keys
+	jwksFetchTimeout = 10 * time.Second
+)
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// JwksKeySet keeps the public keys of a JWKS document loaded from a file or a URL.
+// The document is refreshed on demand, there is no timer: a token with an unknown "kid" fetches it right away,
+// and the first request after the refresh interval fetches it in the background, so the removed keys
+// are still accepted until then.
+type JwksKeySet struct {
+	File            string
+	Url             string
+	RefreshInterval time.Duration
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	lastRefresh time.Time
+	refreshing  bool
+}
+
+func NewJwksKeySet(file, url string, refreshInterval time.Duration) *JwksKeySet {
+	return &JwksKeySet{
+		File:            file,
+		Url:             url,
+		RefreshInterval: refreshInterval,
+		keys:            make(map[string]crypto.PublicKey),
+	}
+}
+
+// Keyfunc looks up the verification key of the token by its "kid" header
+func (k *JwksKeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	k.mu.RLock()
+	key, ok := k.lookup(kid)
+	stale := time.Since(k.lastRefresh) > k.RefreshInterval
+	canRefresh := time.Since(k.lastRefresh) > jwksMinRefreshInterval
+	k.mu.RUnlock()
+
+	if ok && stale {
+		// The known keys keep verifying the tokens while the expired document is fetched again
+		k.refreshInBackground()
+	} else if !ok && (stale || canRefresh) {
+		ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+		err := k.Refresh(ctx)
+		cancel()
+		if err != nil {
+			log.Println("Unable to refresh JWKS: ", err)
+		}
+		k.mu.RLock()
+		key, ok = k.lookup(kid)
+		k.mu.RUnlock()
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// refreshInBackground starts a single refresh at a time, the keys are replaced once it succeeds
+func (k *JwksKeySet) refreshInBackground() {
+	k.mu.Lock()
+	if k.refreshing {
+		k.mu.Unlock()
+		return
+	}
+	k.refreshing = true
+	k.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+		defer cancel()
+		if err := k.Refresh(ctx); err != nil {
+			// Keep verifying with the previous keys while the JWKS source is unavailable
+			log.Println("Unable to refresh JWKS: ", err)
+		}
+		k.mu.Lock()
+		k.refreshing = false
+		k.mu.Unlock()
+	}()
+}
+
+func (k *JwksKeySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if len(kid) == 0 && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+func (k *JwksKeySet) Refresh(ctx context.Context) error {
+	var err error
+	document := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+
+	k.mu.Lock()
+	k.lastRefresh = time.Now()
+	k.mu.Unlock()
+
+	if len(k.File) > 0 {
+		var data []byte
+		data, err = os.ReadFile(k.File)
+		if err == nil {
+			err = json.Unmarshal(data, &document)
+		}
+	} else if len(k.Url) > 0 {
+		err = requests.URL(k.Url).ToJSON(&document).Fetch(ctx)
+	} else {
+		err = errors.New("JWKS file or URL is not configured")
+	}
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Println("Skipping JWKS key", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+	return nil
+}
+
+func (jwk *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeJwkInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJwkInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeJwkInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJwkInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+func decodeJwkInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
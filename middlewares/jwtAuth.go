@@ -0,0 +1,127 @@
+package middlewares
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var (
+	jwtKeySet   *JwksKeySet
+	jwtKeySetMu sync.Mutex
+
+	jwtValidMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+)
+
+// jwtAuthProvider validates bearer JWTs locally against the configured JWKS
+type jwtAuthProvider struct{}
+
+func (jwtAuthProvider) Authenticate(_ *gin.Context, _, token, _, action string) (*AuthResult, error) {
+	jwtConfig := config.Get().Auth.Jwt
+
+	token = CredentialSecret(token)
+
+	parserOptions := []jwt.ParserOption{
+		jwt.WithValidMethods(jwtValidMethods),
+		jwt.WithExpirationRequired(),
+	}
+	if len(jwtConfig.Issuer) > 0 {
+		parserOptions = append(parserOptions, jwt.WithIssuer(jwtConfig.Issuer))
+	}
+	if len(jwtConfig.Audience) > 0 {
+		parserOptions = append(parserOptions, jwt.WithAudience(jwtConfig.Audience))
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, getJwtKeySet().Keyfunc, parserOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	authResult := &AuthResult{
+		AuthId:    claimString(claims, jwtConfig.Claims.AuthId),
+		Namespace: claimString(claims, jwtConfig.Claims.Namespace),
+		Read:      claimGranted(claims, jwtConfig.Claims.Read),
+		Write:     claimGranted(claims, jwtConfig.Claims.Write),
+		Delete:    claimGranted(claims, jwtConfig.Claims.Delete),
+	}
+	if len(authResult.AuthId) == 0 {
+		return nil, errors.New("token is missing the auth id claim")
+	}
+
+	return authResult, nil
+}
+
+func getJwtKeySet() *JwksKeySet {
+	jwtConfig := config.Get().Auth.Jwt
+	jwtKeySetMu.Lock()
+	defer jwtKeySetMu.Unlock()
+
+	if jwtKeySet == nil || jwtKeySet.File != jwtConfig.JwksFile || jwtKeySet.Url != jwtConfig.JwksUrl {
+		jwtKeySet = NewJwksKeySet(jwtConfig.JwksFile, jwtConfig.JwksUrl, jwtConfig.JwksRefreshInterval)
+	}
+	return jwtKeySet
+}
+
+// claimValue resolves dotted claim paths like "realm_access.roles"
+func claimValue(claims map[string]interface{}, path string) interface{} {
+	var value interface{} = claims
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+func claimString(claims map[string]interface{}, path string) string {
+	if len(path) == 0 {
+		return ""
+	}
+	switch value := claimValue(claims, path).(type) {
+	case string:
+		return value
+	case float64:
+		return fmt.Sprintf("%v", value)
+	}
+	return ""
+}
+
+// claimGranted checks a boolean claim, or with "claim=value" that the claim
+// equals or contains the value (as an array or a space separated scope string)
+func claimGranted(claims map[string]interface{}, spec string) bool {
+	if len(spec) == 0 {
+		return false
+	}
+	path, expected, hasExpected := strings.Cut(spec, "=")
+	value := claimValue(claims, path)
+
+	if !hasExpected {
+		switch v := value.(type) {
+		case bool:
+			return v
+		case string:
+			return v == "true"
+		}
+		return false
+	}
+
+	switch v := value.(type) {
+	case string:
+		return slices.Contains(strings.Fields(v), expected)
+	case []interface{}:
+		for _, item := range v {
+			if item == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,265 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/gin-gonic/gin"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	LdapRuleDn     = "dn"
+	LdapRuleFilter = "filter"
+)
+
+// ldapCache keeps the results of the successful binds, so the directory isn't queried on every request.
+// The entries expire by their own deadline, since LDAP_CACHE_TTL can be changed at runtime.
+var ldapCache = expirable.NewLRU[[32]byte, *ldapCacheEntry](1000, nil, 0)
+
+type ldapCacheEntry struct {
+	result    *AuthResult
+	expiresAt time.Time
+}
+
+// ldapAuthProvider binds to the LDAP directory with the basic auth credentials of the user
+type ldapAuthProvider struct{}
+
+// LdapGroupRule maps the users matching a group DN or an LDAP filter to a namespace
+type LdapGroupRule struct {
+	Type      string
+	Match     string
+	Namespace string
+	Read      bool
+	Write     bool
+	Delete    bool
+}
+
+// ParseLdapGroupRule parses a "dn=<group dn>:namespace:permissions" or "filter=<ldap filter>:namespace:permissions" rule.
+// It's split from the right, because the filters can contain ":".
+func ParseLdapGroupRule(rule string) (*LdapGroupRule, error) {
+	rest, permissions, found := cutLast(rule, ":")
+	if !found {
+		return nil, fmt.Errorf("invalid ldap group rule %q, expected dn=<group>:namespace:permissions", rule)
+	}
+	match, namespace, found := cutLast(rest, ":")
+	if !found || len(namespace) == 0 {
+		return nil, fmt.Errorf("invalid ldap group rule %q, expected dn=<group>:namespace:permissions", rule)
+	}
+	ruleType, match, found := strings.Cut(match, "=")
+	if !found || len(match) == 0 {
+		return nil, fmt.Errorf("invalid ldap group rule %q, expected dn=<group>:namespace:permissions", rule)
+	}
+
+	groupRule := &LdapGroupRule{Type: strings.ToLower(strings.TrimSpace(ruleType)), Match: match, Namespace: namespace}
+	switch groupRule.Type {
+	case LdapRuleDn:
+		if _, err := ldap.ParseDN(match); err != nil {
+			return nil, fmt.Errorf("invalid ldap group rule dn %q: %w", match, err)
+		}
+	case LdapRuleFilter:
+		if _, err := ldap.CompileFilter(match); err != nil {
+			return nil, fmt.Errorf("invalid ldap group rule filter %q: %w", match, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown ldap group rule type %q", ruleType)
+	}
+	groupRule.Read, groupRule.Write, groupRule.Delete = parsePermissionList(permissions)
+	return groupRule, nil
+}
+
+func (ldapAuthProvider) Authenticate(_ *gin.Context, _, token, _, _ string) (*AuthResult, error) {
+	username, password, found := strings.Cut(token, ":")
+	// An empty password would be an unauthenticated bind, which most directories accept
+	if !found || len(username) == 0 || len(password) == 0 {
+		return nil, errors.New("basic auth credentials are required")
+	}
+
+	ldapConfig := config.Get().Auth.Ldap
+	cacheKey := sha256.Sum256([]byte(ldapConfig.Url + "\n" + username + "\n" + password))
+	if ldapConfig.CacheTTL > 0 {
+		if cached, ok := ldapCache.Get(cacheKey); ok && time.Now().Before(cached.expiresAt) {
+			return cached.result.Clone(), nil
+		}
+	}
+
+	authResult, err := ldapAuthenticate(username, password)
+	if err != nil {
+		return nil, err
+	}
+	if ldapConfig.CacheTTL > 0 {
+		ldapCache.Add(cacheKey, &ldapCacheEntry{result: authResult, expiresAt: time.Now().Add(ldapConfig.CacheTTL)})
+	}
+	return authResult, nil
+}
+
+func ldapConnect() (*ldap.Conn, error) {
+	ldapConfig := config.Get().Auth.Ldap
+	tlsConfig := &tls.Config{InsecureSkipVerify: ldapConfig.TlsSkipVerify}
+	conn, err := ldap.DialURL(
+		ldapConfig.Url,
+		ldap.DialWithDialer(&net.Dialer{Timeout: ldapConfig.Timeout}),
+		ldap.DialWithTLSConfig(tlsConfig),
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(ldapConfig.Timeout)
+	if ldapConfig.StartTls {
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func ldapAuthenticate(username, password string) (*AuthResult, error) {
+	ldapConfig := config.Get().Auth.Ldap
+	conn, err := ldapConnect()
+	if err != nil {
+		log.Println("Unable to connect to the LDAP server: ", err)
+		return nil, errors.New("unable to verify the credentials")
+	}
+	defer conn.Close()
+
+	userDn, err := ldapUserDn(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.Bind(userDn, password)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, errors.New("invalid username or password")
+		}
+		log.Println("Unable to bind to the LDAP server: ", err)
+		return nil, errors.New("unable to verify the credentials")
+	}
+
+	if len(ldapConfig.GroupRules) == 0 {
+		// Without group rules every directory user publishes under its own name
+		return &AuthResult{AuthId: username, Namespace: username, Read: true, Write: true}, nil
+	}
+
+	groupDns, err := ldapUserGroups(conn, userDn)
+	if err != nil {
+		log.Println("Unable to search the LDAP groups: ", err)
+		return nil, errors.New("unable to verify the credentials")
+	}
+
+	for _, rule := range ldapConfig.GroupRules {
+		groupRule, err := ParseLdapGroupRule(rule)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		matched, err := groupRule.matches(conn, userDn, groupDns)
+		if err != nil {
+			log.Println("Unable to evaluate the LDAP group rule: ", rule, err)
+			continue
+		}
+		if matched {
+			return &AuthResult{
+				AuthId:    username,
+				Namespace: groupRule.Namespace,
+				Read:      groupRule.Read,
+				Write:     groupRule.Write,
+				Delete:    groupRule.Delete,
+			}, nil
+		}
+	}
+	return nil, errors.New("the user isn't a member of any mapped group")
+}
+
+// ldapUserDn builds the DN from LDAP_USER_DN, or searches it with the service account
+func ldapUserDn(conn *ldap.Conn, username string) (string, error) {
+	ldapConfig := config.Get().Auth.Ldap
+	if len(ldapConfig.UserDn) > 0 {
+		return fmt.Sprintf(ldapConfig.UserDn, ldap.EscapeDN(username)), nil
+	}
+
+	if len(ldapConfig.BindDn) > 0 {
+		if err := conn.Bind(ldapConfig.BindDn, ldapConfig.BindPassword); err != nil {
+			log.Println("Unable to bind with the LDAP service account: ", err)
+			return "", errors.New("unable to verify the credentials")
+		}
+	}
+
+	searchResult, err := conn.Search(ldap.NewSearchRequest(
+		ldapConfig.BaseDn, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(ldapConfig.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"dn"}, nil,
+	))
+	if err != nil {
+		log.Println("Unable to search the LDAP user: ", err)
+		return "", errors.New("unable to verify the credentials")
+	}
+	if len(searchResult.Entries) != 1 {
+		return "", errors.New("invalid username or password")
+	}
+	return searchResult.Entries[0].DN, nil
+}
+
+// ldapUserGroups returns the memberOf groups of the user with the groups found by LDAP_GROUP_FILTER
+func ldapUserGroups(conn *ldap.Conn, userDn string) ([]string, error) {
+	ldapConfig := config.Get().Auth.Ldap
+	searchResult, err := conn.Search(ldap.NewSearchRequest(
+		userDn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+		"(objectClass=*)", []string{"memberOf"}, nil,
+	))
+	if err != nil {
+		return nil, err
+	}
+	groupDns := make([]string, 0)
+	for _, entry := range searchResult.Entries {
+		groupDns = append(groupDns, entry.GetAttributeValues("memberOf")...)
+	}
+
+	if len(ldapConfig.GroupBaseDn) > 0 {
+		searchResult, err = conn.Search(ldap.NewSearchRequest(
+			ldapConfig.GroupBaseDn, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			fmt.Sprintf(ldapConfig.GroupFilter, ldap.EscapeFilter(userDn)), []string{"dn"}, nil,
+		))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range searchResult.Entries {
+			groupDns = append(groupDns, entry.DN)
+		}
+	}
+	return groupDns, nil
+}
+
+func (r *LdapGroupRule) matches(conn *ldap.Conn, userDn string, groupDns []string) (bool, error) {
+	if r.Type == LdapRuleFilter {
+		// The filter is evaluated on the user entry with the permissions of the user
+		searchResult, err := conn.Search(ldap.NewSearchRequest(
+			userDn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+			r.Match, []string{"dn"}, nil,
+		))
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return false, nil
+		}
+		return err == nil && len(searchResult.Entries) > 0, err
+	}
+
+	ruleDn, err := ldap.ParseDN(r.Match)
+	if err != nil {
+		return false, err
+	}
+	for _, groupDn := range groupDns {
+		parsedDn, err := ldap.ParseDN(groupDn)
+		if err == nil && parsedDn.EqualFold(ruleDn) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,60 @@
+package middlewares
+
+import (
+	"errors"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log"
+	"strings"
+	"time"
+)
+
+// lastUsedResolution avoids writing to the DB on every single authenticated request
+const lastUsedResolution = time.Minute
+
+// localAuthProvider authenticates API tokens issued by the built-in user store
+type localAuthProvider struct{}
+
+func (localAuthProvider) Authenticate(_ *gin.Context, _, token, _, action string) (*AuthResult, error) {
+	token = CredentialSecret(token)
+	if !strings.HasPrefix(token, models.ApiTokenPrefix) {
+		return nil, errors.New("invalid token")
+	}
+
+	apiToken := models.ApiToken{}
+	err := apiToken.FillByPlainToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if !apiToken.IsActive() {
+		return nil, errors.New("token is expired or revoked")
+	}
+
+	user := models.User{}
+	err = user.FillById(apiToken.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == uuid.Nil || user.Disabled {
+		return nil, errors.New("user is disabled")
+	}
+
+	authResult := &AuthResult{
+		Read:      apiToken.HasScope(models.TokenScopeRead) && user.AllowsScope(models.TokenScopeRead),
+		Write:     apiToken.HasScope(models.TokenScopePush) && user.AllowsScope(models.TokenScopePush),
+		Delete:    apiToken.HasScope(models.TokenScopeDelete) && user.AllowsScope(models.TokenScopeDelete),
+		AuthId:    user.Name,
+		Namespace: user.Namespace,
+
+		ApiTokenId: apiToken.ID,
+	}
+
+	if apiToken.LastUsedAt == nil || time.Since(*apiToken.LastUsedAt) > lastUsedResolution {
+		if err = apiToken.TouchLastUsed(); err != nil {
+			log.Println("Unable to update token usage: ", err)
+		}
+	}
+
+	return authResult, nil
+}
@@ -0,0 +1,146 @@
+package middlewares
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/gin-gonic/gin"
+	"path"
+	"strings"
+	"sync"
+)
+
+var (
+	mtlsRules   []*MtlsRule
+	mtlsRulesMu sync.RWMutex
+)
+
+// mtlsAuthProvider rejects every token, the requests are authorized by their client certificate only
+type mtlsAuthProvider struct{}
+
+func (mtlsAuthProvider) Authenticate(_ *gin.Context, _, _, _, _ string) (*AuthResult, error) {
+	return nil, errors.New("a client certificate is required")
+}
+
+// MtlsRule maps the certificates with a subject or SAN field matching the pattern
+type MtlsRule struct {
+	Field     string
+	Pattern   string
+	Namespace string
+	Read      bool
+	Write     bool
+	Delete    bool
+}
+
+// ParseMtlsRule parses a "field=pattern:namespace:read,push,delete" rule, where the field is
+// one of cn, o, ou, dns, email or uri and the pattern is a glob like "*.build.example.com"
+func ParseMtlsRule(rule string) (*MtlsRule, error) {
+	match, permissions, found := strings.Cut(rule, ":")
+	if !found {
+		return nil, fmt.Errorf("invalid mtls rule %q, expected field=pattern:namespace:permissions", rule)
+	}
+	namespace, permissions, found := strings.Cut(permissions, ":")
+	if !found || len(namespace) == 0 {
+		return nil, fmt.Errorf("invalid mtls rule %q, expected field=pattern:namespace:permissions", rule)
+	}
+	field, pattern, found := strings.Cut(match, "=")
+	if !found || len(pattern) == 0 {
+		return nil, fmt.Errorf("invalid mtls rule %q, expected field=pattern:namespace:permissions", rule)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid mtls rule pattern %q: %w", pattern, err)
+	}
+
+	mtlsRule := &MtlsRule{
+		Field:     strings.ToLower(strings.TrimSpace(field)),
+		Pattern:   pattern,
+		Namespace: namespace,
+	}
+	switch mtlsRule.Field {
+	case "cn", "o", "ou", "dns", "email", "uri":
+	default:
+		return nil, fmt.Errorf("unknown mtls rule field %q", field)
+	}
+	mtlsRule.Read, mtlsRule.Write, mtlsRule.Delete = parsePermissionList(permissions)
+	return mtlsRule, nil
+}
+
+// LoadMtlsRules compiles the configured rules, so an invalid rule is reported at startup instead of skipped
+func LoadMtlsRules() error {
+	rules := make([]*MtlsRule, 0, len(config.Get().Auth.Mtls.Rules))
+	for _, rule := range config.Get().Auth.Mtls.Rules {
+		mtlsRule, err := ParseMtlsRule(rule)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, mtlsRule)
+	}
+
+	mtlsRulesMu.Lock()
+	mtlsRules = rules
+	mtlsRulesMu.Unlock()
+	return nil
+}
+
+// certificateFieldValues returns the values of a subject or SAN field of the certificate
+func certificateFieldValues(cert *x509.Certificate, field string) []string {
+	switch field {
+	case "cn":
+		return []string{cert.Subject.CommonName}
+	case "o":
+		return cert.Subject.Organization
+	case "ou":
+		return cert.Subject.OrganizationalUnit
+	case "dns":
+		return cert.DNSNames
+	case "email":
+		return cert.EmailAddresses
+	case "uri":
+		values := make([]string, 0, len(cert.URIs))
+		for _, uri := range cert.URIs {
+			values = append(values, uri.String())
+		}
+		return values
+	}
+	return nil
+}
+
+// Match returns the matching field value of the certificate, which becomes the auth id
+func (r *MtlsRule) Match(cert *x509.Certificate) (string, bool) {
+	for _, value := range certificateFieldValues(cert, r.Field) {
+		if len(value) == 0 {
+			continue
+		}
+		if matched, _ := path.Match(r.Pattern, value); matched {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// ClientCertAuthResult authorizes the request by its verified client certificate with the first matching rule.
+// It returns nil when the request has no verified certificate or no rule matches it.
+func ClientCertAuthResult(c *gin.Context) *AuthResult {
+	if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 || len(c.Request.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := c.Request.TLS.VerifiedChains[0][0]
+
+	mtlsRulesMu.RLock()
+	rules := mtlsRules
+	mtlsRulesMu.RUnlock()
+
+	for _, mtlsRule := range rules {
+		if authId, ok := mtlsRule.Match(cert); ok {
+			return &AuthResult{
+				AuthId:    authId,
+				Namespace: mtlsRule.Namespace,
+				Read:      mtlsRule.Read,
+				Write:     mtlsRule.Write,
+				Delete:    mtlsRule.Delete,
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/carlmjohnson/requests"
+	"github.com/golang-jwt/jwt/v5"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	oidcKeySets   = make(map[string]*JwksKeySet)
+	oidcKeySetsMu sync.Mutex
+)
+
+// VerifyOidcToken validates a CI identity token against the JWKS of its issuer,
+// which has to be one of the configured OIDC_ISSUERS
+func VerifyOidcToken(ctx context.Context, token string) (jwt.MapClaims, error) {
+	oidcConfig := config.Get().Auth.Oidc
+
+	unverifiedClaims := jwt.MapClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(token, unverifiedClaims)
+	if err != nil {
+		return nil, err
+	}
+	issuer, _ := unverifiedClaims.GetIssuer()
+	if !slices.Contains(oidcConfig.Issuers, issuer) {
+		return nil, fmt.Errorf("issuer %q is not trusted", issuer)
+	}
+
+	keySet, err := getOidcKeySet(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(token, claims, keySet.Keyfunc,
+		jwt.WithValidMethods(jwtValidMethods),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(oidcConfig.Audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// getOidcKeySet discovers and caches the JWKS of the issuer
+func getOidcKeySet(ctx context.Context, issuer string) (*JwksKeySet, error) {
+	oidcKeySetsMu.Lock()
+	keySet, ok := oidcKeySets[issuer]
+	oidcKeySetsMu.Unlock()
+	if ok {
+		return keySet, nil
+	}
+
+	discovery := struct {
+		JwksUri string `json:"jwks_uri"`
+	}{}
+	err := requests.URL(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration").ToJSON(&discovery).Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(discovery.JwksUri) == 0 {
+		return nil, errors.New("issuer doesn't provide a jwks_uri")
+	}
+
+	keySet = NewJwksKeySet("", discovery.JwksUri, time.Hour)
+	oidcKeySetsMu.Lock()
+	oidcKeySets[issuer] = keySet
+	oidcKeySetsMu.Unlock()
+	return keySet, nil
+}
+
+// MatchTrustedPublisher checks the CI token claims against the rules of the trusted publisher.
+// Claim names of GitHub Actions and GitLab CI are both supported.
+func MatchTrustedPublisher(publisher *models.TrustedPublisher, claims jwt.MapClaims) bool {
+	repository := claimString(claims, "repository")
+	if len(repository) == 0 {
+		repository = claimString(claims, "project_path")
+	}
+	if repository != publisher.Repository {
+		return false
+	}
+
+	if len(publisher.Workflow) > 0 {
+		// "owner/repo/.github/workflows/release.yml@refs/heads/main" -> "release.yml"
+		workflowPath, _, _ := strings.Cut(claimString(claims, "workflow_ref"), "@")
+		if path.Base(workflowPath) != publisher.Workflow && claimString(claims, "workflow") != publisher.Workflow {
+			return false
+		}
+	}
+
+	if len(publisher.Branch) > 0 {
+		branch := strings.TrimPrefix(claimString(claims, "ref"), "refs/heads/")
+		if refType := claimString(claims, "ref_type"); len(refType) > 0 && refType != "branch" {
+			return false
+		}
+		if matched, _ := path.Match(publisher.Branch, branch); !matched {
+			return false
+		}
+	}
+
+	if len(publisher.Environment) > 0 && claimString(claims, "environment") != publisher.Environment {
+		return false
+	}
+
+	return true
+}
@@ -1,15 +1,28 @@
 package middlewares
 
 import (
-	"github.com/alin-io/pkgstore/config"
+	"fmt"
 	"github.com/alin-io/pkgstore/models"
 	"github.com/alin-io/pkgstore/services"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"slices"
 	"strings"
 )
 
+const (
+	PkgActionPull   = "pull"
+	PkgActionPush   = "push"
+	PkgActionDelete = "delete"
+)
+
 func PkgNameAccessHandler(service services.PackageService) gin.HandlerFunc {
+	return PkgActionAccessHandler(service, "")
+}
+
+// PkgActionAccessHandler authorizes the route as the given action instead of the one of the HTTP method,
+// for the routes where the method doesn't tell what is done to the package
+func PkgActionAccessHandler(service services.PackageService, action string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		pkgName, namespace := service.ConstructFullPkgName(c)
 		filename := c.Param("filename")
@@ -17,74 +30,260 @@ func PkgNameAccessHandler(service services.PackageService) gin.HandlerFunc {
 			pkgName, _ = service.PkgVersionFromFilename(filename)
 		}
 
-		pkgAction := "pull"
-		if c.Request.Method == "PUT" || c.Request.Method == "POST" || c.Request.Method == "PATCH" {
-			pkgAction = "push"
+		pkgAction := action
+		if len(pkgAction) == 0 {
+			pkgAction = PkgActionFromMethod(c.Request.Method)
 		}
 
 		authResult := &AuthResult{}
+		anonymous := false
 
-		if len(config.Get().AuthEndpoint) > 0 {
-			tokenString, err := extractTokenHeader(c)
-			if err != nil {
-				service.SetAuthHeaderAndAbort(c)
-				return
-			}
-
-			authResult, err = getRemoteAuthContext(c, pkgName, tokenString, service.GetPrefix(), pkgAction)
-			if err != nil {
-				service.SetAuthHeaderAndAbort(c)
-				return
-			}
-
-			if len(authResult.Namespace) == 0 {
-				service.AbortRequestWithError(c, 403, "Unable to get the namespace from the auth endpoint")
-				return
-			}
-
-			if pkgAction == "push" && authResult.Namespace != namespace {
-				service.AbortRequestWithError(c, 403, "You don't have access to this namespace")
-				return
-			}
-
-			if len(pkgName) > 0 {
-				pkg := models.Package[any]{
-					Namespace: authResult.Namespace,
-					Service:   service.GetPrefix(),
+		if authProvider := ActiveAuthProvider(); authProvider != nil {
+			if pkgAction == PkgActionPull && IsSignedUrl(c) {
+				// Signed download links replace the token, they are bound to the path and the namespace
+				signedAuthResult, err := SignedUrlAuthResult(c)
+				if err != nil {
+					service.AbortRequestWithError(c, 403, "Invalid download link: "+err.Error())
+					return
 				}
-				err := pkg.FillByName(pkgName)
+				authResult = signedAuthResult
+				models.RecordAuditLog(&models.AuditLog{
+					Event:       models.AuditEventSignedUrlDownload,
+					AuthId:      authResult.AuthId,
+					Namespace:   authResult.Namespace,
+					Service:     service.GetPrefix(),
+					PackageName: pkgName,
+					Detail:      c.Request.URL.Path,
+					RemoteAddr:  c.ClientIP(),
+				})
+			} else if certAuthResult := ClientCertAuthResult(c); certAuthResult != nil {
+				// Verified client certificates authorize the request without a token
+				authResult = certAuthResult
+			} else {
+				tokenString, err := ExtractTokenHeader(c)
 				if err != nil {
-					service.AbortRequestWithError(c, 500, "Unable to check the DB for the package")
+					service.SetAuthHeaderAndAbort(c)
 					return
 				}
 
-				if pkg.ID != uuid.Nil {
-					if pkgAction == "pull" {
-						authResult.PublicAccess = pkg.IsPublic
-					}
-
-					if pkg.Namespace != authResult.Namespace && !pkg.IsPublic {
-						service.AbortRequestWithError(c, 401, "You don't have access to this package")
+				if len(tokenString) == 0 && pkgAction == PkgActionPull && len(pkgName) > 0 {
+					// Anonymous callers can pull the public packages, anything else asks for credentials
+					authResult = AnonymousAuthResult()
+					anonymous = true
+				} else if issuedToken, parseErr := ParseIssuedToken(CredentialSecret(tokenString), IssuedTokenTypeAccess); parseErr == nil {
+					// Tokens issued by pkgstore itself carry their own access list
+					authResult, err = issuedToken.AuthResult(service.GetPrefix(), pkgName, pkgAction)
+				} else if sessionToken, parseErr := ParseIssuedToken(CredentialSecret(tokenString), IssuedTokenTypeSession); parseErr == nil {
+					// CLI login tokens carry the identity, but only for the service they were issued by
+					if !slices.Contains(sessionToken.Audience, service.GetPrefix()) {
+						service.SetAuthHeaderAndAbort(c)
 						return
 					}
+					authResult = sessionToken.Identity()
+				} else {
+					authResult, err = authProvider.Authenticate(c, pkgName, tokenString, service.GetPrefix(), pkgAction)
 				}
+				if err != nil {
+					service.SetAuthHeaderAndAbort(c)
+					return
+				}
+			}
+
+			grantedAuthResult, status, message := CheckPkgAccess(service.GetPrefix(), authResult, pkgName, namespace, pkgAction)
+			if status != 0 && anonymous {
+				service.SetAuthHeaderAndAbort(c)
+				return
 			}
+			if status != 0 {
+				service.AbortRequestWithError(c, status, message)
+				return
+			}
+			authResult = grantedAuthResult
 		} else {
 			authResult.PublicAccess = true
 			authResult.AuthId = AuthIdPublic
+			authResult.Read = true
+			authResult.Write = true
+			authResult.Delete = true
 		}
 
-		if len(authResult.AuthId) == 0 {
-			service.AbortRequestWithError(c, 401, "Unauthorized")
-			return
+		c.Set("auth", authResult)
+		c.Next()
+	}
+}
+
+func PkgActionFromMethod(method string) string {
+	switch method {
+	case "PUT", "POST", "PATCH":
+		return PkgActionPush
+	case "DELETE":
+		return PkgActionDelete
+	}
+	return PkgActionPull
+}
+
+// CheckPkgAccess verifies that the authenticated caller can run the action on the package.
+// When the access is granted, it returns a copy of the auth result scoped to the namespace of the package,
+// otherwise the HTTP status and the reason. The auth result of the caller is left untouched, the providers cache
+// them and the same one is checked for several packages.
+func CheckPkgAccess(pkgService string, authResult *AuthResult, pkgName, namespace, pkgAction string) (*AuthResult, int, string) {
+	explanation := ExplainPkgAccess(pkgService, authResult, pkgName, namespace, pkgAction)
+	if !explanation.Allowed {
+		return nil, explanation.Status, explanation.Message
+	}
+	grantedAuthResult := authResult.Clone()
+	if len(explanation.Namespace) > 0 {
+		// Shared packages live in other namespaces, while the handlers scope the packages by the auth namespace
+		grantedAuthResult.Namespace = explanation.Namespace
+	}
+	if len(explanation.PackageName) > 0 {
+		grantedAuthResult.PackageName = explanation.PackageName
+	}
+	if explanation.PublicAccess {
+		grantedAuthResult.PublicAccess = true
+	}
+	return grantedAuthResult, 0, ""
+}
+
+// ExplainPkgAccess decides the access with the first matching policy rule,
+// falling back to the permissions and the namespace of the auth provider
+func ExplainPkgAccess(pkgService string, authResult *AuthResult, pkgName, namespace, pkgAction string) *AccessExplanation {
+	if !authResult.AllowsPackage(pkgName) {
+		return &AccessExplanation{Source: "token", Status: 403, Message: "You don't have access to this package"}
+	}
+
+	if activePolicy := ActivePolicy(); activePolicy != nil && len(authResult.AuthId) > 0 {
+		rule := activePolicy.Match(authResult.AuthId, namespace, pkgName, pkgService, pkgAction)
+		if rule != nil && rule.Effect == PolicyEffectAllow && authResult.AuthId == AuthIdAnonymous && !slices.Contains(rule.Subjects, AuthIdAnonymous) {
+			// The rules matching any subject are meant for the authenticated callers, the anonymous ones have to be named
+			rule = nil
+		}
+		if rule != nil {
+			if rule.Effect == PolicyEffectDeny {
+				return newPolicyExplanation(rule, false, 403, fmt.Sprintf("Denied by the policy rule %q", rule.Name))
+			}
+			// The rules extend the namespaces of the caller, not the permissions of the token
+			if status, message := checkActionPermission(authResult, pkgAction); status != 0 {
+				return newPolicyExplanation(rule, false, status, message)
+			}
+			explanation := newPolicyExplanation(rule, true, 0, fmt.Sprintf("Allowed by the policy rule %q", rule.Name))
+			// Policy rules can grant access to other namespaces, and only to the matching packages of them
+			explanation.Namespace = namespace
+			if !rule.CoversAllPackages() {
+				explanation.PackageName = pkgName
+			}
+			return explanation
+		}
+	}
+
+	explanation := &AccessExplanation{Source: "provider"}
+	explanation.Status, explanation.Message = checkProviderPkgAccess(explanation, pkgService, authResult, pkgName, namespace, pkgAction)
+	if explanation.Status == 0 {
+		explanation.Allowed = true
+		explanation.Message = "Allowed by the permissions of the auth provider"
+	}
+	return explanation
+}
+
+// checkProviderPkgAccess records the namespace of the shared packages in the explanation, instead of the auth result
+func checkProviderPkgAccess(explanation *AccessExplanation, pkgService string, authResult *AuthResult, pkgName, namespace, pkgAction string) (status int, message string) {
+	pkg := models.Package[any]{
+		Namespace: namespace,
+		Service:   pkgService,
+	}
+	if len(pkgName) > 0 {
+		err := pkg.FillByName(pkgName)
+		if err != nil {
+			return 500, "Unable to check the DB for the package"
 		}
+	}
 
-		if !authResult.PublicAccess && !strings.HasPrefix(pkgName, authResult.Namespace) {
-			service.AbortRequestWithError(c, 401, "You don't have access to this package")
-			return
+	if pkg.ID != uuid.Nil && pkgAction == PkgActionPull && pkg.IsPublic {
+		// Public packages are readable by everyone, the handlers look them up in the auth namespace
+		explanation.PublicAccess = true
+		explanation.Namespace = pkg.Namespace
+		return 0, ""
+	}
+
+	if pkg.ID != uuid.Nil && pkg.Namespace != authResult.Namespace {
+		granted, err := checkPkgGrant(&pkg, authResult, pkgAction)
+		if err != nil {
+			return 500, "Unable to check the DB for the package grants"
+		}
+		if granted {
+			// Same as the public packages, the handlers look the shared package up in the auth namespace,
+			// but the grant doesn't extend to the other packages of that namespace
+			explanation.Namespace = pkg.Namespace
+			explanation.PackageName = pkg.Name
+			return 0, ""
 		}
+	}
 
-		c.Set("auth", authResult)
-		c.Next()
+	if pkgAction == PkgActionPush && len(pkgName) > 0 && authResult.Namespace != namespace {
+		return 403, "You don't have access to this namespace"
+	}
+
+	if pkg.ID != uuid.Nil && pkg.Namespace != authResult.Namespace {
+		return 401, "You don't have access to this package"
+	}
+
+	if len(authResult.Namespace) == 0 {
+		return 403, "Unable to get the namespace from the auth provider"
+	}
+
+	if len(authResult.AuthId) == 0 {
+		return 401, "Unauthorized"
+	}
+
+	if status, message := checkActionPermission(authResult, pkgAction); status != 0 {
+		return status, message
+	}
+
+	if len(pkgName) > 0 && !strings.HasPrefix(pkgName, authResult.Namespace) {
+		return 401, "You don't have access to this package"
+	}
+
+	return 0, ""
+}
+
+// checkActionPermission checks the permission of the caller for the action, the anonymous callers can only pull
+func checkActionPermission(authResult *AuthResult, pkgAction string) (status int, message string) {
+	if authResult.AuthId == AuthIdAnonymous {
+		if pkgAction != PkgActionPull {
+			return 401, "Unauthorized"
+		}
+		return 0, ""
+	}
+
+	switch {
+	case pkgAction == PkgActionPull && !authResult.Read:
+		return 403, "You don't have read access"
+	case pkgAction == PkgActionPush && !authResult.Write:
+		return 403, "You don't have write access"
+	case pkgAction == PkgActionDelete && !authResult.Delete:
+		return 403, "You don't have delete access"
+	}
+	return 0, ""
+}
+
+// checkPkgGrant reports whether a grant of the package allows the action to the caller.
+// The permissions of the caller still apply, so a read-only token can't publish through a grant.
+func checkPkgGrant(pkg *models.Package[any], authResult *AuthResult, pkgAction string) (bool, error) {
+	if len(authResult.AuthId) == 0 || authResult.AuthId == AuthIdAnonymous || authResult.AuthId == AuthIdPublic {
+		return false, nil
+	}
+
+	grants, err := models.FindPackageGrants(pkg.ID, authResult.AuthId, authResult.Namespace)
+	if err != nil {
+		return false, err
+	}
+	for _, grant := range grants {
+		switch {
+		case pkgAction == PkgActionPull && grant.Read && authResult.Read:
+			return true, nil
+		case pkgAction == PkgActionPush && grant.Publish && authResult.Write:
+			return true, nil
+		}
 	}
+	return false, nil
 }
@@ -0,0 +1,236 @@
+package middlewares
+
+import (
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"gopkg.in/yaml.v3"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	PolicyEffectAllow = "allow"
+	PolicyEffectDeny  = "deny"
+)
+
+var policy = &policyStore{}
+
+// PolicyRule matches requests by the glob patterns of its fields, an empty field matches everything.
+// Rules with a higher priority win, on the same priority a deny wins over an allow.
+type PolicyRule struct {
+	Name       string   `yaml:"name" json:"name"`
+	Effect     string   `yaml:"effect" json:"effect"`
+	Priority   int      `yaml:"priority" json:"priority"`
+	Subjects   []string `yaml:"subjects" json:"subjects,omitempty"`
+	Namespaces []string `yaml:"namespaces" json:"namespaces,omitempty"`
+	Packages   []string `yaml:"packages" json:"packages,omitempty"`
+	Services   []string `yaml:"services" json:"services,omitempty"`
+	Actions    []string `yaml:"actions" json:"actions,omitempty"`
+
+	subjects   []*regexp.Regexp
+	namespaces []*regexp.Regexp
+	packages   []*regexp.Regexp
+}
+
+type Policy struct {
+	Rules []PolicyRule `yaml:"rules"`
+}
+
+type policyStore struct {
+	mu     sync.RWMutex
+	file   watchedFile
+	policy *Policy
+}
+
+// AccessExplanation describes how the access to a package action was decided,
+// only the name and the effect of the matching rule are shown to the caller
+type AccessExplanation struct {
+	Allowed bool                   `json:"allowed"`
+	Status  int                    `json:"status"`
+	Message string                 `json:"message"`
+	Source  string                 `json:"source"`
+	Rule    *PolicyRule            `json:"-"`
+	Matched *AccessExplanationRule `json:"rule,omitempty"`
+
+	// Namespace and PublicAccess scope the granted access to a package of another namespace,
+	// PackageName limits it to that package
+	Namespace    string `json:"-"`
+	PackageName  string `json:"-"`
+	PublicAccess bool   `json:"-"`
+}
+
+type AccessExplanationRule struct {
+	Name   string `json:"name"`
+	Effect string `json:"effect"`
+}
+
+func newPolicyExplanation(rule *PolicyRule, allowed bool, status int, message string) *AccessExplanation {
+	return &AccessExplanation{
+		Allowed: allowed,
+		Status:  status,
+		Message: message,
+		Source:  "policy",
+		Rule:    rule,
+		Matched: &AccessExplanationRule{Name: rule.Name, Effect: rule.Effect},
+	}
+}
+
+// globPattern compiles a glob where "*" matches any characters including "/"
+func globPattern(glob string) (*regexp.Regexp, error) {
+	pattern := regexp.QuoteMeta(glob)
+	pattern = strings.ReplaceAll(pattern, `\*`, ".*")
+	pattern = strings.ReplaceAll(pattern, `\?`, ".")
+	return regexp.Compile("^" + pattern + "$")
+}
+
+func compileGlobs(globs []string) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, 0, len(globs))
+	for _, glob := range globs {
+		pattern, err := globPattern(glob)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+func matchGlobs(patterns []*regexp.Regexp, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePolicy validates the YAML policy and orders its rules by precedence
+func ParsePolicy(data []byte) (*Policy, error) {
+	parsedPolicy := &Policy{}
+	err := yaml.Unmarshal(data, parsedPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range parsedPolicy.Rules {
+		rule := &parsedPolicy.Rules[i]
+		if len(rule.Name) == 0 {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if rule.Effect != PolicyEffectAllow && rule.Effect != PolicyEffectDeny {
+			return nil, fmt.Errorf("policy rule %q: effect has to be allow or deny", rule.Name)
+		}
+		for _, service := range rule.Services {
+			if !slices.Contains([]string{"npm", "pypi", "container"}, service) {
+				return nil, fmt.Errorf("policy rule %q: unknown service %q", rule.Name, service)
+			}
+		}
+		for _, action := range rule.Actions {
+			if !slices.Contains([]string{PkgActionPull, PkgActionPush, PkgActionDelete}, action) {
+				return nil, fmt.Errorf("policy rule %q: unknown action %q", rule.Name, action)
+			}
+		}
+
+		if rule.subjects, err = compileGlobs(rule.Subjects); err != nil {
+			return nil, fmt.Errorf("policy rule %q: %w", rule.Name, err)
+		}
+		if rule.namespaces, err = compileGlobs(rule.Namespaces); err != nil {
+			return nil, fmt.Errorf("policy rule %q: %w", rule.Name, err)
+		}
+		if rule.packages, err = compileGlobs(rule.Packages); err != nil {
+			return nil, fmt.Errorf("policy rule %q: %w", rule.Name, err)
+		}
+	}
+
+	sort.SliceStable(parsedPolicy.Rules, func(i, j int) bool {
+		if parsedPolicy.Rules[i].Priority != parsedPolicy.Rules[j].Priority {
+			return parsedPolicy.Rules[i].Priority > parsedPolicy.Rules[j].Priority
+		}
+		return parsedPolicy.Rules[i].Effect == PolicyEffectDeny && parsedPolicy.Rules[j].Effect != PolicyEffectDeny
+	})
+	return parsedPolicy, nil
+}
+
+// Match returns the rule that decides the request, or nil when no rule matches it
+func (p *Policy) Match(subject, namespace, pkgName, pkgService, pkgAction string) *PolicyRule {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if len(rule.Services) > 0 && !slices.Contains(rule.Services, pkgService) {
+			continue
+		}
+		if len(rule.Actions) > 0 && !slices.Contains(rule.Actions, pkgAction) {
+			continue
+		}
+		if matchGlobs(rule.subjects, subject) && matchGlobs(rule.namespaces, namespace) && matchGlobs(rule.packages, pkgName) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// CoversAllPackages reports whether the rule matches every package of its namespaces
+func (r *PolicyRule) CoversAllPackages() bool {
+	return len(r.Packages) == 0 || slices.Contains(r.Packages, "*")
+}
+
+// LoadPolicy reads the configured policy file, so an invalid policy is reported at startup
+func LoadPolicy() error {
+	return policy.reloadIfChanged()
+}
+
+// ActivePolicy returns the current policy, reloading the file when it changed.
+// A broken file keeps the previous policy in place.
+func ActivePolicy() *Policy {
+	if len(config.Get().Auth.PolicyFile) == 0 {
+		return nil
+	}
+	err := policy.reloadIfChanged()
+	if err != nil {
+		log.Println("Unable to reload the policy file: ", err)
+	}
+
+	policy.mu.RLock()
+	defer policy.mu.RUnlock()
+	if policy.file.Path != config.Get().Auth.PolicyFile {
+		return nil
+	}
+	return policy.policy
+}
+
+func (s *policyStore) reloadIfChanged() error {
+	file, err := statWatchedFile(config.Get().Auth.PolicyFile)
+	if err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	changed := file != s.file
+	s.mu.RUnlock()
+	if !changed || len(file.Path) == 0 {
+		return nil
+	}
+
+	data, err := os.ReadFile(file.Path)
+	if err != nil {
+		return err
+	}
+	parsedPolicy, err := ParsePolicy(data)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.file = file
+	s.policy = parsedPolicy
+	s.mu.Unlock()
+	log.Println("Loaded", len(parsedPolicy.Rules), "policy rules from", file.Path)
+	return nil
+}
@@ -0,0 +1,198 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/alin-io/pkgstore/services"
+	"github.com/gin-gonic/gin"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	RateLimitMetadata = "metadata"
+	RateLimitDownload = "download"
+	RateLimitPublish  = "publish"
+	RateLimitDelete   = "delete"
+	// RateLimitLogin limits the logins exchanging a password for a token
+	RateLimitLogin = "login"
+
+	// rateLimitSweepInterval is how often the expired counters are dropped
+	rateLimitSweepInterval = time.Minute
+)
+
+// RateLimiter counts the requests of a key in fixed windows
+type RateLimiter interface {
+	// Increment counts a request and returns the number of requests in the current window
+	Increment(key string, windowStart time.Time, window time.Duration) (int, error)
+}
+
+var (
+	memoryLimiter = &memoryRateLimiter{counters: make(map[string]*memoryRateCounter)}
+	dbLimiter     = &dbRateLimiter{}
+)
+
+// RateLimitHandler limits the requests of the route class by client IP and token.
+// It runs before PkgNameAccessHandler, so the requests failing the authentication are counted too.
+func RateLimitHandler(service services.PackageService, routeClass string) gin.HandlerFunc {
+	return rateLimitHandler(service, routeClass, func(c *gin.Context) []string {
+		keys := []string{"ip:" + c.ClientIP()}
+		if token := c.GetHeader("Authorization"); len(token) > 0 {
+			tokenHash := sha256.Sum256([]byte(token))
+			keys = append(keys, "token:"+hex.EncodeToString(tokenHash[:8]))
+		}
+		return keys
+	})
+}
+
+// UserRateLimitHandler limits the requests of the route class by auth id.
+// It has to run after PkgNameAccessHandler to know the auth id of the request.
+func UserRateLimitHandler(service services.PackageService, routeClass string) gin.HandlerFunc {
+	return rateLimitHandler(service, routeClass, func(c *gin.Context) []string {
+		if authCtx, ok := c.Get("auth"); ok {
+			// The shared public and anonymous identities are limited by IP only
+			if authId := authCtx.(*AuthResult).AuthId; authId != AuthIdPublic && authId != AuthIdAnonymous {
+				return []string{"auth:" + authId}
+			}
+		}
+		return nil
+	})
+}
+
+func rateLimitHandler(service services.PackageService, routeClass string, requestKeys func(c *gin.Context) []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rule := rateLimitRule(routeClass)
+		if rule.Requests <= 0 {
+			c.Next()
+			return
+		}
+
+		limiter := RateLimiter(memoryLimiter)
+		if config.Get().RateLimit.Backend == config.RateLimitBackendDB {
+			limiter = dbLimiter
+		}
+
+		now := time.Now()
+		windowStart := now.Truncate(rule.Window)
+		for _, key := range requestKeys(c) {
+			count, err := limiter.Increment(fmt.Sprintf("%s:%s:%s", service.GetPrefix(), routeClass, key), windowStart, rule.Window)
+			if err != nil {
+				// Don't block the registry when the counters are unavailable
+				log.Println("Unable to count the request for rate limiting: ", err)
+				break
+			}
+			if count > rule.Requests {
+				retryAfter := windowStart.Add(rule.Window).Sub(now)
+				c.Header("Retry-After", fmt.Sprintf("%d", int(math.Ceil(retryAfter.Seconds()))))
+				abortTooManyRequests(c, service)
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RateLimitedAccess chains the rate limits of the route class around PkgNameAccessHandler
+func RateLimitedAccess(service services.PackageService, routeClass string) []gin.HandlerFunc {
+	return RateLimitedActionAccess(service, routeClass, "")
+}
+
+// RateLimitedActionAccess chains the rate limits of the route class around PkgActionAccessHandler
+func RateLimitedActionAccess(service services.PackageService, routeClass, action string) []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		RateLimitHandler(service, routeClass),
+		PkgActionAccessHandler(service, action),
+		UserRateLimitHandler(service, routeClass),
+	}
+}
+
+func rateLimitRule(routeClass string) config.RateLimitRule {
+	switch routeClass {
+	case RateLimitMetadata:
+		return config.Get().RateLimit.Metadata
+	case RateLimitDownload:
+		return config.Get().RateLimit.Download
+	case RateLimitPublish:
+		return config.Get().RateLimit.Publish
+	case RateLimitDelete:
+		return config.Get().RateLimit.Delete
+	case RateLimitLogin:
+		return config.Get().RateLimit.Login
+	}
+	return config.RateLimitRule{}
+}
+
+// abortTooManyRequests responds in the error format that the package manager understands
+func abortTooManyRequests(c *gin.Context, service services.PackageService) {
+	message := "Too many requests, please retry later"
+	if service.GetPrefix() == "container" {
+		service.AbortRequestWithError(c, 429, message)
+		return
+	}
+	c.AbortWithStatusJSON(429, gin.H{"error": message})
+}
+
+type memoryRateCounter struct {
+	windowStart time.Time
+	windowEnd   time.Time
+	count       int
+}
+
+type memoryRateLimiter struct {
+	mu        sync.Mutex
+	counters  map[string]*memoryRateCounter
+	lastSweep time.Time
+}
+
+func (l *memoryRateLimiter) Increment(key string, windowStart time.Time, window time.Duration) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > rateLimitSweepInterval {
+		for counterKey, counter := range l.counters {
+			if counter.windowEnd.Before(now) {
+				delete(l.counters, counterKey)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	counter, ok := l.counters[key]
+	if !ok || !counter.windowStart.Equal(windowStart) {
+		counter = &memoryRateCounter{windowStart: windowStart, windowEnd: windowStart.Add(window)}
+		l.counters[key] = counter
+	}
+	counter.count++
+	return counter.count, nil
+}
+
+type dbRateLimiter struct {
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func (l *dbRateLimiter) Increment(key string, windowStart time.Time, _ time.Duration) (int, error) {
+	l.mu.Lock()
+	sweep := time.Since(l.lastSweep) > rateLimitSweepInterval
+	if sweep {
+		l.lastSweep = time.Now()
+	}
+	l.mu.Unlock()
+
+	if sweep {
+		// Counters of a day ago are past any reasonable window
+		err := models.DeleteRateLimitCountersBefore(time.Now().Add(-24 * time.Hour))
+		if err != nil {
+			log.Println("Unable to delete expired rate limit counters: ", err)
+		}
+	}
+
+	return models.IncrementRateLimitCounter(key, windowStart)
+}
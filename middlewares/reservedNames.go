@@ -0,0 +1,69 @@
+package middlewares
+
+import (
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/alin-io/pkgstore/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"path"
+	"strings"
+)
+
+// ReservedNameRule returns the rule reserving the name, or an empty string when the name can be fetched
+// from the public registry. The owner is the namespace of the existing package, if any.
+func ReservedNameRule(service, pkgName string) (rule, owner string, err error) {
+	name := models.ReservedNameKey(service, pkgName)
+	if namespace, _, found := strings.Cut(name, "/"); found {
+		for _, reservedNamespace := range config.Get().Proxy.ReservedNamespaces {
+			if models.ReservedNameKey(service, reservedNamespace) == namespace {
+				return "reserved namespace " + reservedNamespace, "", nil
+			}
+		}
+	}
+	for _, pattern := range config.Get().Proxy.ReservedNames {
+		if matched, _ := path.Match(models.ReservedNameKey(service, pattern), name); matched {
+			return "reserved name " + pattern, "", nil
+		}
+	}
+
+	reservedName, err := models.FindReservedName(service, pkgName)
+	if err != nil || reservedName.ID == uuid.Nil {
+		return "", "", err
+	}
+	return "existing package", reservedName.Namespace, nil
+}
+
+// CheckProxyAllowed is called before a missing package is fetched from the public registry,
+// the reserved names are answered with a 404 instead, and the attempt is recorded in the audit log
+// of the caller namespace and of the namespace owning the name
+func CheckProxyAllowed(c *gin.Context, service services.PackageService, pkgName string) bool {
+	rule, owner, err := ReservedNameRule(service.GetPrefix(), pkgName)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Error while trying to get package info"})
+		return false
+	}
+	if len(rule) == 0 {
+		return true
+	}
+
+	authCtx := GetAuthCtx(c)
+	namespaces := []string{authCtx.Namespace}
+	if len(owner) > 0 && owner != authCtx.Namespace {
+		namespaces = append(namespaces, owner)
+	}
+	for _, namespace := range namespaces {
+		models.RecordAuditLog(&models.AuditLog{
+			Event:       models.AuditEventProxyBlocked,
+			AuthId:      authCtx.AuthId,
+			Namespace:   namespace,
+			Service:     service.GetPrefix(),
+			PackageName: pkgName,
+			Detail:      rule,
+			RemoteAddr:  c.ClientIP(),
+		})
+	}
+	c.JSON(404, gin.H{"error": fmt.Sprintf("Package not found, %s is reserved (%s) and isn't fetched from the public registry", pkgName, rule)})
+	return false
+}
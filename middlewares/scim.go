@@ -0,0 +1,102 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/gin-gonic/gin"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const ScimErrorSchema = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+// ScimAuthHandler accepts the identity provider requests carrying SCIM_TOKEN,
+// the SCIM endpoints don't exist when the token isn't configured
+func ScimAuthHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scimToken := config.Get().Auth.Scim.Token
+		if len(scimToken) == 0 {
+			AbortScimRequest(c, 404, "SCIM provisioning is not enabled")
+			return
+		}
+
+		token, err := ExtractTokenHeader(c)
+		if err != nil || subtle.ConstantTimeCompare([]byte(CredentialSecret(token)), []byte(scimToken)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="scim"`)
+			AbortScimRequest(c, 401, "Invalid SCIM token")
+			return
+		}
+		c.Next()
+	}
+}
+
+// AbortScimRequest responds with the SCIM error format
+func AbortScimRequest(c *gin.Context, status int, detail string) {
+	c.Header("Content-Type", "application/scim+json")
+	c.AbortWithStatusJSON(status, gin.H{
+		"schemas": []string{ScimErrorSchema},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	})
+}
+
+// ScimGroupMapping is a parsed "group:namespace:read,push,delete" entry of SCIM_GROUP_MAPPING
+type ScimGroupMapping struct {
+	Group     string
+	Namespace string
+	Scopes    []string
+}
+
+// ParseScimGroupMapping splits the mapping from the right, so group names can contain ":"
+func ParseScimGroupMapping(mapping string) (*ScimGroupMapping, error) {
+	rest, permissions, found := cutLast(mapping, ":")
+	if !found {
+		return nil, fmt.Errorf("invalid scim group mapping %q, expected group:namespace:permissions", mapping)
+	}
+	group, namespace, found := cutLast(rest, ":")
+	if !found || len(group) == 0 || len(namespace) == 0 {
+		return nil, fmt.Errorf("invalid scim group mapping %q, expected group:namespace:permissions", mapping)
+	}
+
+	groupMapping := &ScimGroupMapping{Group: group, Namespace: namespace, Scopes: make([]string, 0)}
+	read, write, canDelete := parsePermissionList(permissions)
+	if read {
+		groupMapping.Scopes = append(groupMapping.Scopes, models.TokenScopeRead)
+	}
+	if write {
+		groupMapping.Scopes = append(groupMapping.Scopes, models.TokenScopePush)
+	}
+	if canDelete {
+		groupMapping.Scopes = append(groupMapping.Scopes, models.TokenScopeDelete)
+	}
+	// Empty user scopes allow everything, so a mapping has to grant something
+	if len(groupMapping.Scopes) == 0 {
+		return nil, fmt.Errorf("scim group mapping %q has no permissions", mapping)
+	}
+	return groupMapping, nil
+}
+
+// ApplyScimGroupMapping sets the namespace and the scopes of a SCIM managed user from the first mapping
+// matching one of its groups. Users without a mapped group keep their name as namespace but get no scopes,
+// the identity provider controls the user names, so they can't be trusted to not match an existing namespace.
+func ApplyScimGroupMapping(user *models.User, groups []models.Group) {
+	for _, mapping := range config.Get().Auth.Scim.GroupMapping {
+		groupMapping, err := ParseScimGroupMapping(mapping)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for _, group := range groups {
+			if group.DisplayName == groupMapping.Group {
+				user.Namespace = groupMapping.Namespace
+				user.Scopes = strings.Join(groupMapping.Scopes, ",")
+				return
+			}
+		}
+	}
+	user.Namespace = user.Name
+	user.Scopes = models.UserScopesNone
+}
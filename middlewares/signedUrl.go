@@ -0,0 +1,83 @@
+package middlewares
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/gin-gonic/gin"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// SignedUrlAuthIdPrefix marks the downloads authorized by a signed link, followed by the key id and the link id
+	SignedUrlAuthIdPrefix = "signed-url:"
+
+	signedUrlNamespaceParam = "namespace"
+	signedUrlExpiresParam   = "expires"
+	signedUrlKeyParam       = "kid"
+	signedUrlSignatureParam = "signature"
+)
+
+var ErrSignedUrlsDisabled = errors.New("signed urls are not configured")
+
+func signedUrlSignature(secret, path, namespace, expires, keyId string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%s\n%s\n%s\n%s", path, namespace, expires, keyId)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignDownloadPath returns the query that authorizes the download of the path in the namespace until the expiry
+func SignDownloadPath(path, namespace string, expiresAt time.Time) (string, error) {
+	keys := config.Get().SignedUrls.Keys
+	if len(keys) == 0 {
+		return "", ErrSignedUrlsDisabled
+	}
+
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	query := url.Values{}
+	query.Set(signedUrlNamespaceParam, namespace)
+	query.Set(signedUrlExpiresParam, expires)
+	query.Set(signedUrlKeyParam, keys[0].Id)
+	query.Set(signedUrlSignatureParam, signedUrlSignature(keys[0].Secret, path, namespace, expires, keys[0].Id))
+	return query.Encode(), nil
+}
+
+// IsSignedUrl reports whether the request carries a download link signature
+func IsSignedUrl(c *gin.Context) bool {
+	return len(c.Query(signedUrlSignatureParam)) > 0
+}
+
+// SignedUrlAuthResult verifies the signature and the expiry of the link,
+// the result can only read the packages of the signed namespace
+func SignedUrlAuthResult(c *gin.Context) (*AuthResult, error) {
+	namespace := c.Query(signedUrlNamespaceParam)
+	expires := c.Query(signedUrlExpiresParam)
+	keyId := c.Query(signedUrlKeyParam)
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid expiry")
+	}
+	if time.Now().Unix() > expiresAt {
+		return nil, errors.New("the link has expired")
+	}
+
+	for _, key := range config.Get().SignedUrls.Keys {
+		if key.Id != keyId {
+			continue
+		}
+		expected := signedUrlSignature(key.Secret, c.Request.URL.Path, namespace, expires, keyId)
+		if !hmac.Equal([]byte(expected), []byte(c.Query(signedUrlSignatureParam))) {
+			return nil, errors.New("invalid signature")
+		}
+		// Every link gets its own auth id, so the downloads of one link don't use up the rate limit of the others
+		linkId := expected[:16]
+		return &AuthResult{AuthId: SignedUrlAuthIdPrefix + keyId + ":" + linkId, Namespace: namespace, Read: true}, nil
+	}
+	return nil, errors.New("unknown or revoked signing key")
+}
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"github.com/alin-io/pkgstore/db"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"slices"
+	"time"
+)
+
+// Advisory is a vulnerability imported from an OSV database, an OSV entry affecting several packages
+// is stored once per package, with the affected versions of that package
+type Advisory struct {
+	ID          uuid.UUID `gorm:"column:id;primaryKey;" json:"-"`
+	Service     string    `gorm:"column:service;uniqueIndex:advisory_package;not null" json:"-"`
+	PackageName string    `gorm:"column:package_name;uniqueIndex:advisory_package;index;not null" json:"-"`
+	OsvId       string    `gorm:"column:osv_id;uniqueIndex:advisory_package;not null" json:"id"`
+
+	Aliases  datatypes.JSONSlice[string] `gorm:"column:aliases" json:"aliases"`
+	Summary  string                      `gorm:"column:summary" json:"summary"`
+	Details  string                      `gorm:"column:details" json:"details"`
+	Severity string                      `gorm:"column:severity;not null" json:"severity"`
+	Url      string                      `gorm:"column:url" json:"url"`
+	Cwe      datatypes.JSONSlice[string] `gorm:"column:cwe" json:"cwe"`
+
+	// Ranges are the affected intervals in the OSV event order, Versions are the versions listed one by one,
+	// and VulnerableVersions is the same as an npm range, like "npm audit" shows it
+	Ranges             datatypes.JSONSlice[AdvisoryRange] `gorm:"column:ranges" json:"ranges"`
+	Versions           datatypes.JSONSlice[string]        `gorm:"column:versions" json:"versions,omitempty"`
+	VulnerableVersions string                             `gorm:"column:vulnerable_versions" json:"vulnerable_versions"`
+
+	PublishedAt time.Time `gorm:"column:published_at" json:"published_at"`
+	ModifiedAt  time.Time `gorm:"column:modified_at" json:"modified_at"`
+	CreatedAt   time.Time `gorm:"column:created_at" json:"-"`
+	UpdatedAt   time.Time `gorm:"column:updated_at" json:"-"`
+}
+
+// AdvisoryRange is an affected interval, the versions from Introduced up to Fixed excluded or LastAffected included
+type AdvisoryRange struct {
+	Introduced   string `json:"introduced,omitempty"`
+	Fixed        string `json:"fixed,omitempty"`
+	LastAffected string `json:"last_affected,omitempty"`
+}
+
+func (a *Advisory) BeforeCreate(_ *gorm.DB) (err error) {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return
+}
+
+func (*Advisory) TableName() string {
+	return "advisories"
+}
+
+// Affects matches the version with the listed versions and the ranges, the ranges with a bound that isn't semver are skipped
+func (a *Advisory) Affects(version string) bool {
+	if slices.Contains(a.Versions, version) {
+		return true
+	}
+	parsedVersion, ok := parseSemver(version)
+	if !ok {
+		return false
+	}
+	for _, affectedRange := range a.Ranges {
+		if affectedRange.Introduced != "" && affectedRange.Introduced != "0" {
+			if introduced, ok := parseSemver(affectedRange.Introduced); !ok || compareSemver(parsedVersion, introduced) < 0 {
+				continue
+			}
+		}
+		if affectedRange.Fixed != "" {
+			if fixed, ok := parseSemver(affectedRange.Fixed); !ok || compareSemver(parsedVersion, fixed) >= 0 {
+				continue
+			}
+		}
+		if affectedRange.LastAffected != "" {
+			if lastAffected, ok := parseSemver(affectedRange.LastAffected); !ok || compareSemver(parsedVersion, lastAffected) > 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// ReplaceAdvisories stores the packages of an OSV entry, the packages it doesn't affect anymore are removed,
+// and a withdrawn entry is removed by passing no advisories
+func ReplaceAdvisories(osvId string, advisories []Advisory) error {
+	return db.DB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&Advisory{}, "osv_id = ?", osvId).Error; err != nil {
+			return err
+		}
+		if len(advisories) == 0 {
+			return nil
+		}
+		return tx.Create(&advisories).Error
+	})
+}
+
+func ListAdvisories(service string, packageNames []string) (advisories []Advisory, err error) {
+	advisories = make([]Advisory, 0)
+	if len(packageNames) == 0 {
+		return
+	}
+	err = db.DB().Order("published_at").Find(&advisories, "service = ? AND package_name IN ?", service, packageNames).Error
+	return
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/alin-io/pkgstore/db"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	TokenScopeRead   = "read"
+	TokenScopePush   = "push"
+	TokenScopeDelete = "delete"
+
+	// ApiTokenPrefix makes pkgstore tokens recognizable in config files and secret scanners
+	ApiTokenPrefix = "pks_"
+)
+
+var AllTokenScopes = []string{TokenScopeRead, TokenScopePush, TokenScopeDelete}
+
+type ApiToken struct {
+	ID     uuid.UUID `gorm:"column:id;primaryKey;" json:"id" binding:"required"`
+	UserId uuid.UUID `gorm:"column:user_id;index;not null" json:"user_id" binding:"required"`
+	Name   string    `gorm:"column:name" json:"name"`
+
+	// TokenHash is the hex encoded sha256 of the token, the plain token is never stored
+	TokenHash string `gorm:"column:token_hash;uniqueIndex;not null" json:"-"`
+	Scopes    string `gorm:"column:scopes;not null" json:"scopes"`
+
+	ExpiresAt  *time.Time `gorm:"column:expires_at" json:"expires_at"`
+	RevokedAt  *time.Time `gorm:"column:revoked_at" json:"revoked_at"`
+	LastUsedAt *time.Time `gorm:"column:last_used_at" json:"last_used_at"`
+
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (t *ApiToken) BeforeCreate(_ *gorm.DB) (err error) {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return
+}
+
+func (*ApiToken) TableName() string {
+	return "api_tokens"
+}
+
+// NewApiToken generates a random token for the user and stores only its hash.
+// The returned plain token should be shown once and never logged.
+func NewApiToken(user *User, name string, scopes []string, ttl time.Duration) (plainToken string, token *ApiToken, err error) {
+	for _, scope := range scopes {
+		if !slices.Contains(AllTokenScopes, scope) {
+			return "", nil, fmt.Errorf("unknown token scope %q", scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return "", nil, errors.New("at least one token scope is required")
+	}
+
+	data := make([]byte, 32)
+	if _, err = rand.Read(data); err != nil {
+		return "", nil, err
+	}
+	plainToken = ApiTokenPrefix + hex.EncodeToString(data)
+
+	token = &ApiToken{
+		UserId:    user.ID,
+		Name:      name,
+		TokenHash: HashApiToken(plainToken),
+		Scopes:    strings.Join(scopes, ","),
+	}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		token.ExpiresAt = &expiresAt
+	}
+
+	err = db.DB().Create(token).Error
+	if err != nil {
+		return "", nil, err
+	}
+	return plainToken, token, nil
+}
+
+func HashApiToken(plainToken string) string {
+	hash := sha256.Sum256([]byte(plainToken))
+	return hex.EncodeToString(hash[:])
+}
+
+func (t *ApiToken) FillByPlainToken(plainToken string) error {
+	return db.DB().Find(t, "token_hash = ?", HashApiToken(plainToken)).Error
+}
+
+func (t *ApiToken) FillById(id uuid.UUID) error {
+	return db.DB().Find(t, "id = ?", id).Error
+}
+
+func (t *ApiToken) HasScope(scope string) bool {
+	return slices.Contains(strings.Split(t.Scopes, ","), scope)
+}
+
+// IsActive reports whether the token is neither revoked nor expired
+func (t *ApiToken) IsActive() bool {
+	if t.ID == uuid.Nil || t.RevokedAt != nil {
+		return false
+	}
+	return t.ExpiresAt == nil || t.ExpiresAt.After(time.Now())
+}
+
+func (t *ApiToken) Revoke() error {
+	now := time.Now()
+	t.RevokedAt = &now
+	return db.DB().Model(t).Update("revoked_at", now).Error
+}
+
+func (t *ApiToken) TouchLastUsed() error {
+	now := time.Now()
+	t.LastUsedAt = &now
+	return db.DB().Model(t).UpdateColumn("last_used_at", now).Error
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"github.com/alin-io/pkgstore/db"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+const (
+	AuditEventSignedUrlCreate   = "signed_url.create"
+	AuditEventSignedUrlDownload = "signed_url.download"
+	AuditEventProxyBlocked      = "proxy.blocked"
+)
+
+// AuditLog records the security relevant events of a namespace
+type AuditLog struct {
+	ID        uuid.UUID `gorm:"column:id;primaryKey;" json:"id"`
+	Event     string    `gorm:"column:event;index;not null" json:"event"`
+	AuthId    string    `gorm:"column:auth_id" json:"auth_id"`
+	Namespace string    `gorm:"column:namespace;index" json:"namespace"`
+
+	Service     string `gorm:"column:service" json:"service"`
+	PackageName string `gorm:"column:package_name" json:"package_name"`
+	Detail      string `gorm:"column:detail" json:"detail"`
+	RemoteAddr  string `gorm:"column:remote_addr" json:"remote_addr"`
+
+	CreatedAt time.Time `gorm:"column:created_at;index" json:"created_at"`
+}
+
+func (t *AuditLog) BeforeCreate(_ *gorm.DB) (err error) {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return
+}
+
+func (*AuditLog) TableName() string {
+	return "audit_logs"
+}
+
+func (t *AuditLog) Insert() error {
+	return db.DB().Create(t).Error
+}
+
+// RecordAuditLog stores the event without failing the request that caused it
+func RecordAuditLog(auditLog *AuditLog) {
+	err := auditLog.Insert()
+	if err != nil {
+		log.Println("Unable to record the audit log: ", auditLog.Event, err)
+	}
+}
+
+func ListAuditLogs(namespace string, limit int) (auditLogs []AuditLog, err error) {
+	auditLogs = make([]AuditLog, 0)
+	err = db.DB().Order("created_at DESC").Limit(limit).Find(&auditLogs, "namespace = ?", namespace).Error
+	return
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"github.com/alin-io/pkgstore/db"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Group is a user group provisioned with SCIM, mapped to a namespace by SCIM_GROUP_MAPPING
+type Group struct {
+	ID          uuid.UUID `gorm:"column:id;primaryKey;" json:"id"`
+	DisplayName string    `gorm:"column:display_name;uniqueIndex;not null" json:"display_name"`
+	ExternalId  string    `gorm:"column:external_id;index" json:"external_id"`
+
+	Members []User `gorm:"many2many:group_members;" json:"members"`
+
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (g *Group) BeforeCreate(_ *gorm.DB) (err error) {
+	if g.ID == uuid.Nil {
+		g.ID = uuid.New()
+	}
+	return
+}
+
+func (*Group) TableName() string {
+	return "groups"
+}
+
+func (g *Group) FillById(id uuid.UUID) error {
+	return db.DB().Preload("Members").Find(g, "id = ?", id).Error
+}
+
+func (g *Group) FillByDisplayName(displayName string) error {
+	return db.DB().Find(g, "display_name = ?", displayName).Error
+}
+
+func (g *Group) Insert() error {
+	return db.DB().Omit("Members").Create(g).Error
+}
+
+// Save updates the group fields, the members are changed with SetMembers
+func (g *Group) Save() error {
+	return db.DB().Omit("Members").Save(g).Error
+}
+
+func (g *Group) SetMembers(users []User) error {
+	g.Members = users
+	return db.DB().Model(g).Association("Members").Replace(users)
+}
+
+func (g *Group) Delete() error {
+	err := db.DB().Model(g).Association("Members").Clear()
+	if err != nil {
+		return err
+	}
+	return db.DB().Delete(&Group{}, "id = ?", g.ID).Error
+}
+
+func ListGroups() (groups []Group, err error) {
+	groups = make([]Group, 0)
+	err = db.DB().Preload("Members").Order("display_name").Find(&groups).Error
+	return
+}
+
+// UserGroups returns the groups that the user is a member of
+func UserGroups(userId uuid.UUID) (groups []Group, err error) {
+	groups = make([]Group, 0)
+	err = db.DB().Model(&User{ID: userId}).Association("Groups").Find(&groups)
+	return
+}
+
+func FindUsersByIds(ids []uuid.UUID) (users []User, err error) {
+	users = make([]User, 0)
+	if len(ids) == 0 {
+		return
+	}
+	err = db.DB().Find(&users, "id IN ?", ids).Error
+	return
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"errors"
+	"github.com/alin-io/pkgstore/db"
+	"github.com/google/uuid"
+	"time"
+)
+
+// IssuedToken records the long-lived tokens signed by pkgstore by their "jti", so they can be revoked
+// before they expire. ApiTokenId is the API token they were exchanged for, if any.
+type IssuedToken struct {
+	ID         uuid.UUID  `gorm:"column:id;primaryKey;" json:"id"`
+	TokenType  string     `gorm:"column:token_type;not null" json:"token_type"`
+	AuthId     string     `gorm:"column:auth_id;index;not null" json:"auth_id"`
+	ApiTokenId *uuid.UUID `gorm:"column:api_token_id;index" json:"api_token_id"`
+
+	ExpiresAt time.Time  `gorm:"column:expires_at;index" json:"expires_at"`
+	RevokedAt *time.Time `gorm:"column:revoked_at" json:"revoked_at"`
+
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+func (*IssuedToken) TableName() string {
+	return "issued_tokens"
+}
+
+func (t *IssuedToken) FillById(id uuid.UUID) error {
+	return db.DB().Find(t, "id = ?", id).Error
+}
+
+func (t *IssuedToken) Insert() error {
+	return db.DB().Create(t).Error
+}
+
+func (t *IssuedToken) IsActive() bool {
+	return t.ID != uuid.Nil && t.RevokedAt == nil && t.ExpiresAt.After(time.Now())
+}
+
+// Revoke only succeeds for the first caller, so a single use token can't be exchanged twice concurrently
+func (t *IssuedToken) Revoke() error {
+	now := time.Now()
+	result := db.DB().Model(&IssuedToken{}).Where("id = ? AND revoked_at IS NULL", t.ID).Update("revoked_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("token is already revoked")
+	}
+	t.RevokedAt = &now
+	return nil
+}
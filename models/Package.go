@@ -5,6 +5,7 @@ import (
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 	"log"
+	"strings"
 	"time"
 )
 
@@ -31,6 +32,12 @@ func (p *Package[MetaType]) BeforeCreate(_ *gorm.DB) (err error) {
 	return
 }
 
+// AfterCreate reserves the name, so it isn't fetched from the public registry even after the package is deleted
+func (p *Package[MetaType]) AfterCreate(tx *gorm.DB) error {
+	reservedName := ReservedName{Service: p.Service, Name: p.Name, Namespace: p.Namespace}
+	return reservedName.Insert(tx.Session(&gorm.Session{NewDB: true}))
+}
+
 func (*Package[T]) TableName() string {
 	return "packages"
 }
@@ -62,6 +69,18 @@ func (p *Package[T]) Version(name string) (PackageVersion[T], error) {
 	return version, nil
 }
 
+// HasAsset reports whether a version of the package references the asset, the blobs are shared between the packages
+func (p *Package[T]) HasAsset(asset *Asset) (bool, error) {
+	if p.ID == uuid.Nil || asset.ID == uuid.Nil {
+		return false, nil
+	}
+	var count int64
+	err := db.DB().Model(&PackageVersion[T]{}).
+		Where("package_id = ? AND service = ? AND asset_ids LIKE ?", p.ID.String(), p.Service, "%"+asset.ID.String()+"%").
+		Count(&count).Error
+	return count > 0, err
+}
+
 func (p *Package[T]) Insert() error {
 	return db.DB().Create(p).Error
 }
@@ -78,13 +97,34 @@ func (p *Package[T]) InsertVersion(version PackageVersion[T]) error {
 	_ = p.Save()
 
 	p.Versions = append(p.Versions, version)
-	return db.DB().Create(&version).Error
+	err := db.DB().Create(&version).Error
+	if err != nil {
+		return err
+	}
+	return touchPackage(p.ID)
 }
 
 func (p *Package[T]) Save() error {
 	return db.DB().Save(p).Error
 }
 
+// SetPublic changes the visibility of the package, public packages can be pulled without credentials
+func (p *Package[T]) SetPublic(isPublic bool) error {
+	p.IsPublic = isPublic
+	return db.DB().Model(&Package[T]{}).Where("id = ?", p.ID.String()).Update("is_public", isPublic).Error
+}
+
+// touchPackage moves the modification time of the package, which versions the cached metadata documents
+func touchPackage(packageId uuid.UUID) error {
+	return db.DB().Model(&Package[any]{}).Where("id = ?", packageId.String()).Update("updated_at", time.Now()).Error
+}
+
+// SetLatestVersion updates only the latest version column, without saving the loaded versions
+func (p *Package[T]) SetLatestVersion(version string) error {
+	p.LatestVersion = version
+	return db.DB().Model(&Package[T]{}).Where("id = ?", p.ID.String()).Update("latest_version", version).Error
+}
+
 func (p *Package[T]) Delete() error {
 	err := db.DB().Delete(&Package[T]{}, "id = ?", p.ID.String()).Error
 	if err != nil {
@@ -94,5 +134,40 @@ func (p *Package[T]) Delete() error {
 	if err != nil {
 		log.Println("Error deleting version -> ", err)
 	}
+	err = DeletePackageGrants(p.ID)
+	if err != nil {
+		log.Println("Error deleting grants -> ", err)
+	}
+	err = DeletePackageTags(p.ID)
+	if err != nil {
+		log.Println("Error deleting tags -> ", err)
+	}
 	return nil
 }
+
+// likeEscaper escapes the LIKE wildcards of the search terms
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// SearchVisiblePackages returns the packages of the service that the namespace owns, the public packages
+// and the packages shared with the user or the namespace through a read grant, when every term is found in the name
+// or in the metadata of the latest version. Only the latest version is loaded, the callers rank the matches.
+func SearchVisiblePackages[T any](service, namespace, authId string, terms []string) (pkgs []Package[T], err error) {
+	pkgs = make([]Package[T], 0)
+	grantedIds := db.DB().Model(&PackageGrant{}).Select("package_id").Where(
+		"read = ? AND ((grantee_type = ? AND grantee = ?) OR (grantee_type = ? AND grantee = ?))",
+		true, GranteeTypeUser, authId, GranteeTypeNamespace, namespace,
+	)
+	query := db.DB().Where("service = ?", service).
+		Where(db.DB().Where("namespace = ?", namespace).Or("is_public = ?", true).Or("id IN (?)", grantedIds))
+	for _, term := range terms {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(term)) + "%"
+		latestMetadata := db.DB().Model(&PackageVersion[T]{}).Select("1").Where(
+			"package_versions.package_id = packages.id AND package_versions.version = packages.latest_version AND LOWER(CAST(package_versions.metadata AS TEXT)) LIKE ? ESCAPE '\\'",
+			pattern,
+		)
+		query = query.Where(db.DB().Where("LOWER(packages.name) LIKE ? ESCAPE '\\'", pattern).Or("EXISTS (?)", latestMetadata))
+	}
+	err = query.Preload("Versions", "version = (SELECT latest_version FROM packages WHERE packages.id = package_versions.package_id)").
+		Find(&pkgs).Error
+	return
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"github.com/alin-io/pkgstore/db"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	GranteeTypeNamespace = "namespace"
+	GranteeTypeUser      = "user"
+)
+
+// PackageGrant shares a package with a namespace or a user outside of the package namespace
+type PackageGrant struct {
+	ID        uuid.UUID `gorm:"column:id;primaryKey;" json:"id"`
+	PackageId uuid.UUID `gorm:"column:package_id;uniqueIndex:package_grantee;not null" json:"package_id"`
+
+	// GranteeType is either namespace or user, the grantee is the namespace name or the auth id of the user
+	GranteeType string `gorm:"column:grantee_type;uniqueIndex:package_grantee;not null" json:"grantee_type"`
+	Grantee     string `gorm:"column:grantee;uniqueIndex:package_grantee;not null" json:"grantee"`
+
+	Read    bool `gorm:"column:read;not null;default:false" json:"read"`
+	Publish bool `gorm:"column:publish;not null;default:false" json:"publish"`
+
+	// AuthId is the owner that created the grant
+	AuthId    string    `gorm:"column:auth_id;not null" json:"auth_id"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (t *PackageGrant) BeforeCreate(_ *gorm.DB) (err error) {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return
+}
+
+func (*PackageGrant) TableName() string {
+	return "package_grants"
+}
+
+func (t *PackageGrant) Insert() error {
+	return db.DB().Create(t).Error
+}
+
+func (t *PackageGrant) Delete() error {
+	return db.DB().Delete(&PackageGrant{}, "id = ?", t.ID).Error
+}
+
+func (t *PackageGrant) FillById(id, packageId uuid.UUID) error {
+	return db.DB().Find(t, "id = ? AND package_id = ?", id, packageId).Error
+}
+
+func (t *PackageGrant) FillByGrantee(packageId uuid.UUID, granteeType, grantee string) error {
+	return db.DB().Find(t, "package_id = ? AND grantee_type = ? AND grantee = ?", packageId, granteeType, grantee).Error
+}
+
+func ListPackageGrants(packageId uuid.UUID) (grants []PackageGrant, err error) {
+	grants = make([]PackageGrant, 0)
+	err = db.DB().Order("created_at").Find(&grants, "package_id = ?", packageId).Error
+	return
+}
+
+// FindPackageGrants returns the grants of the package matching the user or its namespace
+func FindPackageGrants(packageId uuid.UUID, authId, namespace string) (grants []PackageGrant, err error) {
+	grants = make([]PackageGrant, 0)
+	err = db.DB().Find(
+		&grants,
+		"package_id = ? AND ((grantee_type = ? AND grantee = ?) OR (grantee_type = ? AND grantee = ?))",
+		packageId, GranteeTypeUser, authId, GranteeTypeNamespace, namespace,
+	).Error
+	return
+}
+
+func DeletePackageGrants(packageId uuid.UUID) error {
+	return db.DB().Delete(&PackageGrant{}, "package_id = ?", packageId).Error
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"github.com/alin-io/pkgstore/db"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"time"
+)
+
+// PackageTag points a distribution tag (npm dist-tags) to a version of the package,
+// a version can have any number of tags and a tag is moved by pointing it to another version
+type PackageTag struct {
+	ID        uuid.UUID `gorm:"column:id;primaryKey;" json:"id"`
+	PackageId uuid.UUID `gorm:"column:package_id;uniqueIndex:package_tag;not null" json:"package_id"`
+	Tag       string    `gorm:"column:tag;uniqueIndex:package_tag;not null" json:"tag"`
+	Version   string    `gorm:"column:version;not null" json:"version"`
+
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (t *PackageTag) BeforeCreate(_ *gorm.DB) (err error) {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return
+}
+
+func (*PackageTag) TableName() string {
+	return "package_tags"
+}
+
+// DistTags returns the tags of the package mapped to their versions
+func (p *Package[T]) DistTags() (map[string]string, error) {
+	tags := make([]PackageTag, 0)
+	err := db.DB().Find(&tags, "package_id = ?", p.ID).Error
+	if err != nil {
+		return nil, err
+	}
+	distTags := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		distTags[tag.Tag] = tag.Version
+	}
+	return distTags, nil
+}
+
+// SetDistTag creates the tag or moves it to the version
+func (p *Package[T]) SetDistTag(tag, version string) error {
+	err := db.DB().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "package_id"}, {Name: "tag"}},
+		DoUpdates: clause.AssignmentColumns([]string{"version", "updated_at"}),
+	}).Create(&PackageTag{PackageId: p.ID, Tag: tag, Version: version}).Error
+	if err != nil {
+		return err
+	}
+	return touchPackage(p.ID)
+}
+
+func (p *Package[T]) DeleteDistTag(tag string) error {
+	err := db.DB().Delete(&PackageTag{}, "package_id = ? AND tag = ?", p.ID, tag).Error
+	if err != nil {
+		return err
+	}
+	return touchPackage(p.ID)
+}
+
+func DeletePackageTags(packageId uuid.UUID) error {
+	return db.DB().Delete(&PackageTag{}, "package_id = ?", packageId).Error
+}
+
+// migrateNpmVersionTags moves the dist-tags that npm versions used to keep in their tag column
+// to the tags table, the version tag becomes the version itself like the pypi versions
+func migrateNpmVersionTags() {
+	versions := make([]PackageVersion[any], 0)
+	err := db.DB().Find(&versions, "service = ? AND tag <> '' AND tag <> version", "npm").Error
+	if err != nil {
+		log.Println("Unable to migrate the npm version tags: ", err)
+		return
+	}
+	for _, version := range versions {
+		pkg := Package[any]{ID: version.PackageId}
+		if err = pkg.SetDistTag(version.Tag, version.Version); err != nil {
+			log.Println("Unable to migrate the npm version tag: ", version.ID, err)
+			continue
+		}
+		err = db.DB().Model(&PackageVersion[any]{}).Where("id = ?", version.ID).Update("tag", version.Version).Error
+		if err != nil {
+			log.Println("Unable to migrate the npm version tag: ", version.ID, err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"github.com/alin-io/pkgstore/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// RateLimitCounter is a fixed window request counter shared between the replicas
+type RateLimitCounter struct {
+	Key         string    `gorm:"column:counter_key;primaryKey" json:"key"`
+	WindowStart time.Time `gorm:"column:window_start;primaryKey;index" json:"window_start"`
+	Count       int       `gorm:"column:count;not null" json:"count"`
+}
+
+func (*RateLimitCounter) TableName() string {
+	return "rate_limit_counters"
+}
+
+// IncrementRateLimitCounter atomically counts a request in the window and returns the new count
+func IncrementRateLimitCounter(key string, windowStart time.Time) (count int, err error) {
+	err = db.DB().Transaction(func(tx *gorm.DB) error {
+		counter := RateLimitCounter{Key: key, WindowStart: windowStart, Count: 1}
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "counter_key"}, {Name: "window_start"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"count": gorm.Expr("rate_limit_counters.count + 1")}),
+		}).Create(&counter).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&RateLimitCounter{}).Select("count").
+			Where("counter_key = ? AND window_start = ?", key, windowStart).Scan(&count).Error
+	})
+	return
+}
+
+func DeleteRateLimitCountersBefore(before time.Time) error {
+	return db.DB().Delete(&RateLimitCounter{}, "window_start < ?", before).Error
+}
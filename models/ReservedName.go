@@ -0,0 +1,89 @@
+package models
+
+import (
+	"github.com/alin-io/pkgstore/db"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// pypiNameSeparators are the characters pip treats as the same (PEP 503)
+var pypiNameSeparators = regexp.MustCompile(`[-_.]+`)
+
+// ReservedName is a package name that existed in pkgstore, it is kept after the package is deleted,
+// so the name is never fetched from the public registry and can't be taken over there
+type ReservedName struct {
+	ID        uuid.UUID `gorm:"column:id;primaryKey;" json:"id"`
+	Service   string    `gorm:"column:service;uniqueIndex:reserved_name;not null" json:"service"`
+	Name      string    `gorm:"column:name;uniqueIndex:reserved_name;not null" json:"name"`
+	Namespace string    `gorm:"column:namespace" json:"namespace"`
+
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+func (r *ReservedName) BeforeCreate(_ *gorm.DB) (err error) {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return
+}
+
+func (*ReservedName) TableName() string {
+	return "reserved_names"
+}
+
+// Insert keeps the first namespace that used the name
+func (r *ReservedName) Insert(tx *gorm.DB) error {
+	r.Name = ReservedNameKey(r.Service, r.Name)
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(r).Error
+}
+
+// ReservedNameKey is the name the way the package clients compare them, without the "@" of the npm scopes,
+// case-insensitive, and with the pypi separators normalized
+func ReservedNameKey(service, name string) string {
+	name = strings.ToLower(strings.TrimPrefix(name, "@"))
+	if service == "pypi" {
+		name = pypiNameSeparators.ReplaceAllString(name, "-")
+	}
+	return name
+}
+
+func FindReservedName(service, name string) (reservedName ReservedName, err error) {
+	err = db.DB().Find(&reservedName, "service = ? AND name = ?", service, ReservedNameKey(service, name)).Error
+	return
+}
+
+// migrateReservedNames reserves the names of the packages that don't have a reserved name yet,
+// created before the names were recorded or while the recording failed
+func migrateReservedNames() {
+	reservedNames := make([]ReservedName, 0)
+	err := db.DB().Select("service", "name").Find(&reservedNames).Error
+	if err != nil {
+		log.Println("Unable to migrate the reserved package names: ", err)
+		return
+	}
+	reserved := make(map[string]bool, len(reservedNames))
+	for _, reservedName := range reservedNames {
+		reserved[reservedName.Service+"\n"+reservedName.Name] = true
+	}
+
+	packages := make([]Package[any], 0)
+	err = db.DB().Select("service", "name", "namespace").Find(&packages).Error
+	if err != nil {
+		log.Println("Unable to migrate the reserved package names: ", err)
+		return
+	}
+	for _, pkg := range packages {
+		if reserved[pkg.Service+"\n"+ReservedNameKey(pkg.Service, pkg.Name)] {
+			continue
+		}
+		reservedName := ReservedName{Service: pkg.Service, Name: pkg.Name, Namespace: pkg.Namespace}
+		if err = reservedName.Insert(db.DB()); err != nil {
+			log.Println("Unable to migrate the reserved package name: ", pkg.Service, pkg.Name, err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"github.com/alin-io/pkgstore/db"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// TrustedPublisher allows a CI workflow to publish a package with its OIDC identity token
+type TrustedPublisher struct {
+	ID          uuid.UUID `gorm:"column:id;primaryKey;" json:"id" binding:"required"`
+	Service     string    `gorm:"column:service;index:trusted_publisher_pkg;not null" json:"service" binding:"required"`
+	PackageName string    `gorm:"column:package_name;index:trusted_publisher_pkg;not null" json:"package_name" binding:"required"`
+
+	// AuthId and Namespace are used for the packages published by the CI workflow
+	AuthId    string `gorm:"column:auth_id;not null" json:"auth_id"`
+	Namespace string `gorm:"column:namespace;not null" json:"namespace"`
+
+	// Claim rules, empty values match anything
+	Issuer      string `gorm:"column:issuer;not null" json:"issuer" binding:"required"`
+	Repository  string `gorm:"column:repository;not null" json:"repository" binding:"required"`
+	Workflow    string `gorm:"column:workflow" json:"workflow"`
+	Branch      string `gorm:"column:branch" json:"branch"`
+	Environment string `gorm:"column:environment" json:"environment"`
+
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (t *TrustedPublisher) BeforeCreate(_ *gorm.DB) (err error) {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return
+}
+
+func (*TrustedPublisher) TableName() string {
+	return "trusted_publishers"
+}
+
+func (t *TrustedPublisher) Insert() error {
+	return db.DB().Create(t).Error
+}
+
+func (t *TrustedPublisher) Delete() error {
+	return db.DB().Delete(&TrustedPublisher{}, "id = ?", t.ID).Error
+}
+
+func (t *TrustedPublisher) FillById(id uuid.UUID, namespace string) error {
+	return db.DB().Find(t, "id = ? AND namespace = ?", id, namespace).Error
+}
+
+func ListTrustedPublishers(namespace string) (publishers []TrustedPublisher, err error) {
+	publishers = make([]TrustedPublisher, 0)
+	err = db.DB().Order("created_at").Find(&publishers, "namespace = ?", namespace).Error
+	return
+}
+
+func FindTrustedPublishers(service, pkgName, issuer string) (publishers []TrustedPublisher, err error) {
+	publishers = make([]TrustedPublisher, 0)
+	err = db.DB().Find(&publishers, "service = ? AND package_name = ? AND issuer = ?", service, pkgName, issuer).Error
+	return
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"github.com/alin-io/pkgstore/db"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// UpstreamDocument is a metadata document fetched from the public registry by the caching proxy,
+// it is kept after it expires, so it can be served while the public registry is down
+type UpstreamDocument struct {
+	ID          uuid.UUID `gorm:"column:id;primaryKey;" json:"id"`
+	Service     string    `gorm:"column:service;uniqueIndex:upstream_document;not null" json:"service"`
+	Name        string    `gorm:"column:name;uniqueIndex:upstream_document;not null" json:"name"`
+	Abbreviated bool      `gorm:"column:abbreviated;uniqueIndex:upstream_document;not null" json:"abbreviated"`
+	Body        []byte    `gorm:"column:body;not null" json:"-"`
+	ETag        string    `gorm:"column:etag" json:"etag"`
+	FetchedAt   time.Time `gorm:"column:fetched_at" json:"fetched_at"`
+
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (d *UpstreamDocument) BeforeCreate(_ *gorm.DB) (err error) {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return
+}
+
+func (*UpstreamDocument) TableName() string {
+	return "upstream_documents"
+}
+
+func (d *UpstreamDocument) FillByName(name string, abbreviated bool) error {
+	return db.DB().Find(d, "service = ? AND name = ? AND abbreviated = ?", d.Service, name, abbreviated).Error
+}
+
+// Save stores the document, replacing the one fetched by another replica in the meantime
+func (d *UpstreamDocument) Save() error {
+	return db.DB().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "service"}, {Name: "name"}, {Name: "abbreviated"}},
+		DoUpdates: clause.AssignmentColumns([]string{"body", "etag", "fetched_at", "updated_at"}),
+	}).Create(d).Error
+}
+
+// Refresh marks the document as fetched again, when the public registry reports it as not modified
+func (d *UpstreamDocument) Refresh() error {
+	d.FetchedAt = time.Now()
+	return db.DB().Model(d).Update("fetched_at", d.FetchedAt).Error
+}
+
+// UpstreamTarball points a version of a public package to the asset it was stored as on the first download
+type UpstreamTarball struct {
+	ID      uuid.UUID `gorm:"column:id;primaryKey;" json:"id"`
+	Service string    `gorm:"column:service;uniqueIndex:upstream_tarball;not null" json:"service"`
+	Name    string    `gorm:"column:name;uniqueIndex:upstream_tarball;not null" json:"name"`
+	Version string    `gorm:"column:version;uniqueIndex:upstream_tarball;not null" json:"version"`
+	AssetId uuid.UUID `gorm:"column:asset_id;index;not null" json:"asset_id"`
+
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+func (t *UpstreamTarball) BeforeCreate(_ *gorm.DB) (err error) {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return
+}
+
+func (*UpstreamTarball) TableName() string {
+	return "upstream_tarballs"
+}
+
+func (t *UpstreamTarball) FillByVersion(name, version string) error {
+	return db.DB().Find(t, "service = ? AND name = ? AND version = ?", t.Service, name, version).Error
+}
+
+// Insert keeps the first stored tarball when concurrent downloads race on the same version
+func (t *UpstreamTarball) Insert() error {
+	return db.DB().Clauses(clause.OnConflict{DoNothing: true}).Create(t).Error
+}
+
+// IsUpstreamAsset reports the assets of the cached tarballs, which no package version refers to
+func IsUpstreamAsset(assetId uuid.UUID) (bool, error) {
+	var count int64
+	err := db.DB().Model(&UpstreamTarball{}).Where("asset_id = ?", assetId).Count(&count).Error
+	return count > 0, err
+}
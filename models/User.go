@@ -0,0 +1,102 @@
+package models
+
+import (
+	"github.com/alin-io/pkgstore/db"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"slices"
+	"strings"
+	"time"
+)
+
+// UserScopesNone doesn't match any token scope, the tokens of the user can't do anything
+const UserScopesNone = "none"
+
+type User struct {
+	ID   uuid.UUID `gorm:"column:id;primaryKey;" json:"id" binding:"required"`
+	Name string    `gorm:"column:name;uniqueIndex;not null" json:"name" binding:"required"`
+
+	// Namespace is the package namespace that user is allowed to publish into
+	Namespace string `gorm:"column:namespace;index;not null" json:"namespace" binding:"required"`
+	Disabled  bool   `gorm:"column:disabled;not null;default:false" json:"disabled"`
+	// Scopes limits the scopes of the user tokens, empty allows every scope and UserScopesNone none of them
+	Scopes string `gorm:"column:scopes" json:"scopes"`
+
+	// ExternalId is the id of the user in the identity provider, ScimManaged users get
+	// their namespace and scopes from the SCIM groups they belong to
+	ExternalId  string `gorm:"column:external_id;index" json:"external_id"`
+	ScimManaged bool   `gorm:"column:scim_managed;not null;default:false" json:"scim_managed"`
+
+	Groups []Group `gorm:"many2many:group_members;" json:"-"`
+
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (u *User) BeforeCreate(_ *gorm.DB) (err error) {
+	if u.ID == uuid.Nil {
+		u.ID = uuid.New()
+	}
+	return
+}
+
+func (*User) TableName() string {
+	return "users"
+}
+
+func (u *User) FillByName(name string) error {
+	return db.DB().Find(u, "name = ?", name).Error
+}
+
+func (u *User) FillById(id uuid.UUID) error {
+	return db.DB().Find(u, "id = ?", id).Error
+}
+
+func (u *User) Insert() error {
+	return db.DB().Create(u).Error
+}
+
+func (u *User) Save() error {
+	return db.DB().Save(u).Error
+}
+
+// AllowsScope reports whether the tokens of the user can use the scope
+func (u *User) AllowsScope(scope string) bool {
+	return len(u.Scopes) == 0 || slices.Contains(strings.Split(u.Scopes, ","), scope)
+}
+
+func (u *User) Delete() error {
+	err := db.DB().Model(u).Association("Groups").Clear()
+	if err != nil {
+		return err
+	}
+	return db.DB().Delete(&User{}, "id = ?", u.ID).Error
+}
+
+func (u *User) Tokens() (tokens []ApiToken, err error) {
+	tokens = make([]ApiToken, 0)
+	err = db.DB().Order("created_at desc").Find(&tokens, "user_id = ?", u.ID).Error
+	return
+}
+
+// RevokeTokens revokes every active token of the user at once, with the tokens pkgstore issued to the user
+func (u *User) RevokeTokens() error {
+	return db.DB().Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Model(&ApiToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", u.ID).
+			Update("revoked_at", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&IssuedToken{}).
+			Where("auth_id = ? AND revoked_at IS NULL", u.Name).
+			Update("revoked_at", now).Error
+	})
+}
+
+func ListUsers() (users []User, err error) {
+	users = make([]User, 0)
+	err = db.DB().Order("name").Find(&users).Error
+	return
+}
@@ -33,6 +33,9 @@ type PackageVersion[MetaType any] struct {
 	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
 
 	AssetIds string `gorm:"column:asset_ids" json:"asset_ids"`
+
+	// Advisories are the known vulnerabilities of the version, filled by the API
+	Advisories []Advisory `gorm:"-" json:"advisories,omitempty"`
 }
 
 func (p *PackageVersion[T]) BeforeCreate(_ *gorm.DB) (err error) {
@@ -67,7 +70,23 @@ func (p *PackageVersion[T]) Insert() error {
 }
 
 func (p *PackageVersion[T]) SaveMeta() error {
-	return db.DB().Model(p).Update("metadata", p.Metadata).Error
+	err := db.DB().Model(p).Update("metadata", p.Metadata).Error
+	if err != nil {
+		return err
+	}
+	return touchPackage(p.PackageId)
+}
+
+// SaveMetaIfUnchanged saves the metadata only when the version wasn't updated since it was loaded,
+// it reports whether the metadata was saved
+func (p *PackageVersion[T]) SaveMetaIfUnchanged() (bool, error) {
+	result := db.DB().Model(&PackageVersion[T]{}).
+		Where("id = ? AND updated_at = ?", p.ID, p.UpdatedAt).
+		Updates(map[string]any{"metadata": p.Metadata, "updated_at": time.Now()})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	return true, touchPackage(p.PackageId)
 }
 
 func (p *PackageVersion[T]) Save() error {
@@ -75,7 +94,16 @@ func (p *PackageVersion[T]) Save() error {
 }
 
 func (p *PackageVersion[T]) Delete() error {
-	return db.DB().Delete(&PackageVersion[T]{}, "id = ?", p.ID).Error
+	err := db.DB().Delete(&PackageVersion[T]{}, "id = ?", p.ID).Error
+	if err != nil {
+		return err
+	}
+	// The tags can't point to a deleted version
+	err = db.DB().Delete(&PackageTag{}, "package_id = ? AND version = ?", p.PackageId, p.Version).Error
+	if err != nil {
+		return err
+	}
+	return touchPackage(p.PackageId)
 }
 
 func (p *PackageVersion[T]) AddAsset(asset *Asset) error {
@@ -5,8 +5,10 @@ import (
 )
 
 func SyncModels() {
-	err := db.DB().AutoMigrate(&Package[any]{}, &PackageVersion[any]{}, &Asset{})
+	err := db.DB().AutoMigrate(&Package[any]{}, &PackageVersion[any]{}, &Asset{}, &User{}, &ApiToken{}, &TrustedPublisher{}, &RateLimitCounter{}, &AuditLog{}, &PackageGrant{}, &Group{}, &PackageTag{}, &UpstreamDocument{}, &UpstreamTarball{}, &Advisory{}, &ReservedName{}, &IssuedToken{})
 	if err != nil {
 		panic(err)
 	}
+	migrateNpmVersionTags()
+	migrateReservedNames()
 }
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+)
+
+// semver is a parsed semantic version, the build metadata is dropped since it doesn't affect the precedence
+type semver struct {
+	core       [3]int
+	prerelease []string
+}
+
+// parseSemver accepts the versions of npm, with an optional "v" and the missing minor or patch parts as zeros
+func parseSemver(version string) (semver, bool) {
+	version = strings.TrimLeft(strings.TrimSpace(version), "v=")
+	version, _, _ = strings.Cut(version, "+")
+	core, prerelease, hasPrerelease := strings.Cut(version, "-")
+
+	parsed := semver{}
+	parts := strings.Split(core, ".")
+	if len(parts) > 3 {
+		return parsed, false
+	}
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return parsed, false
+		}
+		parsed.core[i] = number
+	}
+	if hasPrerelease {
+		parsed.prerelease = strings.Split(prerelease, ".")
+	}
+	return parsed, true
+}
+
+// compareSemver orders the versions by the semver precedence, a prerelease comes before its release
+func compareSemver(a, b semver) int {
+	for i := range a.core {
+		if a.core[i] != b.core[i] {
+			return compareInts(a.core[i], b.core[i])
+		}
+	}
+	if len(a.prerelease) == 0 || len(b.prerelease) == 0 {
+		return compareInts(len(b.prerelease), len(a.prerelease))
+	}
+	for i := 0; i < len(a.prerelease) && i < len(b.prerelease); i++ {
+		if result := comparePrereleaseIdentifiers(a.prerelease[i], b.prerelease[i]); result != 0 {
+			return result
+		}
+	}
+	return compareInts(len(a.prerelease), len(b.prerelease))
+}
+
+// comparePrereleaseIdentifiers compares the numeric identifiers as numbers, and before the alphanumeric ones
+func comparePrereleaseIdentifiers(a, b string) int {
+	aNumber, aErr := strconv.Atoi(a)
+	bNumber, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareInts(aNumber, bNumber)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(a, b int) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
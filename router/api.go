@@ -18,7 +18,27 @@ func initApiRoutes(r *gin.Engine, storageBackend storage.BaseStorageBackend) {
 		apiRoutes.GET("/packages/:id", apiService.GetPackage)
 		apiRoutes.GET("/packages/:id/versions", apiService.ListVersionsHandler)
 
+		apiRoutes.PUT("/packages/:id/visibility", apiService.SetPackageVisibility)
+
+		apiRoutes.GET("/packages/:id/grants", apiService.ListPackageGrantsHandler)
+		apiRoutes.POST("/packages/:id/grants", apiService.CreatePackageGrantHandler)
+
 		apiRoutes.DELETE("/packages/:id", apiService.DeletePackage)
 		apiRoutes.DELETE("/packages/:id/versions/:versionId", apiService.DeleteVersion)
+
+		apiRoutes.GET("/trusted-publishers", apiService.ListTrustedPublishersHandler)
+		apiRoutes.POST("/trusted-publishers", apiService.CreateTrustedPublisherHandler)
+		apiRoutes.DELETE("/trusted-publishers/:id", apiService.DeleteTrustedPublisherHandler)
+
+		apiRoutes.GET("/policy/explain", apiService.PolicyExplainHandler)
+
+		apiRoutes.POST("/signed-urls", apiService.CreateSignedUrlHandler)
+		apiRoutes.GET("/audit-logs", apiService.ListAuditLogsHandler)
 	}
+
+	// Revoking a grant changes the sharing of the package like creating one, it doesn't delete anything from the package
+	r.DELETE("/api/packages/:id/grants/:grantId", middlewares.PkgActionAccessHandler(apiService, middlewares.PkgActionPush), apiService.DeletePackageGrantHandler)
+
+	// The CI identity token is the credential of the exchange, so it's outside the access handler
+	r.POST("/api/oidc/token", apiService.OidcTokenExchangeHandler)
 }
@@ -7,21 +7,15 @@ import (
 	"github.com/alin-io/pkgstore/services/container"
 	"github.com/alin-io/pkgstore/storage"
 	"github.com/gin-gonic/gin"
-	"strings"
 )
 
 func initContainerRoutes(r *gin.Engine, storageBackend storage.BaseStorageBackend) {
 	containerService := container.NewService(storageBackend)
 	containerRoutes := r.Group("/v2")
 	{
-		containerRoutes.GET("/", func(c *gin.Context) {
-			authToken := c.GetHeader("Authorization")
-			if len(authToken) > 0 {
-				c.JSON(200, gin.H{"token": strings.Split(authToken, " ")[1]})
-			} else {
-				containerService.SetAuthHeaderAndAbort(c)
-			}
-		})
+		containerRoutes.GET("/", containerService.ApiVersionCheckHandler)
+		containerRoutes.GET("/token", containerService.TokenHandler)
+		containerRoutes.POST("/token", containerService.TokenHandler)
 
 		pkgNameParam := ""
 
@@ -29,20 +23,23 @@ func initContainerRoutes(r *gin.Engine, storageBackend storage.BaseStorageBacken
 			pkgNameParam += fmt.Sprintf("/:name%d", i)
 			pkgNameRoutes := containerRoutes.Group(pkgNameParam)
 			{
-				pkgNameRoutes.Use(middlewares.PkgNameAccessHandler(containerService))
+				// The rate limits run before the access handler, so they count the failed authentications
+				metadataRoutes := pkgNameRoutes.Group("", middlewares.RateLimitedAccess(containerService, middlewares.RateLimitMetadata)...)
+				downloadRoutes := pkgNameRoutes.Group("", middlewares.RateLimitedAccess(containerService, middlewares.RateLimitDownload)...)
+				publishRoutes := pkgNameRoutes.Group("", middlewares.RateLimitedAccess(containerService, middlewares.RateLimitPublish)...)
 
 				// Upload Process
-				pkgNameRoutes.GET("blobs/uploads/:uuid", containerService.GetUploadProgressHandler)
-				pkgNameRoutes.HEAD("blobs/:sha256", containerService.CheckBlobExistenceHandler)
-				pkgNameRoutes.POST("blobs/uploads/", containerService.StartLayerUploadHandler)
-				pkgNameRoutes.PATCH("blobs/uploads/:uuid", containerService.ChunkUploadHandler)
-				pkgNameRoutes.PUT("blobs/uploads/:uuid", containerService.UploadHandler)
-				pkgNameRoutes.PUT("manifests/:reference", containerService.ManifestUploadHandler)
+				publishRoutes.GET("blobs/uploads/:uuid", containerService.GetUploadProgressHandler)
+				downloadRoutes.HEAD("blobs/:sha256", containerService.CheckBlobExistenceHandler)
+				publishRoutes.POST("blobs/uploads/", containerService.StartLayerUploadHandler)
+				publishRoutes.PATCH("blobs/uploads/:uuid", containerService.ChunkUploadHandler)
+				publishRoutes.PUT("blobs/uploads/:uuid", containerService.UploadHandler)
+				publishRoutes.PUT("manifests/:reference", containerService.ManifestUploadHandler)
 
 				// Download Process
-				pkgNameRoutes.GET("manifests/:reference", containerService.MetadataHandler)
-				pkgNameRoutes.HEAD("manifests/:reference", containerService.CheckMetadataHandler)
-				pkgNameRoutes.GET("blobs/:sha256", containerService.DownloadHandler)
+				metadataRoutes.GET("manifests/:reference", containerService.MetadataHandler)
+				metadataRoutes.HEAD("manifests/:reference", containerService.CheckMetadataHandler)
+				downloadRoutes.GET("blobs/:sha256", containerService.DownloadHandler)
 			}
 		}
 	}
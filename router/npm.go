@@ -14,18 +14,56 @@ func initNpmRoutes(r *gin.Engine, storageBackend storage.BaseStorageBackend) {
 
 	npmRoutes := r.Group("/npm")
 	{
+		searchRoutes := npmRoutes.Group("/-/v1", middlewares.RateLimitedAccess(npmService, middlewares.RateLimitMetadata)...)
+		{
+			searchRoutes.GET("/search", npmService.SearchHandler)
+		}
+
+		// The logins exchange the credentials for a token, so they are outside the access handler,
+		// and only limited by IP against the password guessing
+		npmRoutes.GET("/-/ping", npmService.PingHandler)
+		npmRoutes.PUT("/-/user/:user", middlewares.RateLimitHandler(npmService, middlewares.RateLimitLogin), npmService.AddUserHandler)
+		npmRoutes.POST("/-/v1/login", npmService.WebLoginHandler)
+		npmRoutes.GET("/-/v1/login/:sessionId", npmService.LoginPageHandler)
+		npmRoutes.POST("/-/v1/login/:sessionId", middlewares.RateLimitHandler(npmService, middlewares.RateLimitLogin), npmService.LoginSubmitHandler)
+		npmRoutes.GET("/-/v1/done/:sessionId", npmService.LoginDoneHandler)
+		npmRoutes.GET("/-/whoami", middlewares.PkgNameAccessHandler(npmService), npmService.WhoamiHandler)
+		// npm audit POSTs the dependency tree, it only reads the advisories
+		npmRoutes.POST("/-/npm/v1/security/advisories/bulk", middlewares.PkgActionAccessHandler(npmService, middlewares.PkgActionPull), npmService.AdvisoriesBulkHandler)
+
 		pkgNameParam := ""
 		for i := 0; i < config.NumberOfPkgNameLevels; i++ {
 			pkgNameParam += fmt.Sprintf("/:name%d", i)
 
 			pkgNameRoutes := npmRoutes.Group(pkgNameParam)
 			{
-				pkgNameRoutes.Use(middlewares.PkgNameAccessHandler(npmService))
+				// The rate limits run before the access handler, so they count the failed authentications
+				metadataRoutes := pkgNameRoutes.Group("", middlewares.RateLimitedAccess(npmService, middlewares.RateLimitMetadata)...)
+				downloadRoutes := pkgNameRoutes.Group("", middlewares.RateLimitedAccess(npmService, middlewares.RateLimitDownload)...)
+				publishRoutes := pkgNameRoutes.Group("", middlewares.RateLimitedAccess(npmService, middlewares.RateLimitPublish)...)
+				// npm unpublishes the versions by sending the packument back without them, so the -rev PUT is a delete too
+				deleteRoutes := pkgNameRoutes.Group("", middlewares.RateLimitedActionAccess(npmService, middlewares.RateLimitDelete, middlewares.PkgActionDelete)...)
+
+				metadataRoutes.GET("", npmService.MetadataHandler)
+				downloadRoutes.GET("-/:filename", npmService.DownloadHandler)
+
+				publishRoutes.PUT("", npmService.UploadHandler)
+				deleteRoutes.PUT("-rev/:rev", npmService.UpdatePackumentHandler)
+				deleteRoutes.DELETE("-rev/:rev", npmService.UnpublishPackageHandler)
+				deleteRoutes.DELETE("-/:filename/-rev/:rev", npmService.UnpublishTarballHandler)
+			}
+
+			accessRoutes := npmRoutes.Group("/-/package" + pkgNameParam)
+			{
+				accessRoutes.Use(middlewares.PkgNameAccessHandler(npmService))
 
-				pkgNameRoutes.GET("", npmService.MetadataHandler)
-				pkgNameRoutes.GET("-/:filename", npmService.DownloadHandler)
+				accessRoutes.GET("/access", npmService.AccessHandler)
+				accessRoutes.GET("/visibility", npmService.VisibilityHandler)
+				accessRoutes.POST("/access", npmService.SetAccessHandler)
 
-				pkgNameRoutes.PUT("", npmService.UploadHandler)
+				accessRoutes.GET("/dist-tags", npmService.DistTagsHandler)
+				accessRoutes.PUT("/dist-tags/:tag", npmService.SetDistTagHandler)
+				accessRoutes.DELETE("/dist-tags/:tag", npmService.DeleteDistTagHandler)
 			}
 		}
 	}
@@ -13,19 +13,22 @@ func initPypiRoutes(r *gin.Engine, storageBackend storage.BaseStorageBackend) {
 	pypiService := pypi.NewService(storageBackend)
 	pypiRoutes := r.Group("/pypi")
 	{
-		pypiRoutes.Use(middlewares.PkgNameAccessHandler(pypiService))
+		// The rate limits run before the access handler, so they count the failed authentications
+		metadataRoutes := pypiRoutes.Group("", middlewares.RateLimitedAccess(pypiService, middlewares.RateLimitMetadata)...)
+		downloadRoutes := pypiRoutes.Group("", middlewares.RateLimitedAccess(pypiService, middlewares.RateLimitDownload)...)
+		publishRoutes := pypiRoutes.Group("", middlewares.RateLimitedAccess(pypiService, middlewares.RateLimitPublish)...)
 
 		pkgNameParam := ""
 		for i := 0; i < config.NumberOfPkgNameLevels; i++ {
 			pkgNameParam += fmt.Sprintf("/:name%d", i)
-			pypiRoutes.GET(
+			metadataRoutes.GET(
 				"/simple/"+pkgNameParam,
 				pypiService.MetadataHandler,
 			)
 		}
 
-		pypiRoutes.GET("/files/:sha256/:filename", pypiService.DownloadHandler)
+		downloadRoutes.GET("/files/:sha256/:filename", pypiService.DownloadHandler)
 
-		pypiRoutes.POST("", pypiService.UploadHandler)
+		publishRoutes.POST("", pypiService.UploadHandler)
 	}
 }
@@ -1,9 +1,12 @@
 package router
 
 import (
+	"expvar"
+	"github.com/alin-io/pkgstore/config"
 	"github.com/alin-io/pkgstore/services"
 	"github.com/alin-io/pkgstore/storage"
 	"github.com/gin-gonic/gin"
+	"net/http"
 )
 
 func SetupGinServer() *gin.Engine {
@@ -11,6 +14,11 @@ func SetupGinServer() *gin.Engine {
 	r.Use(gin.Logger())
 	r.Use(gin.Recovery())
 
+	// The client IP of the rate limits only comes from X-Forwarded-For behind the trusted proxies
+	if err := r.SetTrustedProxies(config.Get().TrustedProxies); err != nil {
+		panic(err)
+	}
+
 	r.GET("/", services.HealthCheckHandler)
 
 	r.RedirectTrailingSlash = false
@@ -18,9 +26,17 @@ func SetupGinServer() *gin.Engine {
 	return r
 }
 
+// SetupMetricsServer serves the expvar metrics on their own address, so they aren't exposed on the public listener
+func SetupMetricsServer() *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	return &http.Server{Addr: config.Get().MetricsAddress, Handler: mux}
+}
+
 func PackageRouter(r *gin.Engine, storageBackend storage.BaseStorageBackend) {
 	initNpmRoutes(r, storageBackend)
 	initPypiRoutes(r, storageBackend)
 	initContainerRoutes(r, storageBackend)
 	initApiRoutes(r, storageBackend)
+	initScimRoutes(r)
 }
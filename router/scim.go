@@ -0,0 +1,29 @@
+package router
+
+import (
+	"github.com/alin-io/pkgstore/middlewares"
+	"github.com/alin-io/pkgstore/services/scim"
+	"github.com/gin-gonic/gin"
+)
+
+func initScimRoutes(r *gin.Engine) {
+	scimService := scim.NewService()
+	scimRoutes := r.Group("/scim/v2")
+	{
+		scimRoutes.Use(middlewares.ScimAuthHandler())
+
+		scimRoutes.GET("/Users", scimService.ListUsersHandler)
+		scimRoutes.POST("/Users", scimService.CreateUserHandler)
+		scimRoutes.GET("/Users/:id", scimService.GetUserHandler)
+		scimRoutes.PUT("/Users/:id", scimService.ReplaceUserHandler)
+		scimRoutes.PATCH("/Users/:id", scimService.PatchUserHandler)
+		scimRoutes.DELETE("/Users/:id", scimService.DeleteUserHandler)
+
+		scimRoutes.GET("/Groups", scimService.ListGroupsHandler)
+		scimRoutes.POST("/Groups", scimService.CreateGroupHandler)
+		scimRoutes.GET("/Groups/:id", scimService.GetGroupHandler)
+		scimRoutes.PUT("/Groups/:id", scimService.ReplaceGroupHandler)
+		scimRoutes.PATCH("/Groups/:id", scimService.PatchGroupHandler)
+		scimRoutes.DELETE("/Groups/:id", scimService.DeleteGroupHandler)
+	}
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"github.com/alin-io/pkgstore/db"
+	"github.com/alin-io/pkgstore/middlewares"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type packageGrantRequestBody struct {
+	GranteeType string `json:"grantee_type" binding:"required,oneof=namespace user"`
+	Grantee     string `json:"grantee" binding:"required"`
+	Read        bool   `json:"read"`
+	Publish     bool   `json:"publish"`
+}
+
+// ownedPackage loads the package of the id param when it belongs to the namespace of the caller
+func (s *Service) ownedPackage(c *gin.Context) (*models.Package[any], bool) {
+	packageId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid package id"})
+		return nil, false
+	}
+
+	pkg := models.Package[any]{}
+	err = db.DB().Model(&pkg).Where("id = ? AND namespace = ?", packageId, middlewares.GetAuthCtx(c).Namespace).Find(&pkg).Error
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if pkg.ID == uuid.Nil {
+		c.JSON(404, gin.H{"error": "Package not found"})
+		return nil, false
+	}
+	return &pkg, true
+}
+
+func (s *Service) ListPackageGrantsHandler(c *gin.Context) {
+	pkg, ok := s.ownedPackage(c)
+	if !ok {
+		return
+	}
+
+	grants, err := models.ListPackageGrants(pkg.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, grants)
+}
+
+// CreatePackageGrantHandler POST /api/packages/:id/grants shares the package with another namespace or user
+func (s *Service) CreatePackageGrantHandler(c *gin.Context) {
+	requestBody := packageGrantRequestBody{}
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !requestBody.Read && !requestBody.Publish {
+		c.JSON(400, gin.H{"error": "The grant needs the read or the publish permission"})
+		return
+	}
+	if requestBody.Grantee == middlewares.AuthIdAnonymous || requestBody.Grantee == middlewares.AuthIdPublic {
+		c.JSON(400, gin.H{"error": "Use the package visibility to share it with everyone"})
+		return
+	}
+
+	pkg, ok := s.ownedPackage(c)
+	if !ok {
+		return
+	}
+	if requestBody.GranteeType == models.GranteeTypeNamespace && requestBody.Grantee == pkg.Namespace {
+		c.JSON(400, gin.H{"error": "The package namespace already has access to it"})
+		return
+	}
+
+	existing := models.PackageGrant{}
+	err = existing.FillByGrantee(pkg.ID, requestBody.GranteeType, requestBody.Grantee)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if existing.ID != uuid.Nil {
+		c.JSON(409, gin.H{"error": "The package is already shared with this grantee, revoke the grant to change it"})
+		return
+	}
+
+	grant := models.PackageGrant{
+		PackageId:   pkg.ID,
+		GranteeType: requestBody.GranteeType,
+		Grantee:     requestBody.Grantee,
+		Read:        requestBody.Read,
+		Publish:     requestBody.Publish,
+		AuthId:      middlewares.GetAuthCtx(c).AuthId,
+	}
+	err = grant.Insert()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, grant)
+}
+
+// DeletePackageGrantHandler DELETE /api/packages/:id/grants/:grantId revokes the sharing of the package
+func (s *Service) DeletePackageGrantHandler(c *gin.Context) {
+	grantId, err := uuid.Parse(c.Param("grantId"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid grant id"})
+		return
+	}
+
+	pkg, ok := s.ownedPackage(c)
+	if !ok {
+		return
+	}
+
+	grant := models.PackageGrant{}
+	err = grant.FillById(grantId, pkg.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if grant.ID == uuid.Nil {
+		c.JSON(404, gin.H{"error": "Grant not found"})
+		return
+	}
+
+	err = grant.Delete()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, grant)
+}
@@ -37,9 +37,32 @@ func (s *Service) GetPackage(c *gin.Context) {
 		c.JSON(404, gin.H{"error": "Package not found"})
 		return
 	}
+	if err = fillVersionAdvisories(&pkg); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(200, pkg)
 }
 
+// fillVersionAdvisories attaches the imported advisories to the affected versions, only npm has advisories for now
+func fillVersionAdvisories(pkg *models.Package[any]) error {
+	if pkg.Service != "npm" {
+		return nil
+	}
+	advisories, err := models.ListAdvisories(pkg.Service, []string{pkg.Name})
+	if err != nil {
+		return err
+	}
+	for i := range pkg.Versions {
+		for _, advisory := range advisories {
+			if advisory.Affects(pkg.Versions[i].Version) {
+				pkg.Versions[i].Advisories = append(pkg.Versions[i].Advisories, advisory)
+			}
+		}
+	}
+	return nil
+}
+
 func (s *Service) DeletePackage(c *gin.Context) {
 	packageIdString := c.Param("id")
 	packageId, err := uuid.Parse(packageIdString)
@@ -65,3 +88,46 @@ func (s *Service) DeletePackage(c *gin.Context) {
 	}
 	c.JSON(200, pkg)
 }
+
+type packageVisibilityRequestBody struct {
+	Public *bool `json:"public" binding:"required"`
+}
+
+func (s *Service) SetPackageVisibility(c *gin.Context) {
+	packageIdString := c.Param("id")
+	packageId, err := uuid.Parse(packageIdString)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid package id"})
+		return
+	}
+
+	requestBody := packageVisibilityRequestBody{}
+	err = c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Exposing a package is as sensitive as deleting it
+	if *requestBody.Public && !middlewares.GetAuthCtx(c).Delete {
+		c.JSON(403, gin.H{"error": "Making a package public requires the delete permission"})
+		return
+	}
+
+	pkg := models.Package[any]{}
+	err = db.DB().Model(&pkg).Where("id = ? AND auth_id = ?", packageId, middlewares.GetAuthCtx(c).AuthId).Find(&pkg).Error
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if pkg.ID == uuid.Nil {
+		c.JSON(404, gin.H{"error": "Package not found"})
+		return
+	}
+	err = pkg.SetPublic(*requestBody.Public)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, pkg)
+}
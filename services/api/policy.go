@@ -0,0 +1,44 @@
+package api
+
+import (
+	"github.com/alin-io/pkgstore/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+type policyExplainQuery struct {
+	Service string `form:"service" binding:"required,oneof=npm pypi container"`
+	Package string `form:"package" binding:"required"`
+	Action  string `form:"action" binding:"omitempty,oneof=pull push delete"`
+}
+
+// PolicyExplainHandler GET /api/policy/explain shows how the access of the caller to a package action is decided
+func (s *Service) PolicyExplainHandler(c *gin.Context) {
+	query := policyExplainQuery{}
+	err := c.ShouldBindQuery(&query)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if len(query.Action) == 0 {
+		query.Action = middlewares.PkgActionPull
+	}
+
+	authCtx := *middlewares.GetAuthCtx(c)
+	pkgName, namespace := s.SplitPkgName(query.Package)
+
+	var explanation *middlewares.AccessExplanation
+	if authCtx.AuthId == middlewares.AuthIdPublic {
+		explanation = &middlewares.AccessExplanation{Allowed: true, Message: "The registry runs without authentication", Source: "public"}
+	} else {
+		explanation = middlewares.ExplainPkgAccess(query.Service, &authCtx, pkgName, namespace, query.Action)
+	}
+
+	c.JSON(200, gin.H{
+		"subject":   authCtx.AuthId,
+		"service":   query.Service,
+		"package":   pkgName,
+		"namespace": namespace,
+		"action":    query.Action,
+		"decision":  explanation,
+	})
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/middlewares"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type signedUrlRequestBody struct {
+	Service  string `json:"service" binding:"required,oneof=npm pypi container"`
+	Package  string `json:"package" binding:"required"`
+	Version  string `json:"version"`
+	Filename string `json:"filename"`
+	Digest   string `json:"digest"`
+	// Ttl is the lifetime of the link in seconds, the default one is used when it's empty
+	Ttl int64 `json:"ttl"`
+}
+
+// CreateSignedUrlHandler POST /api/signed-urls mints a time-limited download link of a package file or a container blob
+func (s *Service) CreateSignedUrlHandler(c *gin.Context) {
+	authCtx := middlewares.GetAuthCtx(c)
+	requestBody := signedUrlRequestBody{}
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if len(config.Get().SignedUrls.Keys) == 0 {
+		c.JSON(501, gin.H{"error": "Signed URLs are not configured on this registry"})
+		return
+	}
+
+	pkgName, namespace := s.SplitPkgName(requestBody.Package)
+	if authCtx.AuthId != middlewares.AuthIdPublic && namespace != authCtx.Namespace {
+		c.JSON(403, gin.H{"error": "You don't have access to this namespace"})
+		return
+	}
+
+	ttl := config.Get().SignedUrls.DefaultTTL
+	if requestBody.Ttl > 0 {
+		ttl = time.Duration(requestBody.Ttl) * time.Second
+	}
+	if ttl > config.Get().SignedUrls.MaxTTL {
+		c.JSON(400, gin.H{"error": "The link lifetime is longer than the allowed " + config.Get().SignedUrls.MaxTTL.String()})
+		return
+	}
+
+	pkg := models.Package[any]{Namespace: authCtx.Namespace, Service: requestBody.Service}
+	err = pkg.FillByName(pkgName)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if pkg.ID == uuid.Nil {
+		c.JSON(404, gin.H{"error": "Package not found"})
+		return
+	}
+
+	downloadPath, registryHost, err := s.signedUrlDownloadPath(&pkg, requestBody)
+	if err != nil {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	query, err := middlewares.SignDownloadPath(downloadPath, authCtx.Namespace, expiresAt)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	models.RecordAuditLog(&models.AuditLog{
+		Event:       models.AuditEventSignedUrlCreate,
+		AuthId:      authCtx.AuthId,
+		Namespace:   authCtx.Namespace,
+		Service:     requestBody.Service,
+		PackageName: pkgName,
+		Detail:      fmt.Sprintf("%s expires %s", downloadPath, expiresAt.UTC().Format(time.RFC3339)),
+		RemoteAddr:  c.ClientIP(),
+	})
+
+	c.JSON(200, gin.H{
+		"url":        registryHost + downloadPath + "?" + query,
+		"expires_at": expiresAt.UTC(),
+	})
+}
+
+// signedUrlDownloadPath returns the download route of the requested file with the registry host serving it
+func (s *Service) signedUrlDownloadPath(pkg *models.Package[any], requestBody signedUrlRequestBody) (string, string, error) {
+	baseName := path.Base(pkg.Name)
+	registryHosts := config.Get().RegistryHosts
+
+	if requestBody.Service == "container" {
+		digest := strings.Replace(requestBody.Digest, "sha256:", "", 1)
+		asset := models.Asset{Service: requestBody.Service}
+		err := asset.FillByDigest(digest)
+		if err != nil || len(digest) == 0 || asset.Digest != digest {
+			return "", "", errors.New("Blob not found")
+		}
+		// The digest alone would sign the blobs of any repository, only the layers of the package are allowed
+		if referenced, err := pkg.HasAsset(&asset); err != nil || !referenced {
+			return "", "", errors.New("Blob not found")
+		}
+		return fmt.Sprintf("/v2/%s/blobs/sha256:%s", pkg.Name, digest), strings.TrimSuffix(registryHosts.Container, "/v2"), nil
+	}
+
+	version, err := pkg.Version(requestBody.Version)
+	if err != nil || version.ID == uuid.Nil || len(version.Digest) == 0 {
+		return "", "", errors.New("Package version not found")
+	}
+
+	if requestBody.Service == "npm" {
+		return fmt.Sprintf("/npm/%s/-/%s-%s.tgz", pkg.Name, baseName, version.Version), strings.TrimSuffix(registryHosts.Npm, "/npm"), nil
+	}
+
+	filename := requestBody.Filename
+	if len(filename) == 0 {
+		filename = fmt.Sprintf("%s-%s.tar.gz", baseName, version.Version)
+	}
+	if _, filenameVersion := s.PkgVersionFromFilename(filename); filenameVersion != version.Version {
+		return "", "", errors.New("The file doesn't belong to the package version")
+	}
+	return fmt.Sprintf("/pypi/files/%s/%s", version.Digest, path.Base(filename)), strings.TrimSuffix(registryHosts.Pypi, "/pypi"), nil
+}
+
+// ListAuditLogsHandler GET /api/audit-logs lists the latest audit events of the namespace
+func (s *Service) ListAuditLogsHandler(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		c.JSON(400, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	auditLogs, err := models.ListAuditLogs(middlewares.GetAuthCtx(c).Namespace, limit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, auditLogs)
+}
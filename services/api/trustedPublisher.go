@@ -0,0 +1,167 @@
+package api
+
+import (
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/middlewares"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log"
+	"slices"
+)
+
+type trustedPublisherRequestBody struct {
+	Service     string `json:"service" binding:"required,oneof=npm pypi container"`
+	PackageName string `json:"package_name" binding:"required"`
+	Issuer      string `json:"issuer" binding:"required"`
+	Repository  string `json:"repository" binding:"required"`
+	Workflow    string `json:"workflow"`
+	Branch      string `json:"branch"`
+	Environment string `json:"environment"`
+}
+
+type oidcTokenRequestBody struct {
+	Token   string `json:"token" binding:"required"`
+	Service string `json:"service" binding:"required,oneof=npm pypi container"`
+	Package string `json:"package" binding:"required"`
+}
+
+func (s *Service) ListTrustedPublishersHandler(c *gin.Context) {
+	publishers, err := models.ListTrustedPublishers(middlewares.GetAuthCtx(c).Namespace)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, publishers)
+}
+
+func (s *Service) CreateTrustedPublisherHandler(c *gin.Context) {
+	authCtx := middlewares.GetAuthCtx(c)
+	requestBody := trustedPublisherRequestBody{}
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	pkgName, namespace := s.SplitPkgName(requestBody.PackageName)
+	if authCtx.AuthId != middlewares.AuthIdPublic && namespace != authCtx.Namespace {
+		c.JSON(403, gin.H{"error": "You don't have access to this namespace"})
+		return
+	}
+	// The identity tokens of the other issuers are refused by the exchange, the publisher would never match
+	if !slices.Contains(config.Get().Auth.Oidc.Issuers, requestBody.Issuer) {
+		c.JSON(400, gin.H{"error": "The issuer isn't one of the trusted OIDC issuers"})
+		return
+	}
+
+	publisher := models.TrustedPublisher{
+		Service:     requestBody.Service,
+		PackageName: pkgName,
+		AuthId:      authCtx.AuthId,
+		Namespace:   authCtx.Namespace,
+		Issuer:      requestBody.Issuer,
+		Repository:  requestBody.Repository,
+		Workflow:    requestBody.Workflow,
+		Branch:      requestBody.Branch,
+		Environment: requestBody.Environment,
+	}
+	err = publisher.Insert()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, publisher)
+}
+
+func (s *Service) DeleteTrustedPublisherHandler(c *gin.Context) {
+	publisherId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid trusted publisher id"})
+		return
+	}
+
+	publisher := models.TrustedPublisher{}
+	err = publisher.FillById(publisherId, middlewares.GetAuthCtx(c).Namespace)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if publisher.ID == uuid.Nil {
+		c.JSON(404, gin.H{"error": "Trusted publisher not found"})
+		return
+	}
+
+	err = publisher.Delete()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, publisher)
+}
+
+// OidcTokenExchangeHandler POST /api/oidc/token exchanges a CI identity token for a short-lived publish token
+func (s *Service) OidcTokenExchangeHandler(c *gin.Context) {
+	requestBody := oidcTokenRequestBody{}
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims, err := middlewares.VerifyOidcToken(c, requestBody.Token)
+	if err != nil {
+		log.Println("Rejected OIDC token: ", err)
+		c.JSON(401, gin.H{"error": "Invalid identity token"})
+		return
+	}
+	issuer, _ := claims.GetIssuer()
+	pkgName, _ := s.SplitPkgName(requestBody.Package)
+
+	publishers, err := models.FindTrustedPublishers(requestBody.Service, pkgName, issuer)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	var publisher *models.TrustedPublisher
+	for i := range publishers {
+		if middlewares.MatchTrustedPublisher(&publishers[i], claims) {
+			publisher = &publishers[i]
+			break
+		}
+	}
+	if publisher == nil {
+		c.JSON(403, gin.H{"error": "No trusted publisher matches the identity token"})
+		return
+	}
+	// The token is issued in the name of the user who registered the publisher
+	if err = middlewares.CheckIssuedTokenSubject(publisher.AuthId); err != nil {
+		c.JSON(403, gin.H{"error": "The owner of the trusted publisher is disabled"})
+		return
+	}
+
+	tokenClaims := &middlewares.IssuedTokenClaims{
+		TokenType: middlewares.IssuedTokenTypeAccess,
+		Namespace: publisher.Namespace,
+		Access: []middlewares.TokenAccess{{
+			Type:    middlewares.TokenAccessType(publisher.Service),
+			Name:    publisher.PackageName,
+			Actions: []string{middlewares.PkgActionPull, middlewares.PkgActionPush},
+		}},
+	}
+	tokenClaims.Subject = publisher.AuthId
+	tokenTTL := config.Get().Auth.Oidc.PublishTokenTTL
+	token, err := middlewares.SignIssuedToken(tokenClaims, tokenTTL)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Unable to issue the token"})
+		return
+	}
+
+	subject, _ := claims.GetSubject()
+	log.Println("Issued publish token for", publisher.Service, publisher.PackageName, "to", subject)
+	c.JSON(200, gin.H{
+		"token":      token,
+		"expires_in": int(tokenTTL.Seconds()),
+	})
+}
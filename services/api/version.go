@@ -2,10 +2,10 @@ package api
 
 import (
 	"github.com/alin-io/pkgstore/db"
+	"github.com/alin-io/pkgstore/middlewares"
 	"github.com/alin-io/pkgstore/models"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"strconv"
 )
 
 func (s *Service) ListVersionsHandler(c *gin.Context) {
@@ -26,6 +26,10 @@ func (s *Service) ListVersionsHandler(c *gin.Context) {
 		c.JSON(404, gin.H{"error": "Package not found"})
 		return
 	}
+	if err = fillVersionAdvisories(&pkg); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(200, pkg.Versions)
 }
 
@@ -38,14 +42,23 @@ func (s *Service) DeleteVersion(c *gin.Context) {
 		return
 	}
 
-	versionId, err := strconv.ParseUint(versionIdString, 10, 64)
+	versionId, err := uuid.Parse(versionIdString)
 	if err != nil {
 		c.JSON(400, gin.H{"error": "Invalid version id"})
 		return
 	}
 
 	version := models.PackageVersion[any]{}
-	err = db.DB().Model(&version).Delete(`"package_id" = ? AND id = ?`, packageId, versionId).Error
+	err = db.DB().Model(&version).Where(`"package_id" = ? AND id = ? AND auth_id = ?`, packageId, versionId, middlewares.GetAuthCtx(c).AuthId).Find(&version).Error
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if version.ID == uuid.Nil {
+		c.JSON(404, gin.H{"error": "Package version not found"})
+		return
+	}
+	err = version.Delete()
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
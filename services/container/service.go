@@ -73,7 +73,17 @@ func (s *Service) SetAuthHeaderAndAbort(c *gin.Context) {
 		c.Abort()
 		return
 	}
-	c.Header("www-authenticate", fmt.Sprintf(`Bearer realm="%[1]s/",service="%[2]s"`, registryHost, registryHostUrl.Hostname()))
+	authenticateHeader := fmt.Sprintf(`Bearer realm="%[1]s/token",service="%[2]s"`, registryHost, registryHostUrl.Hostname())
+	if pkgName, _ := s.ConstructFullPkgName(c); len(pkgName) > 0 {
+		actions := "pull"
+		if c.Request.Method == "DELETE" {
+			actions = "delete"
+		} else if c.Request.Method != "GET" && c.Request.Method != "HEAD" {
+			actions = "pull,push"
+		}
+		authenticateHeader += fmt.Sprintf(`,scope="repository:%s:%s"`, pkgName, actions)
+	}
+	c.Header("www-authenticate", authenticateHeader)
 	c.JSON(401, gin.H{
 		"errors": []gin.H{
 			{
@@ -0,0 +1,175 @@
+package container
+
+import (
+	"errors"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/middlewares"
+	"github.com/gin-gonic/gin"
+	"strings"
+	"time"
+)
+
+type TokenResponse struct {
+	Token        string `json:"token"`
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	IssuedAt     string `json:"issued_at"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// ApiVersionCheckHandler GET /v2/
+func (s *Service) ApiVersionCheckHandler(c *gin.Context) {
+	c.Header("Docker-Distribution-API-Version", "registry/2.0")
+	if middlewares.ActiveAuthProvider() == nil || middlewares.ClientCertAuthResult(c) != nil {
+		c.JSON(200, gin.H{})
+		return
+	}
+
+	token, err := middlewares.ExtractTokenHeader(c)
+	if err == nil {
+		_, err = middlewares.ParseIssuedToken(token, middlewares.IssuedTokenTypeAccess)
+	}
+	if err != nil {
+		s.SetAuthHeaderAndAbort(c)
+		return
+	}
+	c.JSON(200, gin.H{})
+}
+
+// TokenHandler GET|POST /v2/token implements the Docker registry token authentication
+func (s *Service) TokenHandler(c *gin.Context) {
+	var (
+		authProvider    = middlewares.ActiveAuthProvider()
+		credentials     = ""
+		refreshIdentity *middlewares.AuthResult
+		scopes          = c.QueryArray("scope")
+		offline         = c.Query("offline_token") == "true"
+		audience        = c.Query("service")
+		err             error
+	)
+
+	if c.Request.Method == "POST" {
+		scopes = strings.Fields(c.PostForm("scope"))
+		offline = c.PostForm("access_type") == "offline"
+		audience = c.PostForm("service")
+
+		switch c.PostForm("grant_type") {
+		case "refresh_token":
+			refreshIdentity, err = middlewares.ExchangeRefreshToken(c.PostForm("refresh_token"))
+		case "password":
+			credentials = c.PostForm("username") + ":" + c.PostForm("password")
+		default:
+			c.JSON(400, gin.H{"error": "unsupported_grant_type"})
+			return
+		}
+	} else if len(c.GetHeader("Authorization")) > 0 {
+		credentials, err = middlewares.ExtractTokenHeader(c)
+	}
+	if err != nil {
+		s.AbortRequestWithError(c, 401, "invalid credentials")
+		return
+	}
+
+	authenticate := func(pkgName, action string) (*middlewares.AuthResult, error) {
+		if authProvider == nil {
+			return &middlewares.AuthResult{AuthId: middlewares.AuthIdPublic, PublicAccess: true, Read: true, Write: true, Delete: true}, nil
+		}
+		if refreshIdentity != nil {
+			return refreshIdentity, nil
+		}
+		if len(credentials) == 0 {
+			// Anonymous clients only get the pulls of public images
+			if len(pkgName) > 0 && action != middlewares.PkgActionPull {
+				return nil, errors.New("authentication required")
+			}
+			return middlewares.AnonymousAuthResult(), nil
+		}
+		// Publish tokens of trusted publishers can be used as the docker login password
+		if issuedToken, err := middlewares.ParseIssuedToken(middlewares.CredentialSecret(credentials), middlewares.IssuedTokenTypeAccess); err == nil {
+			if len(pkgName) == 0 {
+				return &middlewares.AuthResult{AuthId: issuedToken.Subject, Namespace: issuedToken.Namespace}, nil
+			}
+			return issuedToken.AuthResult(s.Prefix, pkgName, action)
+		}
+		return authProvider.Authenticate(c, pkgName, credentials, s.Prefix, action)
+	}
+
+	identity, err := authenticate("", middlewares.PkgActionPull)
+	if err != nil {
+		s.AbortRequestWithError(c, 401, "invalid credentials")
+		return
+	}
+
+	access := make([]middlewares.TokenAccess, 0)
+	for _, scope := range scopes {
+		scopeParts := strings.Split(scope, ":")
+		if len(scopeParts) != 3 || scopeParts[0] != middlewares.TokenAccessRepository {
+			continue
+		}
+		pkgName, namespace := s.SplitPkgName(scopeParts[1])
+		grantedActions := make([]string, 0)
+		for _, action := range s.expandScopeActions(scopeParts[2]) {
+			if authProvider != nil {
+				authResult, err := authenticate(pkgName, action)
+				if err != nil {
+					continue
+				}
+				if _, status, _ := middlewares.CheckPkgAccess(s.Prefix, authResult, pkgName, namespace, action); status != 0 {
+					continue
+				}
+			}
+			grantedActions = append(grantedActions, action)
+		}
+		access = append(access, middlewares.TokenAccess{
+			Type:    middlewares.TokenAccessRepository,
+			Name:    pkgName,
+			Actions: grantedActions,
+		})
+	}
+
+	tokenClaims := &middlewares.IssuedTokenClaims{
+		TokenType: middlewares.IssuedTokenTypeAccess,
+		Namespace: identity.Namespace,
+		Access:    access,
+	}
+	tokenClaims.Subject = identity.AuthId
+	if len(audience) > 0 {
+		tokenClaims.Audience = []string{audience}
+	}
+	tokenTTL := config.Get().Auth.TokenTTL
+	token, err := middlewares.SignIssuedToken(tokenClaims, tokenTTL)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Unable to issue the token"})
+		return
+	}
+
+	result := TokenResponse{
+		Token:       token,
+		AccessToken: token,
+		ExpiresIn:   int(tokenTTL.Seconds()),
+		IssuedAt:    tokenClaims.IssuedAt.Format(time.RFC3339),
+	}
+
+	// The exchanged refresh token is revoked, so a new one is always returned
+	if (offline || refreshIdentity != nil) && identity.AuthId != middlewares.AuthIdAnonymous {
+		result.RefreshToken, err = middlewares.SignRefreshToken(identity)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Unable to issue the token"})
+			return
+		}
+	}
+
+	c.JSON(200, result)
+}
+
+func (s *Service) expandScopeActions(actions string) []string {
+	result := make([]string, 0)
+	for _, action := range strings.Split(actions, ",") {
+		if action == "*" {
+			result = append(result, middlewares.PkgActionPull, middlewares.PkgActionPush, middlewares.PkgActionDelete)
+		} else if action == middlewares.PkgActionPull || action == middlewares.PkgActionPush || action == middlewares.PkgActionDelete {
+			result = append(result, action)
+		}
+	}
+	return result
+}
@@ -26,6 +26,14 @@ func (g *GarbageCollector) CleanupAssets(dryrun bool) (assets []models.Asset, er
 		}
 
 		if version == nil || version.ID == uuid.Nil {
+			// The tarballs cached by the npm proxy don't belong to a package version
+			isUpstream, err := models.IsUpstreamAsset(asset.ID)
+			if err != nil {
+				return nil, err
+			}
+			if isUpstream {
+				continue
+			}
 			assets = append(assets, asset)
 			if !dryrun {
 				err = g.DeleteAsset(&asset)
@@ -0,0 +1,86 @@
+package npm
+
+import (
+	"github.com/alin-io/pkgstore/middlewares"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	npmAccessPublic     = "public"
+	npmAccessRestricted = "restricted"
+)
+
+type accessRequestBody struct {
+	Access string `json:"access" binding:"required,oneof=public restricted private"`
+}
+
+func (s *Service) findAccessPackage(c *gin.Context) *models.Package[PackageMetadata] {
+	pkgName, _ := s.ConstructFullPkgName(c)
+	pkg := &models.Package[PackageMetadata]{
+		Namespace: middlewares.GetAuthCtx(c).Namespace,
+		Service:   s.Prefix,
+	}
+	err := pkg.FillByName(pkgName)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Error while trying to get package info"})
+		return nil
+	}
+	if pkg.ID == uuid.Nil {
+		c.JSON(404, gin.H{"error": "Package not found"})
+		return nil
+	}
+	return pkg
+}
+
+// AccessHandler GET /-/package/:name/access reports the access level used by "npm access"
+func (s *Service) AccessHandler(c *gin.Context) {
+	pkg := s.findAccessPackage(c)
+	if pkg == nil {
+		return
+	}
+
+	access := npmAccessRestricted
+	if pkg.IsPublic {
+		access = npmAccessPublic
+	}
+	c.JSON(200, gin.H{"access": access})
+}
+
+// VisibilityHandler GET /-/package/:name/visibility is queried by "npm access get status"
+func (s *Service) VisibilityHandler(c *gin.Context) {
+	pkg := s.findAccessPackage(c)
+	if pkg == nil {
+		return
+	}
+	c.JSON(200, gin.H{"public": pkg.IsPublic})
+}
+
+// SetAccessHandler POST /-/package/:name/access maps "npm access public|restricted" to the package visibility
+func (s *Service) SetAccessHandler(c *gin.Context) {
+	requestBody := accessRequestBody{}
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Exposing a package is as sensitive as deleting it
+	if requestBody.Access == npmAccessPublic && !middlewares.GetAuthCtx(c).Delete {
+		c.JSON(403, gin.H{"error": "Making a package public requires the delete permission"})
+		return
+	}
+
+	pkg := s.findAccessPackage(c)
+	if pkg == nil {
+		return
+	}
+
+	err = pkg.SetPublic(requestBody.Access == npmAccessPublic)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Error while trying to update the package access"})
+		return
+	}
+	c.JSON(200, gin.H{"access": requestBody.Access})
+}
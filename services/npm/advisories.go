@@ -0,0 +1,300 @@
+package npm
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/gin-gonic/gin"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	osvEcosystemNpm = "npm"
+	// maxAuditRequestSize limits the decompressed "npm audit" requests
+	maxAuditRequestSize = 32 << 20
+)
+
+// osvEntry is the part of the OSV schema (https://ossf.github.io/osv-schema/) stored by pkgstore
+type osvEntry struct {
+	Id        string    `json:"id"`
+	Aliases   []string  `json:"aliases"`
+	Summary   string    `json:"summary"`
+	Details   string    `json:"details"`
+	Published time.Time `json:"published"`
+	Modified  time.Time `json:"modified"`
+	Withdrawn string    `json:"withdrawn"`
+	Affected  []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Ranges []struct {
+			Type   string              `json:"type"`
+			Events []map[string]string `json:"events"`
+		} `json:"ranges"`
+		Versions []string `json:"versions"`
+	} `json:"affected"`
+	References []struct {
+		Type string `json:"type"`
+		Url  string `json:"url"`
+	} `json:"references"`
+	DatabaseSpecific struct {
+		Severity string   `json:"severity"`
+		CweIds   []string `json:"cwe_ids"`
+	} `json:"database_specific"`
+}
+
+// BulkAdvisory is an advisory in the format of the npm bulk advisory endpoint
+type BulkAdvisory struct {
+	Id                 uint32   `json:"id"`
+	Url                string   `json:"url"`
+	Title              string   `json:"title"`
+	Severity           string   `json:"severity"`
+	VulnerableVersions string   `json:"vulnerable_versions"`
+	Cwe                []string `json:"cwe"`
+}
+
+// ImportOsvAdvisories imports the npm entries of an OSV dump, which is a directory or a zip of JSON files
+// (like the npm/all.zip export of osv.dev) or a single JSON file. The other ecosystems are skipped.
+func ImportOsvAdvisories(path string) (imported int, err error) {
+	importFile := func(name string, open func() (io.ReadCloser, error)) error {
+		if !strings.EqualFold(filepath.Ext(name), ".json") {
+			return nil
+		}
+		file, err := open()
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		isNpm, err := importOsvEntry(file)
+		if err != nil {
+			log.Println("Skipping the invalid OSV entry: ", name, err)
+		} else if isNpm {
+			imported++
+		}
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case info.IsDir():
+		err = filepath.WalkDir(path, func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			return importFile(filePath, func() (io.ReadCloser, error) { return os.Open(filePath) })
+		})
+	case strings.EqualFold(filepath.Ext(path), ".zip"):
+		archive, zipErr := zip.OpenReader(path)
+		if zipErr != nil {
+			return 0, zipErr
+		}
+		defer archive.Close()
+		for _, file := range archive.File {
+			if err = importFile(file.Name, file.Open); err != nil {
+				break
+			}
+		}
+	default:
+		err = importFile(path, func() (io.ReadCloser, error) { return os.Open(path) })
+	}
+	return imported, err
+}
+
+// importOsvEntry replaces the advisories of the entry, reporting whether it affects npm packages
+func importOsvEntry(r io.Reader) (bool, error) {
+	entry := osvEntry{}
+	if err := json.NewDecoder(r).Decode(&entry); err != nil {
+		return false, err
+	}
+	if len(entry.Id) == 0 {
+		return false, fmt.Errorf("missing id")
+	}
+
+	advisories := make([]models.Advisory, 0)
+	for _, affected := range entry.Affected {
+		if affected.Package.Ecosystem != osvEcosystemNpm || len(affected.Package.Name) == 0 {
+			continue
+		}
+		advisory := models.Advisory{
+			Service:     "npm",
+			PackageName: strings.TrimPrefix(affected.Package.Name, "@"),
+			OsvId:       entry.Id,
+			Aliases:     entry.Aliases,
+			Summary:     entry.Summary,
+			Details:     entry.Details,
+			Severity:    osvSeverity(entry),
+			Url:         osvUrl(entry),
+			Cwe:         entry.DatabaseSpecific.CweIds,
+			Ranges:      make([]models.AdvisoryRange, 0),
+			Versions:    affected.Versions,
+			PublishedAt: entry.Published,
+			ModifiedAt:  entry.Modified,
+		}
+		for _, affectedRange := range affected.Ranges {
+			if affectedRange.Type == "SEMVER" || affectedRange.Type == "ECOSYSTEM" {
+				advisory.Ranges = append(advisory.Ranges, osvRanges(affectedRange.Events)...)
+			}
+		}
+		advisory.VulnerableVersions = vulnerableVersions(advisory.Ranges, advisory.Versions)
+		advisories = append(advisories, advisory)
+	}
+	if len(advisories) == 0 {
+		return false, nil
+	}
+
+	// A withdrawn entry removes the advisories imported before
+	if len(entry.Withdrawn) > 0 {
+		advisories = nil
+	}
+	return true, models.ReplaceAdvisories(entry.Id, advisories)
+}
+
+// osvRanges turns the OSV events into intervals, an "introduced" event opens one and "fixed" or "last_affected" closes it
+func osvRanges(events []map[string]string) []models.AdvisoryRange {
+	ranges := make([]models.AdvisoryRange, 0)
+	var current *models.AdvisoryRange
+	for _, event := range events {
+		if introduced, ok := event["introduced"]; ok {
+			if current != nil {
+				ranges = append(ranges, *current)
+			}
+			current = &models.AdvisoryRange{Introduced: introduced}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if fixed, ok := event["fixed"]; ok {
+			current.Fixed = fixed
+		} else if lastAffected, ok := event["last_affected"]; ok {
+			current.LastAffected = lastAffected
+		} else {
+			continue
+		}
+		ranges = append(ranges, *current)
+		current = nil
+	}
+	if current != nil {
+		ranges = append(ranges, *current)
+	}
+	return ranges
+}
+
+// vulnerableVersions writes the intervals as an npm range, e.g. ">=1.0.0 <1.2.5 || 2.0.0"
+func vulnerableVersions(ranges []models.AdvisoryRange, versions []string) string {
+	alternatives := make([]string, 0, len(ranges)+len(versions))
+	for _, affectedRange := range ranges {
+		comparators := make([]string, 0, 2)
+		if affectedRange.Introduced != "" && affectedRange.Introduced != "0" {
+			comparators = append(comparators, ">="+affectedRange.Introduced)
+		}
+		if affectedRange.Fixed != "" {
+			comparators = append(comparators, "<"+affectedRange.Fixed)
+		} else if affectedRange.LastAffected != "" {
+			comparators = append(comparators, "<="+affectedRange.LastAffected)
+		}
+		if len(comparators) == 0 {
+			comparators = append(comparators, "*")
+		}
+		alternatives = append(alternatives, strings.Join(comparators, " "))
+	}
+	alternatives = append(alternatives, versions...)
+	return strings.Join(alternatives, " || ")
+}
+
+// osvSeverity uses the GitHub severity levels of the entry, which are the ones npm shows,
+// the entries without one (or with another scale) are reported as moderate
+func osvSeverity(entry osvEntry) string {
+	switch severity := strings.ToLower(entry.DatabaseSpecific.Severity); severity {
+	case "low", "moderate", "high", "critical":
+		return severity
+	}
+	return "moderate"
+}
+
+func osvUrl(entry osvEntry) string {
+	for _, referenceType := range []string{"ADVISORY", "WEB"} {
+		for _, reference := range entry.References {
+			if reference.Type == referenceType {
+				return reference.Url
+			}
+		}
+	}
+	return "https://osv.dev/vulnerability/" + entry.Id
+}
+
+// bulkAdvisoryId is a stable number for the OSV id, npm uses the ids to deduplicate the advisories
+func bulkAdvisoryId(osvId string) uint32 {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(osvId))
+	return hash.Sum32()
+}
+
+// AdvisoriesBulkHandler POST /-/npm/v1/security/advisories/bulk answers "npm audit" with the imported advisories
+// that affect the requested versions, npm sends the package versions as a gzip JSON map
+func (s *Service) AdvisoriesBulkHandler(c *gin.Context) {
+	body := io.Reader(c.Request.Body)
+	if strings.EqualFold(c.GetHeader("Content-Encoding"), "gzip") {
+		gzipReader, err := gzip.NewReader(c.Request.Body)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid gzip body"})
+			return
+		}
+		defer gzipReader.Close()
+		body = gzipReader
+	}
+
+	requestBody := make(map[string][]string)
+	if err := json.NewDecoder(io.LimitReader(body, maxAuditRequestSize)).Decode(&requestBody); err != nil {
+		c.JSON(400, gin.H{"error": "Bad Request"})
+		return
+	}
+
+	// The advisories are stored with the package names, which don't have the "@" of the scope
+	requestedNames := make(map[string]string, len(requestBody))
+	pkgNames := make([]string, 0, len(requestBody))
+	for name := range requestBody {
+		pkgName, _ := s.SplitPkgName(name)
+		requestedNames[pkgName] = name
+		pkgNames = append(pkgNames, pkgName)
+	}
+	advisories, err := models.ListAdvisories(s.Prefix, pkgNames)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Error while trying to get the advisories"})
+		return
+	}
+
+	result := make(map[string][]BulkAdvisory)
+	for i := range advisories {
+		advisory := &advisories[i]
+		name := requestedNames[advisory.PackageName]
+		for _, version := range requestBody[name] {
+			if !advisory.Affects(version) {
+				continue
+			}
+			result[name] = append(result[name], BulkAdvisory{
+				Id:                 bulkAdvisoryId(advisory.OsvId),
+				Url:                advisory.Url,
+				Title:              advisory.Summary,
+				Severity:           advisory.Severity,
+				VulnerableVersions: advisory.VulnerableVersions,
+				Cwe:                advisory.Cwe,
+			})
+			break
+		}
+	}
+	c.JSON(200, result)
+}
@@ -0,0 +1,98 @@
+package npm
+
+import (
+	"github.com/gin-gonic/gin"
+	"log"
+	"regexp"
+	"strings"
+)
+
+const DistTagLatest = "latest"
+
+// distTagRangeRegex matches the tags npm would read as a version range, like "1", "v1.2" or "^1.0.0"
+var distTagRangeRegex = regexp.MustCompile(`^[v=<>~^\s]*\d`)
+
+// DistTagsHandler GET /-/package/:name/dist-tags lists the tags for "npm dist-tag ls"
+func (s *Service) DistTagsHandler(c *gin.Context) {
+	pkg := s.findAccessPackage(c)
+	if pkg == nil {
+		return
+	}
+
+	distTags, err := pkg.DistTags()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Error while trying to get the package dist-tags"})
+		return
+	}
+	c.JSON(200, distTags)
+}
+
+// SetDistTagHandler PUT /-/package/:name/dist-tags/:tag points the tag to the version sent as a JSON string by "npm dist-tag add"
+func (s *Service) SetDistTagHandler(c *gin.Context) {
+	tag := c.Param("tag")
+	if len(strings.TrimSpace(tag)) == 0 || distTagRangeRegex.MatchString(tag) {
+		c.JSON(400, gin.H{"error": "Tag name must not be a valid version range"})
+		return
+	}
+
+	version := ""
+	err := c.ShouldBindJSON(&version)
+	if err != nil || len(version) == 0 {
+		c.JSON(400, gin.H{"error": "Version is required"})
+		return
+	}
+
+	pkg := s.findAccessPackage(c)
+	if pkg == nil {
+		return
+	}
+
+	pkgVersion, err := pkg.Version(version)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Error while trying to get package info"})
+		return
+	}
+	if len(pkgVersion.Digest) == 0 {
+		c.JSON(404, gin.H{"error": "Package version not found"})
+		return
+	}
+
+	err = pkg.SetDistTag(tag, version)
+	if err != nil {
+		log.Println("Unable to set the package dist-tag: ", err)
+		c.JSON(500, gin.H{"error": "Error while trying to set the package dist-tag"})
+		return
+	}
+	c.JSON(201, gin.H{"ok": true, "id": pkg.Name, "dist-tags": gin.H{tag: version}})
+}
+
+// DeleteDistTagHandler DELETE /-/package/:name/dist-tags/:tag removes the tag for "npm dist-tag rm"
+func (s *Service) DeleteDistTagHandler(c *gin.Context) {
+	tag := c.Param("tag")
+	if tag == DistTagLatest {
+		c.JSON(400, gin.H{"error": "The latest tag can't be removed"})
+		return
+	}
+
+	pkg := s.findAccessPackage(c)
+	if pkg == nil {
+		return
+	}
+
+	distTags, err := pkg.DistTags()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Error while trying to get the package dist-tags"})
+		return
+	}
+	if _, ok := distTags[tag]; !ok {
+		c.JSON(404, gin.H{"error": "Tag not found"})
+		return
+	}
+
+	err = pkg.DeleteDistTag(tag)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Error while trying to delete the package dist-tag"})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true})
+}
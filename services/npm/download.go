@@ -1,6 +1,7 @@
 package npm
 
 import (
+	"github.com/alin-io/pkgstore/config"
 	"github.com/alin-io/pkgstore/middlewares"
 	"github.com/alin-io/pkgstore/models"
 	"github.com/gin-gonic/gin"
@@ -14,7 +15,7 @@ func (s *Service) DownloadHandler(c *gin.Context) {
 	pkgName, _ := s.ConstructFullPkgName(c)
 	namespace := middlewares.GetAuthCtx(c).Namespace
 
-	_, version := s.PkgVersionFromFilename(filename)
+	version := s.tarballVersion(pkgName, filename)
 	pkg := models.Package[PackageMetadata]{
 		Namespace: namespace,
 		Service:   s.Prefix,
@@ -30,6 +31,13 @@ func (s *Service) DownloadHandler(c *gin.Context) {
 	}
 
 	if pkg.ID == uuid.Nil {
+		if config.Get().Npm.ProxyMode == config.NpmProxyCache {
+			if !middlewares.CheckProxyAllowed(c, s, pkgName) {
+				return
+			}
+			s.upstreamTarball(c, pkgName, version, filename)
+			return
+		}
 		c.JSON(404, gin.H{"error": "Not Found"})
 		return
 	}
@@ -56,8 +64,10 @@ func (s *Service) DownloadHandler(c *gin.Context) {
 		return
 	}
 
-	fileAsset := fileAssets[0]
+	s.serveTarball(c, fileAssets[0], filename)
+}
 
+func (s *Service) serveTarball(c *gin.Context, fileAsset models.Asset, filename string) {
 	if fileAsset.ID == uuid.Nil {
 		c.JSON(404, gin.H{"error": "Not Found"})
 		return
@@ -0,0 +1,197 @@
+package npm
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/middlewares"
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"html/template"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	couchUserPrefix = "org.couchdb.user:"
+	loginSessionTTL = 10 * time.Minute
+)
+
+// loginSessions keeps the pending web logins of "npm login", under "login:<id>" for the browser
+// and "done:<id>" for the CLI polling, so the page URL can't be used to fetch the token
+var loginSessions = expirable.NewLRU[string, *loginSession](1000, nil, loginSessionTTL)
+
+type loginSession struct {
+	mu    sync.Mutex
+	token string
+}
+
+type couchUserRequestBody struct {
+	Name     string `json:"name" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+var loginPageTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>pkgstore npm login</title></head>
+<body>
+{{if .Done}}<p>Logged in as {{.Username}}, return to the terminal to continue.</p>{{else}}
+<form method="post">
+<h1>npm login</h1>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<p><label>Username <input name="username" value="{{.Username}}" autofocus></label></p>
+<p><label>Password <input name="password" type="password"></label></p>
+<p><button type="submit">Log in</button></p>
+</form>{{end}}
+</body>
+</html>`))
+
+type loginPage struct {
+	Done     bool
+	Username string
+	Error    string
+}
+
+// authenticateLogin verifies the credentials with the auth provider and issues the session token,
+// the users themselves are managed by the provider, so npm can't create accounts
+func (s *Service) authenticateLogin(c *gin.Context, username, password string) (string, error) {
+	identity := &middlewares.AuthResult{AuthId: middlewares.AuthIdPublic, PublicAccess: true, Read: true, Write: true, Delete: true}
+	if authProvider := middlewares.ActiveAuthProvider(); authProvider != nil {
+		if len(username) == 0 || len(password) == 0 {
+			return "", errors.New("username and password are required")
+		}
+		var err error
+		identity, err = authProvider.Authenticate(c, "", username+":"+password, s.Prefix, middlewares.PkgActionPull)
+		if err != nil {
+			return "", err
+		}
+	}
+	return middlewares.SignSessionToken(identity, s.Prefix)
+}
+
+// AddUserHandler PUT /-/user/org.couchdb.user:<name> is the legacy login of "npm login --auth-type=legacy" and "npm adduser"
+func (s *Service) AddUserHandler(c *gin.Context) {
+	requestBody := couchUserRequestBody{}
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Username and password are required"})
+		return
+	}
+	if strings.TrimPrefix(c.Param("user"), couchUserPrefix) != requestBody.Name {
+		c.JSON(400, gin.H{"error": "Username doesn't match the request path"})
+		return
+	}
+
+	token, err := s.authenticateLogin(c, requestBody.Name, requestBody.Password)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Invalid username or password"})
+		return
+	}
+	c.JSON(201, gin.H{"ok": true, "id": couchUserPrefix + requestBody.Name, "token": token})
+}
+
+func newLoginSessionId() (string, error) {
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// WebLoginHandler POST /-/v1/login starts the web login of "npm login", npm opens the login URL and polls the done URL
+func (s *Service) WebLoginHandler(c *gin.Context) {
+	loginId, err := newLoginSessionId()
+	doneId := ""
+	if err == nil {
+		doneId, err = newLoginSessionId()
+	}
+	if err != nil {
+		log.Println("Unable to start the login session: ", err)
+		c.JSON(500, gin.H{"error": "Unable to start the login"})
+		return
+	}
+
+	session := &loginSession{}
+	loginSessions.Add("login:"+loginId, session)
+	loginSessions.Add("done:"+doneId, session)
+
+	registryHost := strings.TrimSuffix(config.Get().RegistryHosts.Npm, "/")
+	c.JSON(200, gin.H{
+		"loginUrl": registryHost + "/-/v1/login/" + loginId,
+		"doneUrl":  registryHost + "/-/v1/done/" + doneId,
+	})
+}
+
+func renderLoginPage(c *gin.Context, status int, page loginPage) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+	if err := loginPageTemplate.Execute(c.Writer, page); err != nil {
+		log.Println("Unable to render the login page: ", err)
+	}
+}
+
+// LoginPageHandler GET /-/v1/login/:sessionId is the page opened in the browser by "npm login"
+func (s *Service) LoginPageHandler(c *gin.Context) {
+	if _, ok := loginSessions.Get("login:" + c.Param("sessionId")); !ok {
+		c.JSON(404, gin.H{"error": "Login session not found or expired"})
+		return
+	}
+	renderLoginPage(c, 200, loginPage{})
+}
+
+// LoginSubmitHandler POST /-/v1/login/:sessionId verifies the credentials of the login page
+func (s *Service) LoginSubmitHandler(c *gin.Context) {
+	loginKey := "login:" + c.Param("sessionId")
+	session, ok := loginSessions.Get(loginKey)
+	if !ok {
+		c.JSON(404, gin.H{"error": "Login session not found or expired"})
+		return
+	}
+
+	username := c.PostForm("username")
+	token, err := s.authenticateLogin(c, username, c.PostForm("password"))
+	if err != nil {
+		renderLoginPage(c, 401, loginPage{Username: username, Error: "Invalid username or password"})
+		return
+	}
+
+	session.mu.Lock()
+	session.token = token
+	session.mu.Unlock()
+	loginSessions.Remove(loginKey)
+	renderLoginPage(c, 200, loginPage{Done: true, Username: username})
+}
+
+// LoginDoneHandler GET /-/v1/done/:sessionId is polled by npm until the login page is submitted
+func (s *Service) LoginDoneHandler(c *gin.Context) {
+	doneKey := "done:" + c.Param("sessionId")
+	session, ok := loginSessions.Get(doneKey)
+	if !ok {
+		c.JSON(404, gin.H{"error": "Login session not found or expired"})
+		return
+	}
+
+	session.mu.Lock()
+	token := session.token
+	session.mu.Unlock()
+	if len(token) == 0 {
+		c.Header("Retry-After", "5")
+		c.JSON(202, gin.H{})
+		return
+	}
+	loginSessions.Remove(doneKey)
+	c.JSON(200, gin.H{"token": token})
+}
+
+// WhoamiHandler GET /-/whoami answers "npm whoami" with the auth id of the token
+func (s *Service) WhoamiHandler(c *gin.Context) {
+	c.JSON(200, gin.H{"username": middlewares.GetAuthCtx(c).AuthId})
+}
+
+// PingHandler GET /-/ping answers "npm ping"
+func (s *Service) PingHandler(c *gin.Context) {
+	c.JSON(200, gin.H{})
+}
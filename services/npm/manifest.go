@@ -0,0 +1,250 @@
+package npm
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"github.com/alin-io/pkgstore/db"
+	"github.com/alin-io/pkgstore/models"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"io"
+	"log"
+	"path"
+	"strings"
+)
+
+// maxPackageJsonSize limits the package.json read from a tarball by the manifest migration
+const maxPackageJsonSize = 1 << 20
+
+// PackageMetadata is the version document published by npm. Fields keeps the complete document as published,
+// the typed fields are the ones read by pkgstore, and Id, Name, Version, Dist and Deprecated are controlled by the registry.
+type PackageMetadata struct {
+	Id          string            `json:"_id"`
+	Name        string            `json:"name"`
+	Version     string            `json:"version"`
+	Description string            `json:"description"`
+	Readme      string            `json:"readme"`
+	Keywords    []string          `json:"keywords"`
+	Scripts     map[string]string `json:"scripts"`
+	Dist        PackageDist       `json:"dist"`
+	Deprecated  string            `json:"deprecated,omitempty"`
+
+	Fields map[string]json.RawMessage `json:"-"`
+}
+
+// PackageDist keeps the extra dist fields too, like the signatures, fileCount and unpackedSize
+type PackageDist struct {
+	Integrity string `json:"integrity"`
+	Shasum    string `json:"shasum"`
+	Tarball   string `json:"tarball"`
+
+	Fields map[string]json.RawMessage `json:"-"`
+}
+
+type (
+	packageMetadataFields PackageMetadata
+	packageDistFields     PackageDist
+)
+
+// legacyManifestKeys are the keys stored by the typed manifest of the earlier versions,
+// which always wrote all of them and dropped everything else
+var legacyManifestKeys = map[string]bool{
+	"_id": true, "name": true, "version": true, "description": true, "readme": true, "_nodeVersion": true, "_npmVersion": true,
+	"author": true, "dist": true, "publishConfig": true, "scripts": true, "keywords": true, "license": true, "main": true,
+}
+
+// decodeField fills the typed field when the value has the expected type, the raw value is kept either way
+func decodeField(fields map[string]json.RawMessage, key string, target any) {
+	if raw, ok := fields[key]; ok {
+		_ = json.Unmarshal(raw, target)
+	}
+}
+
+// encodeFields writes the raw document with the registry values over it, the empty values keep the published ones
+func encodeFields(fields map[string]json.RawMessage, overlay map[string]any) ([]byte, error) {
+	document := make(map[string]any, len(fields)+len(overlay))
+	for key, value := range fields {
+		document[key] = value
+	}
+	for key, value := range overlay {
+		if value != "" {
+			document[key] = value
+		}
+	}
+	return json.Marshal(document)
+}
+
+func (m *PackageMetadata) UnmarshalJSON(data []byte) error {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*m = PackageMetadata{Fields: fields}
+	decodeField(fields, "_id", &m.Id)
+	decodeField(fields, "name", &m.Name)
+	decodeField(fields, "version", &m.Version)
+	decodeField(fields, "description", &m.Description)
+	decodeField(fields, "readme", &m.Readme)
+	decodeField(fields, "keywords", &m.Keywords)
+	decodeField(fields, "scripts", &m.Scripts)
+	decodeField(fields, "dist", &m.Dist)
+	decodeField(fields, "deprecated", &m.Deprecated)
+	return nil
+}
+
+func (m PackageMetadata) MarshalJSON() ([]byte, error) {
+	if m.Fields == nil {
+		return json.Marshal(packageMetadataFields(m))
+	}
+	overlay := map[string]any{"_id": m.Id, "name": m.Name, "version": m.Version}
+	if m.Dist.Fields != nil || len(m.Dist.Tarball) > 0 {
+		overlay["dist"] = m.Dist
+	}
+	if len(m.Deprecated) > 0 {
+		overlay["deprecated"] = m.Deprecated
+	}
+	fields := m.Fields
+	if _, ok := fields["deprecated"]; ok && len(m.Deprecated) == 0 {
+		fields = make(map[string]json.RawMessage, len(m.Fields))
+		for key, value := range m.Fields {
+			if key != "deprecated" {
+				fields[key] = value
+			}
+		}
+	}
+	return encodeFields(fields, overlay)
+}
+
+func (d *PackageDist) UnmarshalJSON(data []byte) error {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*d = PackageDist{Fields: fields}
+	decodeField(fields, "integrity", &d.Integrity)
+	decodeField(fields, "shasum", &d.Shasum)
+	decodeField(fields, "tarball", &d.Tarball)
+	return nil
+}
+
+func (d PackageDist) MarshalJSON() ([]byte, error) {
+	if d.Fields == nil {
+		return json.Marshal(packageDistFields(d))
+	}
+	return encodeFields(d.Fields, map[string]any{"integrity": d.Integrity, "shasum": d.Shasum, "tarball": d.Tarball})
+}
+
+// isLegacyManifest reports the versions stored by the typed manifest, the only ones that lost fields
+func isLegacyManifest(metadata PackageMetadata) bool {
+	legacyKeys := 0
+	for key := range metadata.Fields {
+		if legacyManifestKeys[key] {
+			legacyKeys++
+		} else if key != "deprecated" {
+			return false
+		}
+	}
+	return legacyKeys == len(legacyManifestKeys)
+}
+
+// MigrateManifests restores the fields dropped by the earlier versions of pkgstore from the package.json of the stored tarballs,
+// it runs once with "pkgstore npm migrate-manifests" and returns the number of migrated versions. It is best effort:
+// the published values are never overwritten, the versions updated meanwhile are left to the registry,
+// and the versions that fail are logged and left as they are.
+func (s *Service) MigrateManifests() (int, error) {
+	migrated := 0
+	versions := make([]models.PackageVersion[PackageMetadata], 0)
+	err := db.DB().Where("service = ?", s.Prefix).FindInBatches(&versions, 100, func(_ *gorm.DB, _ int) error {
+		for _, version := range versions {
+			metadata := version.Metadata.Data()
+			if !isLegacyManifest(metadata) {
+				continue
+			}
+			saved, err := s.migrateManifest(version, metadata)
+			if err != nil {
+				log.Println("Unable to migrate the npm version manifest: ", version.ID, err)
+			} else if saved {
+				migrated++
+			}
+		}
+		return nil
+	}).Error
+	return migrated, err
+}
+
+func (s *Service) migrateManifest(version models.PackageVersion[PackageMetadata], metadata PackageMetadata) (bool, error) {
+	packageJson, err := s.readPackageJson(version)
+	if err != nil {
+		return false, err
+	}
+
+	// The nulls are the empty fields of the typed manifest, so a migrated version isn't picked up again
+	fields := make(map[string]json.RawMessage, len(metadata.Fields)+len(packageJson))
+	changed := false
+	for key, value := range metadata.Fields {
+		if string(value) != "null" {
+			fields[key] = value
+		} else {
+			changed = true
+		}
+	}
+	for key, value := range packageJson {
+		if strings.HasPrefix(key, "_") || key == "dist" || key == "readme" {
+			continue
+		}
+		if _, ok := fields[key]; !ok {
+			fields[key] = value
+			changed = true
+		}
+	}
+	if !changed {
+		// The package.json has nothing more than the stored manifest
+		return false, nil
+	}
+	metadata.Fields = fields
+	version.Metadata = datatypes.NewJSONType[PackageMetadata](metadata)
+	// A deprecation or an unpublish since the version was loaded would be overwritten, it stays legacy instead
+	return version.SaveMetaIfUnchanged()
+}
+
+// readPackageJson extracts the package.json from the top directory of the version tarball
+func (s *Service) readPackageJson(version models.PackageVersion[PackageMetadata]) (map[string]json.RawMessage, error) {
+	assets, err := version.GetAssets()
+	if err != nil {
+		return nil, err
+	}
+	if len(assets) == 0 {
+		return nil, errors.New("the version has no tarball")
+	}
+	fileData, err := s.Storage.GetFile(s.PackageFilename(assets[0].Digest))
+	if err != nil {
+		return nil, err
+	}
+	defer fileData.Close()
+
+	gzipReader, err := gzip.NewReader(fileData)
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil, errors.New("package.json not found in the tarball")
+			}
+			return nil, err
+		}
+		name := strings.Trim(path.Clean(header.Name), "/")
+		if path.Base(name) != "package.json" || strings.Count(name, "/") != 1 {
+			continue
+		}
+		packageJson := make(map[string]json.RawMessage)
+		err = json.NewDecoder(io.LimitReader(tarReader, maxPackageJsonSize)).Decode(&packageJson)
+		return packageJson, err
+	}
+}
@@ -1,16 +1,83 @@
 package npm
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
 	"github.com/alin-io/pkgstore/middlewares"
 	"github.com/alin-io/pkgstore/models"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AbbreviatedMetadataContentType is the "corgi" document that npm asks for on install
+const AbbreviatedMetadataContentType = "application/vnd.npm.install-v1+json"
+
+const metadataTimeFormat = "2006-01-02T15:04:05.000Z"
+
+var (
+	metadataCache     *expirable.LRU[string, *metadataDocument]
+	metadataCacheOnce sync.Once
 )
 
 type MetadataResponse struct {
+	Id       string                     `json:"_id,omitempty"`
+	Rev      string                     `json:"_rev,omitempty"`
 	Name     string                     `json:"name"`
 	DistTags map[string]string          `json:"dist-tags"`
 	Versions map[string]PackageMetadata `json:"versions"`
+	Time     map[string]string          `json:"time,omitempty"`
+}
+
+// AbbreviatedMetadataResponse only keeps what npm needs to resolve and install the versions
+type AbbreviatedMetadataResponse struct {
+	Name     string                        `json:"name"`
+	Modified string                        `json:"modified"`
+	DistTags map[string]string             `json:"dist-tags"`
+	Versions map[string]AbbreviatedVersion `json:"versions"`
+}
+
+// AbbreviatedVersion keeps the published install fields as they are
+type AbbreviatedVersion struct {
+	Name                 string          `json:"name"`
+	Version              string          `json:"version"`
+	Dist                 PackageDist     `json:"dist"`
+	Deprecated           string          `json:"deprecated,omitempty"`
+	HasInstallScript     bool            `json:"hasInstallScript,omitempty"`
+	HasShrinkwrap        json.RawMessage `json:"_hasShrinkwrap,omitempty"`
+	Dependencies         json.RawMessage `json:"dependencies,omitempty"`
+	OptionalDependencies json.RawMessage `json:"optionalDependencies,omitempty"`
+	DevDependencies      json.RawMessage `json:"devDependencies,omitempty"`
+	BundleDependencies   json.RawMessage `json:"bundleDependencies,omitempty"`
+	PeerDependencies     json.RawMessage `json:"peerDependencies,omitempty"`
+	PeerDependenciesMeta json.RawMessage `json:"peerDependenciesMeta,omitempty"`
+	Bin                  json.RawMessage `json:"bin,omitempty"`
+	Directories          json.RawMessage `json:"directories,omitempty"`
+	Engines              json.RawMessage `json:"engines,omitempty"`
+	Os                   json.RawMessage `json:"os,omitempty"`
+	Cpu                  json.RawMessage `json:"cpu,omitempty"`
+}
+
+// metadataDocument is a rendered metadata response, cached by the package modification time,
+// so any publish, tag or unpublish changes the key instead of invalidating the entries on every replica
+type metadataDocument struct {
+	body        []byte
+	etag        string
+	contentType string
+}
+
+func getMetadataCache() *expirable.LRU[string, *metadataDocument] {
+	metadataCacheOnce.Do(func() {
+		metadataCache = expirable.NewLRU[string, *metadataDocument](config.Get().Npm.MetadataCacheSize, nil, time.Hour)
+	})
+	return metadataCache
 }
 
 func (s *Service) MetadataHandler(c *gin.Context) {
@@ -26,29 +93,145 @@ func (s *Service) MetadataHandler(c *gin.Context) {
 		return
 	}
 
-	err = pkg.FillVersions()
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Error while trying to get package info"})
-		return
+	abbreviated := strings.Contains(c.GetHeader("Accept"), AbbreviatedMetadataContentType)
+	registryUrl := s.registryUrl(c)
+	cacheKey := fmt.Sprintf("%s:%t:%d:%s", pkg.ID, abbreviated, pkg.UpdatedAt.UnixNano(), registryUrl)
+	document, isCached := getMetadataCache().Get(cacheKey)
+	if !isCached {
+		err = pkg.FillVersions()
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Error while trying to get package info"})
+			return
+		}
+
+		if pkg.ID == uuid.Nil || len(pkg.Versions) == 0 {
+			isCacheMode := config.Get().Npm.ProxyMode == config.NpmProxyCache
+			if !isCacheMode && c.GetBool("testing") {
+				c.JSON(404, gin.H{"error": "Package not found"})
+			} else if !middlewares.CheckProxyAllowed(c, s, pkgName) {
+				return
+			} else if isCacheMode {
+				s.upstreamMetadata(c, pkgName, abbreviated, registryUrl)
+			} else {
+				s.ProxyToPublicRegistry(c)
+			}
+			return
+		}
+
+		document, err = s.renderMetadata(&pkg, abbreviated, registryUrl)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Error while trying to get package info"})
+			return
+		}
+		getMetadataCache().Add(cacheKey, document)
 	}
 
-	if !c.GetBool("testing") && (pkg.ID == uuid.Nil || len(pkg.Versions) == 0) {
-		s.ProxyToPublicRegistry(c)
+	serveMetadataDocument(c, document, pkg.UpdatedAt)
+}
+
+func serveMetadataDocument(c *gin.Context, document *metadataDocument, modified time.Time) {
+	c.Header("ETag", document.etag)
+	c.Header("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	c.Header("Vary", "Accept")
+	if metadataNotModified(c, document.etag, modified) {
+		c.Status(304)
 		return
 	}
+	c.Data(200, document.contentType, document.body)
+}
 
-	if pkg.ID == uuid.Nil || len(pkg.Versions) == 0 {
-		c.JSON(404, gin.H{"error": "Package not found"})
-		return
+// renderMetadata serves the stored manifests with the tarball URLs of this registry,
+// whatever URL the publisher sent
+func (s *Service) renderMetadata(pkg *models.Package[PackageMetadata], abbreviated bool, registryUrl string) (*metadataDocument, error) {
+	distTags, err := pkg.DistTags()
+	if err != nil {
+		return nil, err
 	}
 
-	result := MetadataResponse{Name: pkgName, DistTags: make(map[string]string), Versions: make(map[string]PackageMetadata)}
+	var result any
+	contentType := "application/json"
+	if abbreviated {
+		contentType = AbbreviatedMetadataContentType
+		abbreviatedResult := AbbreviatedMetadataResponse{
+			Name:     pkg.Name,
+			Modified: pkg.UpdatedAt.UTC().Format(metadataTimeFormat),
+			DistTags: distTags,
+			Versions: make(map[string]AbbreviatedVersion),
+		}
+		for _, version := range pkg.Versions {
+			metadata := version.Metadata.Data()
+			metadata.Dist.Tarball = tarballUrl(registryUrl, pkg.Name, version.Version)
+			abbreviatedResult.Versions[version.Version] = AbbreviatedVersion{
+				Name:                 metadata.Name,
+				Version:              version.Version,
+				Dist:                 metadata.Dist,
+				Deprecated:           metadata.Deprecated,
+				HasInstallScript:     len(metadata.Scripts["preinstall"]+metadata.Scripts["install"]+metadata.Scripts["postinstall"]) > 0,
+				HasShrinkwrap:        metadata.Fields["_hasShrinkwrap"],
+				Dependencies:         metadata.Fields["dependencies"],
+				OptionalDependencies: metadata.Fields["optionalDependencies"],
+				DevDependencies:      metadata.Fields["devDependencies"],
+				BundleDependencies:   metadata.Fields["bundleDependencies"],
+				PeerDependencies:     metadata.Fields["peerDependencies"],
+				PeerDependenciesMeta: metadata.Fields["peerDependenciesMeta"],
+				Bin:                  metadata.Fields["bin"],
+				Directories:          metadata.Fields["directories"],
+				Engines:              metadata.Fields["engines"],
+				Os:                   metadata.Fields["os"],
+				Cpu:                  metadata.Fields["cpu"],
+			}
+		}
+		result = abbreviatedResult
+	} else {
+		fullResult := MetadataResponse{
+			Id:       pkg.Name,
+			Rev:      packumentRev(pkg),
+			Name:     pkg.Name,
+			DistTags: distTags,
+			Versions: make(map[string]PackageMetadata),
+			Time: map[string]string{
+				"created":  pkg.CreatedAt.UTC().Format(metadataTimeFormat),
+				"modified": pkg.UpdatedAt.UTC().Format(metadataTimeFormat),
+			},
+		}
+		for _, version := range pkg.Versions {
+			metadata := version.Metadata.Data()
+			metadata.Dist.Tarball = tarballUrl(registryUrl, pkg.Name, version.Version)
+			fullResult.Versions[version.Version] = metadata
+			fullResult.Time[version.Version] = version.CreatedAt.UTC().Format(metadataTimeFormat)
+		}
+		result = fullResult
+	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return newMetadataDocument(body, contentType), nil
+}
 
-	for _, version := range pkg.Versions {
-		result.Versions[version.Version] = version.Metadata.Data()
-		if len(version.Tag) > 0 {
-			result.DistTags[version.Tag] = version.Version
+func newMetadataDocument(body []byte, contentType string) *metadataDocument {
+	checksum := sha256.Sum256(body)
+	return &metadataDocument{
+		body:        body,
+		etag:        `"` + hex.EncodeToString(checksum[:16]) + `"`,
+		contentType: contentType,
+	}
+}
+
+// metadataNotModified checks If-None-Match, or If-Modified-Since when there is no ETag to compare
+func metadataNotModified(c *gin.Context, etag string, modified time.Time) bool {
+	if ifNoneMatch := c.GetHeader("If-None-Match"); len(ifNoneMatch) > 0 {
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
 		}
+		return false
+	}
+	if ifModifiedSince, err := http.ParseTime(c.GetHeader("If-Modified-Since")); err == nil {
+		return !modified.Truncate(time.Second).After(ifModifiedSince)
 	}
-	c.JSON(200, result)
+	return false
 }
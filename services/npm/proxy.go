@@ -0,0 +1,300 @@
+package npm
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// maxUpstreamDocumentSize limits the metadata documents read from the public registry
+	maxUpstreamDocumentSize = 128 << 20
+	// maxUpstreamTarballSize limits the tarballs downloaded from the public registry
+	maxUpstreamTarballSize = 1 << 30
+)
+
+var (
+	upstreamClient        = &http.Client{Timeout: 30 * time.Second}
+	upstreamTarballClient = &http.Client{Timeout: 10 * time.Minute}
+
+	errUpstreamNotFound    = errors.New("package not found in the upstream registry")
+	errUpstreamUnavailable = errors.New("upstream registry unavailable")
+)
+
+// upstreamDocument returns the cached metadata while it's fresh and revalidates it once it expired,
+// the expired copy is kept serving while the public registry fails
+func (s *Service) upstreamDocument(pkgName string, abbreviated bool) (*models.UpstreamDocument, error) {
+	document := &models.UpstreamDocument{Service: s.Prefix}
+	err := document.FillByName(pkgName, abbreviated)
+	if err != nil {
+		return nil, err
+	}
+	isCached := document.ID != uuid.Nil
+	if isCached && time.Since(document.FetchedAt) < config.Get().Npm.ProxyMetadataTTL {
+		return document, nil
+	}
+
+	// The scoped names are escaped like the npm client does
+	req, err := http.NewRequest("GET", config.Get().Npm.UpstreamUrl+"/"+strings.Replace(npmPackageName(pkgName), "/", "%2f", 1), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if abbreviated {
+		req.Header.Set("Accept", AbbreviatedMetadataContentType)
+	}
+	if isCached && len(document.ETag) > 0 {
+		req.Header.Set("If-None-Match", document.ETag)
+	}
+
+	response, err := upstreamClient.Do(req)
+	if err == nil {
+		defer response.Body.Close()
+		switch {
+		case response.StatusCode == 304 && isCached:
+			return document, document.Refresh()
+		case response.StatusCode == 200:
+			body, readErr := io.ReadAll(io.LimitReader(response.Body, maxUpstreamDocumentSize))
+			if readErr == nil && json.Valid(body) {
+				document.Name = pkgName
+				document.Abbreviated = abbreviated
+				document.Body = body
+				document.ETag = response.Header.Get("ETag")
+				document.FetchedAt = time.Now()
+				return document, document.Save()
+			}
+			err = errors.New("invalid metadata document")
+		case response.StatusCode == 404:
+			return nil, errUpstreamNotFound
+		default:
+			err = fmt.Errorf("status %d", response.StatusCode)
+		}
+	}
+
+	log.Println("Unable to fetch the package metadata from the upstream registry: ", pkgName, err)
+	if isCached {
+		return document, nil
+	}
+	return nil, errUpstreamUnavailable
+}
+
+// upstreamMetadata serves the cached metadata of a public package, with the tarball URLs pointing to pkgstore
+func (s *Service) upstreamMetadata(c *gin.Context, pkgName string, abbreviated bool, registryUrl string) {
+	upstream, err := s.upstreamDocument(pkgName, abbreviated)
+	if !s.checkUpstreamError(c, err) {
+		return
+	}
+
+	cacheKey := fmt.Sprintf("upstream:%s:%d:%s", upstream.ID, upstream.UpdatedAt.UnixNano(), registryUrl)
+	document, isCached := getMetadataCache().Get(cacheKey)
+	if !isCached {
+		body, err := rewriteUpstreamTarballs(upstream.Body, registryUrl, pkgName)
+		if err != nil {
+			log.Println("Unable to rewrite the upstream package metadata: ", pkgName, err)
+			c.JSON(502, gin.H{"error": "Invalid metadata from the upstream registry"})
+			return
+		}
+		contentType := "application/json"
+		if abbreviated {
+			contentType = AbbreviatedMetadataContentType
+		}
+		document = newMetadataDocument(body, contentType)
+		getMetadataCache().Add(cacheKey, document)
+	}
+	serveMetadataDocument(c, document, upstream.UpdatedAt)
+}
+
+func (s *Service) checkUpstreamError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errUpstreamNotFound):
+		c.JSON(404, gin.H{"error": "Package not found"})
+	case errors.Is(err, errUpstreamUnavailable):
+		c.JSON(502, gin.H{"error": "Unable to reach the upstream registry"})
+	default:
+		log.Println("Unable to get the upstream package: ", err)
+		c.JSON(500, gin.H{"error": "Error while trying to get package info"})
+	}
+	return false
+}
+
+// rewriteUpstreamTarballs points the tarball URLs of every version to pkgstore, the rest of the document is kept as it is
+func rewriteUpstreamTarballs(body []byte, registryUrl, pkgName string) ([]byte, error) {
+	document := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, err
+	}
+	versions := make(map[string]map[string]json.RawMessage)
+	if rawVersions, ok := document["versions"]; ok {
+		if err := json.Unmarshal(rawVersions, &versions); err != nil {
+			return nil, err
+		}
+	}
+	for version, manifest := range versions {
+		dist := make(map[string]json.RawMessage)
+		if rawDist, ok := manifest["dist"]; ok {
+			if err := json.Unmarshal(rawDist, &dist); err != nil {
+				return nil, err
+			}
+		}
+		dist["tarball"], _ = json.Marshal(tarballUrl(registryUrl, pkgName, version))
+		manifest["dist"], _ = json.Marshal(dist)
+	}
+	document["versions"], _ = json.Marshal(versions)
+	return json.Marshal(document)
+}
+
+// upstreamTarball serves the tarball of a public package, it is stored as an asset on the first download
+// and served from the storage afterward, whatever the state of the public registry
+func (s *Service) upstreamTarball(c *gin.Context, pkgName, version, filename string) {
+	cachedTarball := models.UpstreamTarball{Service: s.Prefix}
+	err := cachedTarball.FillByVersion(pkgName, version)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Error while trying to get package info"})
+		return
+	}
+	if cachedTarball.ID != uuid.Nil {
+		asset := models.Asset{Service: s.Prefix}
+		if err = asset.FillById(cachedTarball.AssetId.String()); err != nil {
+			c.JSON(500, gin.H{"error": "Error while trying to get package info"})
+			return
+		}
+		s.serveTarball(c, asset, filename)
+		return
+	}
+
+	upstream, err := s.upstreamDocument(pkgName, true)
+	if !s.checkUpstreamError(c, err) {
+		return
+	}
+	dist, ok := upstreamVersionDist(upstream.Body, version)
+	if !ok {
+		c.JSON(404, gin.H{"error": "Not Found"})
+		return
+	}
+
+	asset, err := s.storeUpstreamTarball(dist)
+	if err != nil {
+		log.Println("Unable to fetch the package tarball from the upstream registry: ", pkgName, version, err)
+		c.JSON(502, gin.H{"error": "Unable to fetch the package from the upstream registry"})
+		return
+	}
+	cachedTarball = models.UpstreamTarball{Service: s.Prefix, Name: pkgName, Version: version, AssetId: asset.ID}
+	if err = cachedTarball.Insert(); err != nil {
+		log.Println("Unable to record the upstream tarball: ", pkgName, version, err)
+	}
+	s.serveTarball(c, *asset, filename)
+}
+
+func upstreamVersionDist(body []byte, version string) (PackageDist, bool) {
+	document := struct {
+		Versions map[string]struct {
+			Dist PackageDist `json:"dist"`
+		} `json:"versions"`
+	}{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return PackageDist{}, false
+	}
+	manifest, ok := document.Versions[version]
+	return manifest.Dist, ok && len(manifest.Dist.Tarball) > 0
+}
+
+// storeUpstreamTarball downloads the tarball through a temporary file, and only stores it when it matches
+// the integrity of the metadata document
+func (s *Service) storeUpstreamTarball(dist PackageDist) (*models.Asset, error) {
+	response, err := upstreamTarballClient.Get(dist.Tarball)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("status %d", response.StatusCode)
+	}
+
+	tmpFile, err := os.CreateTemp("", "pkgstore-npm-*.tgz")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+	}()
+
+	sha256Hash, sha512Hash, sha1Hash := sha256.New(), sha512.New(), sha1.New()
+	size, err := io.Copy(io.MultiWriter(tmpFile, sha256Hash, sha512Hash, sha1Hash), io.LimitReader(response.Body, maxUpstreamTarballSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if size > maxUpstreamTarballSize {
+		return nil, fmt.Errorf("tarball is larger than %d bytes", maxUpstreamTarballSize)
+	}
+	if err = verifyTarballIntegrity(dist, sha512Hash.Sum(nil), sha1Hash.Sum(nil)); err != nil {
+		return nil, err
+	}
+	if _, err = tmpFile.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	checksum := hex.EncodeToString(sha256Hash.Sum(nil))
+	if err = s.Storage.WriteFile(s.PackageFilename(checksum), nil, tmpFile); err != nil {
+		return nil, err
+	}
+
+	asset := models.Asset{Service: s.Prefix}
+	if err = asset.FillByDigest(checksum); err != nil {
+		return nil, err
+	}
+	if asset.ID == uuid.Nil {
+		asset = models.Asset{
+			Size:        size,
+			Service:     s.Prefix,
+			Digest:      checksum,
+			UploadUUID:  uuid.NewString(),
+			UploadRange: fmt.Sprintf("0-%d", size),
+		}
+		if err = asset.Insert(); err != nil {
+			return nil, err
+		}
+	}
+	return &asset, nil
+}
+
+// verifyTarballIntegrity checks the sha512 of the integrity field, or the legacy sha1 shasum of the older packages.
+// The tarballs without either are refused, they can't be told apart from a tampered download.
+func verifyTarballIntegrity(dist PackageDist, sha512Sum, sha1Sum []byte) error {
+	hasSha512 := false
+	for _, integrity := range strings.Fields(dist.Integrity) {
+		if expected, ok := strings.CutPrefix(integrity, "sha512-"); ok {
+			hasSha512 = true
+			if expected == base64.StdEncoding.EncodeToString(sha512Sum) {
+				return nil
+			}
+		}
+	}
+	if hasSha512 {
+		return errors.New("integrity mismatch")
+	}
+	if len(dist.Shasum) == 0 {
+		return errors.New("the metadata has no sha512 integrity or shasum to verify the tarball")
+	}
+	if !strings.EqualFold(dist.Shasum, hex.EncodeToString(sha1Sum)) {
+		return errors.New("shasum mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package npm
+
+import (
+	"encoding/json"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/middlewares"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	searchDefaultSize = 20
+	searchMaxSize     = 250
+)
+
+// searchUpstreamClient queries the public registry search, which is only merged in on a best effort basis
+var searchUpstreamClient = &http.Client{Timeout: 5 * time.Second}
+
+type SearchResponse struct {
+	Objects []SearchObject `json:"objects"`
+	Total   int            `json:"total"`
+	Time    string         `json:"time"`
+}
+
+type SearchObject struct {
+	Package     SearchPackage `json:"package"`
+	Score       SearchScore   `json:"score"`
+	SearchScore float64       `json:"searchScore"`
+}
+
+type SearchPackage struct {
+	Name        string            `json:"name"`
+	Scope       string            `json:"scope"`
+	Version     string            `json:"version"`
+	Description string            `json:"description"`
+	Keywords    []string          `json:"keywords,omitempty"`
+	Date        time.Time         `json:"date"`
+	Links       map[string]string `json:"links"`
+	Publisher   map[string]string `json:"publisher,omitempty"`
+}
+
+type SearchScore struct {
+	Final  float64            `json:"final"`
+	Detail map[string]float64 `json:"detail"`
+}
+
+// SearchHandler GET /-/v1/search serves "npm search" over the packages visible to the caller,
+// the results of the public registry follow the private ones when NPM_SEARCH_UPSTREAM is enabled
+func (s *Service) SearchHandler(c *gin.Context) {
+	text := strings.TrimSpace(c.Query("text"))
+	size, err := strconv.Atoi(c.DefaultQuery("size", strconv.Itoa(searchDefaultSize)))
+	if err != nil || size < 1 || size > searchMaxSize {
+		c.JSON(400, gin.H{"error": "size must be between 1 and " + strconv.Itoa(searchMaxSize)})
+		return
+	}
+	from, err := strconv.Atoi(c.DefaultQuery("from", "0"))
+	if err != nil || from < 0 {
+		c.JSON(400, gin.H{"error": "from must be a positive number"})
+		return
+	}
+
+	authCtx := middlewares.GetAuthCtx(c)
+	terms := strings.Fields(strings.ToLower(text))
+	pkgs, err := models.SearchVisiblePackages[PackageMetadata](s.Prefix, authCtx.Namespace, authCtx.AuthId, terms)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Error while trying to search the packages"})
+		return
+	}
+
+	objects := make([]SearchObject, 0)
+	for i := range pkgs {
+		if object, ok := s.searchObject(&pkgs[i], terms); ok {
+			objects = append(objects, object)
+		}
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		if objects[i].SearchScore != objects[j].SearchScore {
+			return objects[i].SearchScore > objects[j].SearchScore
+		}
+		return objects[i].Package.Name < objects[j].Package.Name
+	})
+
+	// The scores are normalized to the best match, like the final score of the public registry
+	if len(objects) > 0 && objects[0].SearchScore > 0 {
+		maxScore := objects[0].SearchScore
+		for i := range objects {
+			objects[i].Score.Final = objects[i].SearchScore / maxScore
+		}
+	}
+
+	result := SearchResponse{Objects: make([]SearchObject, 0), Total: len(objects), Time: time.Now().UTC().Format(time.RFC1123)}
+	if from < len(objects) {
+		result.Objects = objects[from:min(from+size, len(objects))]
+	}
+
+	if config.Get().Npm.SearchUpstream && len(text) > 0 {
+		s.mergeUpstreamSearch(&result, objects, text, max(from-len(objects), 0), size-len(result.Objects))
+	}
+	c.JSON(200, result)
+}
+
+// searchObject scores the latest version of the package, the only one loaded by the search. Every term has
+// to match the name, a keyword or the description, and the name matches weigh the most
+func (s *Service) searchObject(pkg *models.Package[PackageMetadata], terms []string) (SearchObject, bool) {
+	if len(pkg.Versions) == 0 {
+		return SearchObject{}, false
+	}
+	latest := pkg.Versions[0]
+	metadata := latest.Metadata.Data()
+
+	// The names are matched with and without the scope or the namespace
+	name := strings.ToLower(pkg.Name)
+	baseName := name[strings.LastIndex(name, "/")+1:]
+	description := strings.ToLower(metadata.Description)
+	score := 0.0
+	for _, term := range terms {
+		termScore := 0.0
+		switch {
+		case name == term || baseName == term:
+			termScore = 10
+		case strings.HasPrefix(name, term) || strings.HasPrefix(baseName, term):
+			termScore = 6
+		case strings.Contains(name, term):
+			termScore = 4
+		}
+		for _, keyword := range metadata.Keywords {
+			if strings.ToLower(keyword) == term {
+				termScore += 3
+				break
+			}
+		}
+		if strings.Contains(description, term) {
+			termScore += 1
+		}
+		if termScore == 0 {
+			return SearchObject{}, false
+		}
+		score += termScore
+	}
+
+	scope := "unscoped"
+	if strings.HasPrefix(pkg.Name, "@") {
+		scope, _, _ = strings.Cut(pkg.Name[1:], "/")
+	}
+	return SearchObject{
+		Package: SearchPackage{
+			Name:        pkg.Name,
+			Scope:       scope,
+			Version:     latest.Version,
+			Description: metadata.Description,
+			Keywords:    metadata.Keywords,
+			Date:        latest.CreatedAt,
+			Links:       map[string]string{},
+			Publisher:   map[string]string{"username": latest.AuthId},
+		},
+		Score: SearchScore{
+			Final:  1,
+			Detail: map[string]float64{"quality": 1, "popularity": 1, "maintenance": 1},
+		},
+		SearchScore: score,
+	}, true
+}
+
+// mergeUpstreamSearch fills the rest of the page with the public registry results, skipping the names
+// that exist privately or are reserved, so a private package is never shadowed and the installs of the
+// listed packages aren't blocked
+func (s *Service) mergeUpstreamSearch(result *SearchResponse, localObjects []SearchObject, text string, from, size int) {
+	query := url.Values{"text": {text}, "from": {strconv.Itoa(from)}, "size": {strconv.Itoa(max(size, 1))}}
+	response, err := searchUpstreamClient.Get(config.Get().Npm.UpstreamUrl + "/-/v1/search?" + query.Encode())
+	if err != nil {
+		log.Println("Unable to search the public registry: ", err)
+		return
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		log.Println("Unable to search the public registry, status: ", response.StatusCode)
+		return
+	}
+
+	upstream := SearchResponse{}
+	if err = json.NewDecoder(response.Body).Decode(&upstream); err != nil {
+		log.Println("Unable to decode the public registry search: ", err)
+		return
+	}
+
+	localNames := make(map[string]bool, len(localObjects))
+	for _, object := range localObjects {
+		localNames[object.Package.Name] = true
+	}
+	result.Total += upstream.Total
+	for _, object := range upstream.Objects {
+		if size <= 0 {
+			break
+		}
+		if localNames[object.Package.Name] {
+			continue
+		}
+		if rule, _, err := middlewares.ReservedNameRule(s.Prefix, object.Package.Name); err != nil || len(rule) > 0 {
+			continue
+		}
+		result.Objects = append(result.Objects, object)
+		size--
+	}
+}
@@ -0,0 +1,76 @@
+package npm
+
+import (
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/gin-gonic/gin"
+	"net/url"
+	"strings"
+)
+
+// registryUrl is the base of the tarball URLs, REGISTRY_HOST_NPM by default. With NPM_TRUST_FORWARDED_HOST
+// it is the address the client used, as reported by the proxy in front of pkgstore.
+func (s *Service) registryUrl(c *gin.Context) string {
+	registryHost := strings.TrimSuffix(config.Get().RegistryHosts.Npm, "/")
+	if !config.Get().Npm.TrustForwardedHost {
+		return registryHost
+	}
+
+	host := forwardedValue(c, "X-Forwarded-Host")
+	if len(host) == 0 {
+		host = c.Request.Host
+	}
+	// The host ends up in the cached documents, so anything that isn't a plain host[:port] is ignored
+	if parsedHost, err := url.Parse("//" + host); err != nil || len(host) == 0 || parsedHost.Host != host || parsedHost.User != nil {
+		return registryHost
+	}
+
+	scheme := strings.ToLower(forwardedValue(c, "X-Forwarded-Proto"))
+	if scheme != "http" && scheme != "https" {
+		scheme = "http"
+		if c.Request.TLS != nil {
+			scheme = "https"
+		}
+	}
+
+	prefix := strings.TrimSuffix(forwardedValue(c, "X-Forwarded-Prefix"), "/")
+	if len(prefix) > 0 && (!strings.HasPrefix(prefix, "/") || strings.ContainsAny(prefix, "?# ")) {
+		prefix = ""
+	}
+	return scheme + "://" + host + prefix + "/" + s.Prefix
+}
+
+// forwardedValue is the first value of the header, the one added by the proxy closest to the client
+func forwardedValue(c *gin.Context, header string) string {
+	value, _, _ := strings.Cut(c.GetHeader(header), ",")
+	return strings.TrimSpace(value)
+}
+
+// tarballFilename follows the public registry, the tarball of a scoped package is named without the scope
+func tarballFilename(pkgName, version string) string {
+	return fmt.Sprintf("%s-%s.tgz", pkgName[strings.LastIndex(pkgName, "/")+1:], version)
+}
+
+// npmPackageName puts the "@" of the scope back, the stored names don't have it
+func npmPackageName(pkgName string) string {
+	if strings.Contains(pkgName, "/") && !strings.HasPrefix(pkgName, "@") {
+		return "@" + pkgName
+	}
+	return pkgName
+}
+
+func tarballUrl(registryUrl, pkgName, version string) string {
+	return registryUrl + "/" + npmPackageName(pkgName) + "/-/" + tarballFilename(pkgName, version)
+}
+
+// tarballVersion reads the version from the tarball filename, the versions can have dashes in the prerelease part
+func (s *Service) tarballVersion(pkgName, filename string) string {
+	baseName := pkgName[strings.LastIndex(pkgName, "/")+1:]
+	for _, ext := range []string{".tgz", ".tar.gz"} {
+		if version, ok := strings.CutPrefix(strings.TrimSuffix(filename, ext), baseName+"-"); ok && strings.HasSuffix(filename, ext) {
+			return version
+		}
+	}
+	_, version := s.PkgVersionFromFilename(filename)
+	return version
+}
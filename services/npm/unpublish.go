@@ -0,0 +1,202 @@
+package npm
+
+import (
+	"fmt"
+	"github.com/alin-io/pkgstore/config"
+	"github.com/alin-io/pkgstore/middlewares"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/datatypes"
+	"log"
+	"time"
+)
+
+// packumentRev is the CouchDB style revision that npm sends back in the -rev requests,
+// it changes with the versions of the package, but pkgstore doesn't lock on it
+func packumentRev(pkg *models.Package[PackageMetadata]) string {
+	return fmt.Sprintf("%d-%x", len(pkg.Versions), pkg.UpdatedAt.UnixNano())
+}
+
+// UpdatePackumentHandler PUT /:name/-rev/:rev is the packument rewrite sent by "npm unpublish <pkg>@<version>"
+func (s *Service) UpdatePackumentHandler(c *gin.Context) {
+	requestBody := npmUploadRequestBody{}
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Bad Request"})
+		return
+	}
+	s.rewritePackument(c, &requestBody)
+}
+
+// rewritePackument applies a packument without attachments, the versions missing from it are unpublished
+// and the deprecation messages set by "npm deprecate" are stored with the versions
+func (s *Service) rewritePackument(c *gin.Context, requestBody *npmUploadRequestBody) {
+	pkg := s.findAccessPackage(c)
+	if pkg == nil {
+		return
+	}
+	err := pkg.FillVersions()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Error while trying to get package info"})
+		return
+	}
+
+	removedVersions := make([]models.PackageVersion[PackageMetadata], 0)
+	for _, version := range pkg.Versions {
+		if _, ok := requestBody.Versions[version.Version]; !ok {
+			removedVersions = append(removedVersions, version)
+		}
+	}
+	if len(removedVersions) > 0 && !s.checkUnpublish(c, removedVersions) {
+		return
+	}
+
+	for _, version := range pkg.Versions {
+		versionInfo, ok := requestBody.Versions[version.Version]
+		metadata := version.Metadata.Data()
+		if !ok || metadata.Deprecated == versionInfo.Deprecated {
+			continue
+		}
+		metadata.Deprecated = versionInfo.Deprecated
+		version.Metadata = datatypes.NewJSONType[PackageMetadata](metadata)
+		if err = version.SaveMeta(); err != nil {
+			log.Println("Unable to deprecate the package version: ", err)
+			c.JSON(500, gin.H{"error": "Error while trying to deprecate the package version"})
+			return
+		}
+	}
+
+	if len(removedVersions) > 0 {
+		err = s.unpublishVersions(pkg, removedVersions, requestBody.DistTags[DistTagLatest])
+		if err != nil {
+			log.Println("Unable to unpublish the package versions: ", err)
+			c.JSON(500, gin.H{"error": "Error while trying to unpublish the package versions"})
+			return
+		}
+	}
+	c.JSON(200, gin.H{"ok": true, "id": pkg.Name, "rev": packumentRev(pkg)})
+}
+
+// UnpublishPackageHandler DELETE /:name/-rev/:rev removes the whole package for "npm unpublish <pkg> --force"
+func (s *Service) UnpublishPackageHandler(c *gin.Context) {
+	pkg := s.findAccessPackage(c)
+	if pkg == nil {
+		return
+	}
+	err := pkg.FillVersions()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Error while trying to get package info"})
+		return
+	}
+	if !s.checkUnpublish(c, pkg.Versions) {
+		return
+	}
+
+	err = pkg.Delete()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Error while trying to unpublish the package"})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true})
+}
+
+// UnpublishTarballHandler DELETE /:name/-/:filename/-rev/:rev is sent by npm after the packument rewrite,
+// the version is usually gone already
+func (s *Service) UnpublishTarballHandler(c *gin.Context) {
+	pkg := s.findAccessPackage(c)
+	if pkg == nil {
+		return
+	}
+	err := pkg.FillVersions()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Error while trying to get package info"})
+		return
+	}
+
+	version := s.tarballVersion(pkg.Name, c.Param("filename"))
+	for _, pkgVersion := range pkg.Versions {
+		if pkgVersion.Version != version {
+			continue
+		}
+		removedVersions := []models.PackageVersion[PackageMetadata]{pkgVersion}
+		if !s.checkUnpublish(c, removedVersions) {
+			return
+		}
+		if err = s.unpublishVersions(pkg, removedVersions, ""); err != nil {
+			log.Println("Unable to unpublish the package version: ", err)
+			c.JSON(500, gin.H{"error": "Error while trying to unpublish the package version"})
+			return
+		}
+		break
+	}
+	c.JSON(200, gin.H{"ok": true})
+}
+
+// checkUnpublish verifies the delete permission of the caller and NPM_UNPUBLISH_WINDOW,
+// the packument rewrite is a PUT, so the access middleware only checked the push permission
+func (s *Service) checkUnpublish(c *gin.Context, versions []models.PackageVersion[PackageMetadata]) bool {
+	if middlewares.ActiveAuthProvider() != nil {
+		pkgName, namespace := s.ConstructFullPkgName(c)
+		_, status, message := middlewares.CheckPkgAccess(s.Prefix, middlewares.GetAuthCtx(c), pkgName, namespace, middlewares.PkgActionDelete)
+		if status != 0 {
+			c.JSON(status, gin.H{"error": message})
+			return false
+		}
+	}
+
+	unpublishWindow := config.Get().Npm.UnpublishWindow
+	for _, version := range versions {
+		if unpublishWindow > 0 && time.Since(version.CreatedAt) > unpublishWindow {
+			c.JSON(403, gin.H{"error": fmt.Sprintf("Version %s was published more than %s ago and can't be unpublished, deprecate it instead", version.Version, unpublishWindow)})
+			return false
+		}
+	}
+	return true
+}
+
+// unpublishVersions deletes the versions with their tags, the package goes away with its last version.
+// When "latest" pointed to a removed version, it moves to the requested version or the newest remaining one.
+func (s *Service) unpublishVersions(pkg *models.Package[PackageMetadata], versions []models.PackageVersion[PackageMetadata], latestVersion string) error {
+	if len(versions) == len(pkg.Versions) {
+		return pkg.Delete()
+	}
+
+	removed := make(map[string]bool, len(versions))
+	for _, version := range versions {
+		if err := version.Delete(); err != nil {
+			return err
+		}
+		removed[version.Version] = true
+	}
+
+	remainingVersions := make([]models.PackageVersion[PackageMetadata], 0, len(pkg.Versions)-len(versions))
+	for _, version := range pkg.Versions {
+		if !removed[version.Version] {
+			remainingVersions = append(remainingVersions, version)
+		}
+	}
+	pkg.Versions = remainingVersions
+
+	distTags, err := pkg.DistTags()
+	if err != nil {
+		return err
+	}
+	if _, ok := distTags[DistTagLatest]; ok {
+		return nil
+	}
+
+	var newest *models.PackageVersion[PackageMetadata]
+	for i, version := range remainingVersions {
+		if version.Version == latestVersion {
+			newest = &remainingVersions[i]
+			break
+		}
+		if newest == nil || version.CreatedAt.After(newest.CreatedAt) {
+			newest = &remainingVersions[i]
+		}
+	}
+	if err = pkg.SetDistTag(DistTagLatest, newest.Version); err != nil {
+		return err
+	}
+	return pkg.SetLatestVersion(newest.Version)
+}
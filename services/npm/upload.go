@@ -34,6 +34,18 @@ func (s *Service) UploadHandler(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "Bad Request"})
 		return
 	}
+	// The access is checked for the package of the path, the body can't publish another one
+	urlPkgName, _ := s.ConstructFullPkgName(c)
+	if bodyPkgName, _ := s.SplitPkgName(requestBody.Name); bodyPkgName != urlPkgName {
+		c.JSON(400, gin.H{"error": "The package name doesn't match the URL"})
+		return
+	}
+
+	// "npm deprecate" sends the packument back without attachments
+	if len(requestBody.Attachments) == 0 {
+		s.rewritePackument(c, &requestBody)
+		return
+	}
 
 	decodedBytes := make([]byte, 0)
 	for _, attachment := range requestBody.Attachments {
@@ -51,28 +63,39 @@ func (s *Service) UploadHandler(c *gin.Context) {
 		return
 	}
 
-	currentVersion := ""
+	var versionInfo PackageMetadata
+	for _, versionInfo = range requestBody.Versions {
+		break
+	}
+	if len(versionInfo.Version) == 0 {
+		c.JSON(400, gin.H{"error": "Package version is required"})
+		return
+	}
+	versionInfo.Id = requestBody.Name + "@" + versionInfo.Version
+
 	var pkgVersion models.PackageVersion[PackageMetadata]
 
 	pkg := models.Package[PackageMetadata]{
 		Namespace: authCtx.Namespace,
 		Service:   s.Prefix,
 	}
-	err = pkg.FillByName(requestBody.Name)
+	// The packages are stored without the "@" of the scope, like the names of the request paths
+	pkgName, _ := s.SplitPkgName(requestBody.Name)
+	err = pkg.FillByName(pkgName)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Unable to check the DB for package"})
 		return
 	}
 
 	if pkg.ID != uuid.Nil {
-		pkgVersion, err = pkg.Version(currentVersion)
+		pkgVersion, err = pkg.Version(versionInfo.Version)
 		if err != nil {
 			c.JSON(500, gin.H{"error": "Unable to check the DB for package version"})
 			return
 		}
 	} else {
 		pkg = models.Package[PackageMetadata]{
-			Name:      requestBody.Name,
+			Name:      pkgName,
 			Service:   s.Prefix,
 			AuthId:    authCtx.AuthId,
 			Namespace: authCtx.Namespace,
@@ -81,13 +104,7 @@ func (s *Service) UploadHandler(c *gin.Context) {
 
 	if pkgVersion.ID != uuid.Nil && len(pkgVersion.Digest) > 0 {
 		if pkgVersion.Digest == checksum {
-			c.JSON(200, MetadataResponse{
-				Name:     pkg.Name,
-				DistTags: requestBody.DistTags,
-				Versions: map[string]PackageMetadata{
-					pkgVersion.Version: pkgVersion.Metadata.Data(),
-				},
-			})
+			s.uploadResponse(c, &pkg, pkgVersion)
 			return
 		} else {
 			c.JSON(400, gin.H{"error": "Wrong checksum for the existing Package Version"})
@@ -95,25 +112,15 @@ func (s *Service) UploadHandler(c *gin.Context) {
 		}
 	}
 
-	for _, versionInfo := range requestBody.Versions {
-		currentVersion = versionInfo.Version
-
-		pkgVersion = models.PackageVersion[PackageMetadata]{
-			Version:   currentVersion,
-			Digest:    checksum,
-			Service:   s.Prefix,
-			AuthId:    authCtx.AuthId,
-			Namespace: authCtx.Namespace,
-			Metadata:  datatypes.NewJSONType[PackageMetadata](versionInfo),
-		}
-
-		for tagName, tagVersion := range requestBody.DistTags {
-			if tagVersion == versionInfo.Version {
-				pkgVersion.Tag = tagName
-				break
-			}
-		}
-		break
+	// The dist-tags are kept in the tags table, the version tag is the version itself like the pypi versions
+	pkgVersion = models.PackageVersion[PackageMetadata]{
+		Version:   versionInfo.Version,
+		Tag:       versionInfo.Version,
+		Digest:    checksum,
+		Service:   s.Prefix,
+		AuthId:    authCtx.AuthId,
+		Namespace: authCtx.Namespace,
+		Metadata:  datatypes.NewJSONType[PackageMetadata](versionInfo),
 	}
 
 	err = s.Storage.WriteFile(s.PackageFilename(checksum), nil, bytes.NewReader(decodedBytes))
@@ -155,7 +162,7 @@ func (s *Service) UploadHandler(c *gin.Context) {
 	if pkg.ID == uuid.Nil {
 		pkg.Versions = []models.PackageVersion[PackageMetadata]{pkgVersion}
 		err = pkg.Insert()
-	} else if len(pkgVersion.Digest) == 0 {
+	} else {
 		err = pkg.InsertVersion(pkgVersion)
 	}
 
@@ -168,11 +175,51 @@ func (s *Service) UploadHandler(c *gin.Context) {
 		c.JSON(500, gin.H{"error": "Unable to Upload Package"})
 		return
 	}
+
+	err = s.applyPublishedDistTags(&pkg, pkgVersion.Version, requestBody.DistTags)
+	if err != nil {
+		log.Println("Unable to set the package dist-tags: ", err)
+		c.JSON(500, gin.H{"error": "Unable to set the package dist-tags"})
+		return
+	}
+	s.uploadResponse(c, &pkg, pkgVersion)
+}
+
+// applyPublishedDistTags points the dist-tags of the publish request to the published version,
+// "npm publish --tag" doesn't send "latest", so the first version of the package becomes the latest
+func (s *Service) applyPublishedDistTags(pkg *models.Package[PackageMetadata], version string, distTags map[string]string) error {
+	for tagName, tagVersion := range distTags {
+		if tagVersion != version {
+			continue
+		}
+		if err := pkg.SetDistTag(tagName, version); err != nil {
+			return err
+		}
+	}
+
+	currentTags, err := pkg.DistTags()
+	if err != nil {
+		return err
+	}
+	if _, ok := currentTags[DistTagLatest]; !ok {
+		return pkg.SetDistTag(DistTagLatest, version)
+	}
+	return nil
+}
+
+func (s *Service) uploadResponse(c *gin.Context, pkg *models.Package[PackageMetadata], pkgVersion models.PackageVersion[PackageMetadata]) {
+	distTags, err := pkg.DistTags()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Error while trying to get package info"})
+		return
+	}
+	metadata := pkgVersion.Metadata.Data()
+	metadata.Dist.Tarball = tarballUrl(s.registryUrl(c), pkg.Name, pkgVersion.Version)
 	c.JSON(200, MetadataResponse{
 		Name:     pkg.Name,
-		DistTags: requestBody.DistTags,
+		DistTags: distTags,
 		Versions: map[string]PackageMetadata{
-			pkgVersion.Version: pkgVersion.Metadata.Data(),
+			pkgVersion.Version: metadata,
 		},
 	})
 }
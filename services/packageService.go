@@ -77,6 +77,11 @@ func (s *BasePackageService) ConstructFullPkgName(c *gin.Context) (string, strin
 			pkgName = fmt.Sprintf("%s/%s", pkgName, pkgParam)
 		}
 	}
+	return s.SplitPkgName(pkgName)
+}
+
+// SplitPkgName normalizes the package name and extracts the namespace part of it
+func (s *BasePackageService) SplitPkgName(pkgName string) (string, string) {
 	if len(pkgName) == 0 {
 		return "", ""
 	}
@@ -135,6 +140,8 @@ func (s *BasePackageService) AbortRequestWithError(c *gin.Context, status int, m
 	statusText := fmt.Sprintf("%d", status)
 	if status == 401 {
 		statusText = "UNAUTHORIZED"
+	} else if status == 429 {
+		statusText = "TOOMANYREQUESTS"
 	}
 	c.JSON(status, gin.H{
 		"errors": []gin.H{
@@ -12,7 +12,8 @@ import (
 func (s *Service) DownloadHandler(c *gin.Context) {
 	filename := c.Param("filename")
 	digest := c.Param("sha256")
-	pkgName, version := s.PkgVersionFromFilename(filename)
+	_, version := s.PkgVersionFromFilename(filename)
+	pkgName, _ := s.ConstructFullPkgName(c)
 	authCtx := middlewares.GetAuthCtx(c)
 	pkg := models.Package[PackageMetadata]{
 		Namespace: authCtx.Namespace,
@@ -7,6 +7,7 @@ import (
 	"github.com/alin-io/pkgstore/models"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"net/url"
 )
 
 func (s *Service) MetadataHandler(c *gin.Context) {
@@ -29,20 +30,29 @@ func (s *Service) MetadataHandler(c *gin.Context) {
 	}
 
 	if !c.GetBool("testing") && (pkg.ID == uuid.Nil || len(pkg.Versions) == 0) {
-		s.ProxyToPublicRegistry(c)
+		if middlewares.CheckProxyAllowed(c, s, pkgName) {
+			s.ProxyToPublicRegistry(c)
+		}
 		return
 	}
 
+	// The namespace of the package isn't part of the file names
+	filesQuery := ""
+	if len(pkg.Namespace) > 0 {
+		filesQuery = "?namespace=" + url.QueryEscape(pkg.Namespace)
+	}
+
 	versionLinks := ""
 	for _, versionData := range pkg.Versions {
 		for _, originalFilename := range versionData.Metadata.Data().OriginalFiles {
 			versionLinks = fmt.Sprintf(
-				`%[1]s<a href="%[2]s/files/%[3]s/%[4]s#sha256=%[3]s" data-requires-python="%[5]s">%[4]s</a></br>`,
+				`%[1]s<a href="%[2]s/files/%[3]s/%[4]s%[6]s#sha256=%[3]s" data-requires-python="%[5]s">%[4]s</a></br>`,
 				versionLinks,
 				config.Get().RegistryHosts.Pypi,
 				versionData.Digest,
 				originalFilename,
 				versionData.Metadata.Data().RequiresPython,
+				filesQuery,
 			)
 		}
 	}
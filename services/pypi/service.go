@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/alin-io/pkgstore/services"
 	"github.com/alin-io/pkgstore/storage"
+	"github.com/gin-gonic/gin"
 )
 
 type PackageMetadata struct {
@@ -26,6 +27,24 @@ func NewService(storage storage.BaseStorageBackend) *Service {
 	}
 }
 
+// ConstructFullPkgName reads the package name from the upload form, because twine doesn't send it in the URL
+func (s *Service) ConstructFullPkgName(c *gin.Context) (string, string) {
+	// File names don't carry the namespace, the download links add it as a query parameter
+	if filename := c.Param("filename"); len(filename) > 0 {
+		pkgName, _ := s.PkgVersionFromFilename(filename)
+		if namespace := c.Query("namespace"); len(namespace) > 0 {
+			pkgName = namespace + "/" + pkgName
+		}
+		return s.SplitPkgName(pkgName)
+	}
+
+	pkgName, namespace := s.BasePackageService.ConstructFullPkgName(c)
+	if len(pkgName) == 0 && c.Request.Method == "POST" {
+		return s.SplitPkgName(c.PostForm("name"))
+	}
+	return pkgName, namespace
+}
+
 func (s *Service) constructPackageOriginalFilename(name, version, postfix string) string {
 	if len(postfix) > 0 {
 		postfix = "-" + postfix
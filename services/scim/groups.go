@@ -0,0 +1,280 @@
+package scim
+
+import (
+	"encoding/json"
+	"github.com/alin-io/pkgstore/middlewares"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// memberPathRegex matches the `members[value eq "id"]` path of the member removals
+var memberPathRegex = regexp.MustCompile(`(?i)^members\[\s*value\s+eq\s+"([^"]+)"\s*]$`)
+
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	Id          string   `json:"id,omitempty"`
+	ExternalId  string   `json:"externalId,omitempty"`
+	DisplayName string   `json:"displayName" binding:"required"`
+	Members     []Member `json:"members"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+func groupResource(group *models.Group) *Group {
+	resource := &Group{
+		Schemas:     []string{GroupSchema},
+		Id:          group.ID.String(),
+		ExternalId:  group.ExternalId,
+		DisplayName: group.DisplayName,
+		Members:     make([]Member, 0, len(group.Members)),
+		Meta: &Meta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt,
+			LastModified: group.UpdatedAt,
+			Location:     "/scim/v2/Groups/" + group.ID.String(),
+		},
+	}
+	for _, member := range group.Members {
+		resource.Members = append(resource.Members, Member{Value: member.ID.String(), Display: member.Name})
+	}
+	return resource
+}
+
+func memberIds(members []Member) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(members))
+	for _, member := range members {
+		if id, err := uuid.Parse(member.Value); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func userIds(users []models.User) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
+
+func (s *Service) findGroup(c *gin.Context) (*models.Group, bool) {
+	groupId, ok := parseResourceId(c)
+	if !ok {
+		return nil, false
+	}
+	group := &models.Group{}
+	err := group.FillById(groupId)
+	if err != nil {
+		middlewares.AbortScimRequest(c, 500, err.Error())
+		return nil, false
+	}
+	if group.ID == uuid.Nil {
+		middlewares.AbortScimRequest(c, 404, "Group not found")
+		return nil, false
+	}
+	return group, true
+}
+
+// saveGroup stores the group with its members and updates the access of the members that joined or left it
+func (s *Service) saveGroup(c *gin.Context, group *models.Group, members []uuid.UUID, status int) {
+	existing := models.Group{}
+	err := existing.FillByDisplayName(group.DisplayName)
+	if err != nil {
+		middlewares.AbortScimRequest(c, 500, err.Error())
+		return
+	}
+	if existing.ID != uuid.Nil && existing.ID != group.ID {
+		middlewares.AbortScimRequest(c, 409, "The displayName is already taken")
+		return
+	}
+
+	previousMembers := userIds(group.Members)
+	if group.ID == uuid.Nil {
+		err = group.Insert()
+	} else {
+		err = group.Save()
+	}
+	if err != nil {
+		middlewares.AbortScimRequest(c, 500, err.Error())
+		return
+	}
+
+	users, err := models.FindUsersByIds(members)
+	if err != nil {
+		middlewares.AbortScimRequest(c, 500, err.Error())
+		return
+	}
+	err = group.SetMembers(users)
+	if err != nil {
+		middlewares.AbortScimRequest(c, 500, err.Error())
+		return
+	}
+
+	// The display name can change the mapping of the current members as well
+	syncUserAccess(append(previousMembers, userIds(users)...))
+	if status == 201 {
+		c.Header("Location", "/scim/v2/Groups/"+group.ID.String())
+	}
+	scimJSON(c, status, groupResource(group))
+}
+
+func (s *Service) ListGroupsHandler(c *gin.Context) {
+	attribute, value, ok := parseFilter(c, "displayName", "externalId")
+	if !ok {
+		return
+	}
+	groups, err := models.ListGroups()
+	if err != nil {
+		middlewares.AbortScimRequest(c, 500, err.Error())
+		return
+	}
+
+	resources := make([]any, 0)
+	for i := range groups {
+		if attribute == "displayName" && groups[i].DisplayName != value {
+			continue
+		}
+		if attribute == "externalId" && groups[i].ExternalId != value {
+			continue
+		}
+		resources = append(resources, groupResource(&groups[i]))
+	}
+	listResponse(c, resources)
+}
+
+func (s *Service) GetGroupHandler(c *gin.Context) {
+	group, ok := s.findGroup(c)
+	if !ok {
+		return
+	}
+	scimJSON(c, 200, groupResource(group))
+}
+
+// CreateGroupHandler POST /scim/v2/Groups, the members get the namespace of the group mapping
+func (s *Service) CreateGroupHandler(c *gin.Context) {
+	requestBody := Group{}
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		middlewares.AbortScimRequest(c, 400, err.Error())
+		return
+	}
+	group := &models.Group{DisplayName: requestBody.DisplayName, ExternalId: requestBody.ExternalId}
+	s.saveGroup(c, group, memberIds(requestBody.Members), 201)
+}
+
+// ReplaceGroupHandler PUT /scim/v2/Groups/:id
+func (s *Service) ReplaceGroupHandler(c *gin.Context) {
+	requestBody := Group{}
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		middlewares.AbortScimRequest(c, 400, err.Error())
+		return
+	}
+	group, ok := s.findGroup(c)
+	if !ok {
+		return
+	}
+	group.DisplayName = requestBody.DisplayName
+	group.ExternalId = requestBody.ExternalId
+	s.saveGroup(c, group, memberIds(requestBody.Members), 200)
+}
+
+// PatchGroupHandler PATCH /scim/v2/Groups/:id adds, removes or replaces the members and renames the group
+func (s *Service) PatchGroupHandler(c *gin.Context) {
+	requestBody := PatchRequest{}
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		middlewares.AbortScimRequest(c, 400, err.Error())
+		return
+	}
+	group, ok := s.findGroup(c)
+	if !ok {
+		return
+	}
+
+	members := userIds(group.Members)
+	for _, operation := range requestBody.Operations {
+		op := strings.ToLower(operation.Op)
+
+		// `members[value eq "id"]` removes a single member without a value
+		if match := memberPathRegex.FindStringSubmatch(operation.Path); match != nil && op == "remove" {
+			if memberId, err := uuid.Parse(match[1]); err == nil {
+				members = slices.DeleteFunc(members, func(id uuid.UUID) bool { return id == memberId })
+			}
+			continue
+		}
+
+		values := map[string]json.RawMessage{}
+		if len(operation.Path) > 0 {
+			values[operation.Path] = operation.Value
+		} else if err = json.Unmarshal(operation.Value, &values); err != nil {
+			middlewares.AbortScimRequest(c, 400, "Invalid patch value")
+			return
+		}
+
+		for path, value := range values {
+			switch strings.ToLower(path) {
+			case "displayname":
+				_ = json.Unmarshal(value, &group.DisplayName)
+			case "externalid":
+				_ = json.Unmarshal(value, &group.ExternalId)
+			case "members":
+				patchMembers := make([]Member, 0)
+				if len(value) > 0 {
+					if err = json.Unmarshal(value, &patchMembers); err != nil {
+						middlewares.AbortScimRequest(c, 400, "Invalid members value")
+						return
+					}
+				}
+				members, ok = patchMemberIds(members, memberIds(patchMembers), op)
+				if !ok {
+					middlewares.AbortScimRequest(c, 400, "Unsupported patch operation "+operation.Op)
+					return
+				}
+			}
+		}
+	}
+	s.saveGroup(c, group, members, 200)
+}
+
+func patchMemberIds(members, patchMembers []uuid.UUID, op string) ([]uuid.UUID, bool) {
+	switch op {
+	case "add":
+		for _, id := range patchMembers {
+			if !slices.Contains(members, id) {
+				members = append(members, id)
+			}
+		}
+	case "remove":
+		// Removing the members attribute without a value removes every member
+		if len(patchMembers) == 0 {
+			return make([]uuid.UUID, 0), true
+		}
+		members = slices.DeleteFunc(members, func(id uuid.UUID) bool { return slices.Contains(patchMembers, id) })
+	case "replace":
+		members = patchMembers
+	default:
+		return members, false
+	}
+	return members, true
+}
+
+// DeleteGroupHandler DELETE /scim/v2/Groups/:id, the former members fall back to their remaining groups
+func (s *Service) DeleteGroupHandler(c *gin.Context) {
+	group, ok := s.findGroup(c)
+	if !ok {
+		return
+	}
+	members := userIds(group.Members)
+	err := group.Delete()
+	if err != nil {
+		middlewares.AbortScimRequest(c, 500, err.Error())
+		return
+	}
+	syncUserAccess(members)
+	c.Status(204)
+}
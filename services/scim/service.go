@@ -0,0 +1,162 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/alin-io/pkgstore/middlewares"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	UserSchema         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ListResponseSchema = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+)
+
+// filterRegex matches the equality filters that identity providers send to look up existing resources
+var filterRegex = regexp.MustCompile(`(?i)^\s*(\w+)\s+eq\s+"([^"]*)"\s*$`)
+
+// Service implements the SCIM 2.0 Users and Groups endpoints on top of the built-in user store
+type Service struct{}
+
+func NewService() *Service {
+	return &Service{}
+}
+
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations" binding:"required"`
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+func scimJSON(c *gin.Context, status int, obj any) {
+	c.Header("Content-Type", "application/scim+json")
+	c.JSON(status, obj)
+}
+
+// parseFilter reads an `attribute eq "value"` filter, an empty filter matches everything
+func parseFilter(c *gin.Context, attributes ...string) (attribute, value string, ok bool) {
+	filter := c.Query("filter")
+	if len(filter) == 0 {
+		return "", "", true
+	}
+	match := filterRegex.FindStringSubmatch(filter)
+	if match == nil {
+		middlewares.AbortScimRequest(c, 400, "Only the eq filter is supported")
+		return "", "", false
+	}
+	for _, supported := range attributes {
+		if strings.EqualFold(match[1], supported) {
+			return supported, match[2], true
+		}
+	}
+	middlewares.AbortScimRequest(c, 400, "Unsupported filter attribute "+match[1])
+	return "", "", false
+}
+
+// listResponse pages the resources with the 1-based startIndex and count parameters
+func listResponse(c *gin.Context, resources []any) {
+	startIndex, err := strconv.Atoi(c.DefaultQuery("startIndex", "1"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(c.DefaultQuery("count", strconv.Itoa(len(resources))))
+	if err != nil || count < 0 {
+		count = len(resources)
+	}
+
+	page := make([]any, 0)
+	if startIndex <= len(resources) {
+		page = resources[startIndex-1 : min(len(resources), startIndex-1+count)]
+	}
+	scimJSON(c, 200, ListResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: len(resources),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	})
+}
+
+func parseResourceId(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middlewares.AbortScimRequest(c, 404, "Resource not found")
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// parseBoolValue accepts JSON booleans and the "True"/"False" strings sent by some identity providers
+func parseBoolValue(value json.RawMessage) (bool, error) {
+	boolValue := false
+	if err := json.Unmarshal(value, &boolValue); err == nil {
+		return boolValue, nil
+	}
+	stringValue := ""
+	if err := json.Unmarshal(value, &stringValue); err != nil {
+		return false, err
+	}
+	boolValue, err := strconv.ParseBool(strings.ToLower(stringValue))
+	if err != nil {
+		return false, errors.New("invalid boolean value")
+	}
+	return boolValue, nil
+}
+
+// syncUserAccess reapplies the group mapping to the SCIM managed users after their groups changed
+func syncUserAccess(userIds []uuid.UUID) {
+	users, err := models.FindUsersByIds(userIds)
+	if err != nil {
+		log.Println("Unable to load the SCIM users: ", err)
+		return
+	}
+	for i := range users {
+		user := &users[i]
+		if !user.ScimManaged {
+			continue
+		}
+		groups, err := models.UserGroups(user.ID)
+		if err != nil {
+			log.Println("Unable to load the SCIM user groups: ", user.Name, err)
+			continue
+		}
+		middlewares.ApplyScimGroupMapping(user, groups)
+		if err = user.Save(); err != nil {
+			log.Println("Unable to update the SCIM user: ", user.Name, err)
+		}
+	}
+}
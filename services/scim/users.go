@@ -0,0 +1,264 @@
+package scim
+
+import (
+	"encoding/json"
+	"github.com/alin-io/pkgstore/middlewares"
+	"github.com/alin-io/pkgstore/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"strings"
+)
+
+type User struct {
+	Schemas    []string `json:"schemas"`
+	Id         string   `json:"id,omitempty"`
+	ExternalId string   `json:"externalId,omitempty"`
+	UserName   string   `json:"userName" binding:"required"`
+	Active     *bool    `json:"active,omitempty"`
+	Groups     []Member `json:"groups,omitempty"`
+	Meta       *Meta    `json:"meta,omitempty"`
+}
+
+func userResource(user *models.User) *User {
+	active := !user.Disabled
+	resource := &User{
+		Schemas:    []string{UserSchema},
+		Id:         user.ID.String(),
+		ExternalId: user.ExternalId,
+		UserName:   user.Name,
+		Active:     &active,
+		Groups:     make([]Member, 0),
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     "/scim/v2/Users/" + user.ID.String(),
+		},
+	}
+	groups, _ := models.UserGroups(user.ID)
+	for _, group := range groups {
+		resource.Groups = append(resource.Groups, Member{Value: group.ID.String(), Display: group.DisplayName})
+	}
+	return resource
+}
+
+// setActive disables the user and revokes its tokens right away, so the deprovisioned users lose access immediately
+func setActive(user *models.User, active bool) error {
+	wasDisabled := user.Disabled
+	user.Disabled = !active
+	if err := user.Save(); err != nil {
+		return err
+	}
+	if user.Disabled && !wasDisabled {
+		return user.RevokeTokens()
+	}
+	return nil
+}
+
+func (s *Service) findUser(c *gin.Context) (*models.User, bool) {
+	userId, ok := parseResourceId(c)
+	if !ok {
+		return nil, false
+	}
+	user := &models.User{}
+	err := user.FillById(userId)
+	if err != nil {
+		middlewares.AbortScimRequest(c, 500, err.Error())
+		return nil, false
+	}
+	if user.ID == uuid.Nil {
+		middlewares.AbortScimRequest(c, 404, "User not found")
+		return nil, false
+	}
+	return user, true
+}
+
+// renameUser changes the user name when it isn't taken by another user
+func renameUser(c *gin.Context, user *models.User, userName string) bool {
+	if len(userName) == 0 || userName == user.Name {
+		return true
+	}
+	existing := models.User{}
+	err := existing.FillByName(userName)
+	if err != nil {
+		middlewares.AbortScimRequest(c, 500, err.Error())
+		return false
+	}
+	if existing.ID != uuid.Nil {
+		middlewares.AbortScimRequest(c, 409, "The userName is already taken")
+		return false
+	}
+	user.Name = userName
+	return true
+}
+
+func (s *Service) ListUsersHandler(c *gin.Context) {
+	attribute, value, ok := parseFilter(c, "userName", "externalId")
+	if !ok {
+		return
+	}
+	users, err := models.ListUsers()
+	if err != nil {
+		middlewares.AbortScimRequest(c, 500, err.Error())
+		return
+	}
+
+	resources := make([]any, 0)
+	for i := range users {
+		if attribute == "userName" && !strings.EqualFold(users[i].Name, value) {
+			continue
+		}
+		if attribute == "externalId" && users[i].ExternalId != value {
+			continue
+		}
+		resources = append(resources, userResource(&users[i]))
+	}
+	listResponse(c, resources)
+}
+
+func (s *Service) GetUserHandler(c *gin.Context) {
+	user, ok := s.findUser(c)
+	if !ok {
+		return
+	}
+	scimJSON(c, 200, userResource(user))
+}
+
+// CreateUserHandler POST /scim/v2/Users provisions a user, its namespace comes from the group mapping
+func (s *Service) CreateUserHandler(c *gin.Context) {
+	requestBody := User{}
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		middlewares.AbortScimRequest(c, 400, err.Error())
+		return
+	}
+
+	user := &models.User{
+		Name:        requestBody.UserName,
+		ExternalId:  requestBody.ExternalId,
+		ScimManaged: true,
+		Disabled:    requestBody.Active != nil && !*requestBody.Active,
+	}
+	existing := models.User{}
+	err = existing.FillByName(user.Name)
+	if err != nil {
+		middlewares.AbortScimRequest(c, 500, err.Error())
+		return
+	}
+	if existing.ID != uuid.Nil {
+		middlewares.AbortScimRequest(c, 409, "The userName is already taken")
+		return
+	}
+
+	middlewares.ApplyScimGroupMapping(user, nil)
+	err = user.Insert()
+	if err != nil {
+		middlewares.AbortScimRequest(c, 500, err.Error())
+		return
+	}
+	c.Header("Location", "/scim/v2/Users/"+user.ID.String())
+	scimJSON(c, 201, userResource(user))
+}
+
+// ReplaceUserHandler PUT /scim/v2/Users/:id
+func (s *Service) ReplaceUserHandler(c *gin.Context) {
+	requestBody := User{}
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		middlewares.AbortScimRequest(c, 400, err.Error())
+		return
+	}
+	user, ok := s.findUser(c)
+	if !ok || !renameUser(c, user, requestBody.UserName) {
+		return
+	}
+
+	user.ExternalId = requestBody.ExternalId
+	s.saveUser(c, user, requestBody.Active == nil || *requestBody.Active)
+}
+
+// PatchUserHandler PATCH /scim/v2/Users/:id handles the active, userName and externalId attributes
+func (s *Service) PatchUserHandler(c *gin.Context) {
+	requestBody := PatchRequest{}
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		middlewares.AbortScimRequest(c, 400, err.Error())
+		return
+	}
+	user, ok := s.findUser(c)
+	if !ok {
+		return
+	}
+
+	active := !user.Disabled
+	for _, operation := range requestBody.Operations {
+		if !strings.EqualFold(operation.Op, "replace") && !strings.EqualFold(operation.Op, "add") {
+			middlewares.AbortScimRequest(c, 400, "Unsupported patch operation "+operation.Op)
+			return
+		}
+
+		values := map[string]json.RawMessage{}
+		if len(operation.Path) > 0 {
+			values[operation.Path] = operation.Value
+		} else if err = json.Unmarshal(operation.Value, &values); err != nil {
+			middlewares.AbortScimRequest(c, 400, "Invalid patch value")
+			return
+		}
+
+		for path, value := range values {
+			switch strings.ToLower(path) {
+			case "active":
+				if active, err = parseBoolValue(value); err != nil {
+					middlewares.AbortScimRequest(c, 400, "Invalid active value")
+					return
+				}
+			case "username":
+				userName := ""
+				_ = json.Unmarshal(value, &userName)
+				if !renameUser(c, user, userName) {
+					return
+				}
+			case "externalid":
+				_ = json.Unmarshal(value, &user.ExternalId)
+			}
+		}
+	}
+	s.saveUser(c, user, active)
+}
+
+func (s *Service) saveUser(c *gin.Context, user *models.User, active bool) {
+	if user.ScimManaged {
+		groups, err := models.UserGroups(user.ID)
+		if err != nil {
+			middlewares.AbortScimRequest(c, 500, err.Error())
+			return
+		}
+		middlewares.ApplyScimGroupMapping(user, groups)
+	}
+
+	err := setActive(user, active)
+	if err != nil {
+		middlewares.AbortScimRequest(c, 500, err.Error())
+		return
+	}
+	scimJSON(c, 200, userResource(user))
+}
+
+// DeleteUserHandler DELETE /scim/v2/Users/:id revokes the tokens of the user and removes it
+func (s *Service) DeleteUserHandler(c *gin.Context) {
+	user, ok := s.findUser(c)
+	if !ok {
+		return
+	}
+	err := user.RevokeTokens()
+	if err != nil {
+		middlewares.AbortScimRequest(c, 500, err.Error())
+		return
+	}
+	err = user.Delete()
+	if err != nil {
+		middlewares.AbortScimRequest(c, 500, err.Error())
+		return
+	}
+	c.Status(204)
+}